@@ -4,19 +4,25 @@ import (
 	"context"
 	"flag"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
 
+	"github.com/crisog/postgres-migrator/internal/config"
+	"github.com/crisog/postgres-migrator/internal/logging"
+	"github.com/crisog/postgres-migrator/internal/masking"
 	"github.com/crisog/postgres-migrator/pkg/validation"
 )
 
 func main() {
-	logger := log.New(os.Stdout, "", log.Ldate|log.Ltime)
-
 	sourceURL := flag.String("source", "", "Source database connection URL")
 	targetURL := flag.String("target", "", "Target database connection URL")
 	tableName := flag.String("table", "", "Optional: specific table name to validate (validates all tables if not specified)")
 	validateChecksum := flag.Bool("checksum", false, "Perform data checksum validation (slower)")
+	includeTables := flag.String("include-tables", "", "Optional: comma-separated table patterns that were migrated")
+	excludeTables := flag.String("exclude-tables", "", "Optional: comma-separated table patterns that were not migrated")
+	excludeTableData := flag.String("exclude-table-data", "", "Optional: comma-separated table patterns migrated without data")
+	maskingFile := flag.String("masking-file", "", "Optional: masking file used for the migration, masked columns are not compared")
+	logFormat := flag.String("log-format", config.LogFormatText, "Optional: log format, text or json")
 	flag.Parse()
 
 	if *sourceURL == "" || *targetURL == "" {
@@ -25,18 +31,41 @@ func main() {
 		os.Exit(1)
 	}
 
+	if *logFormat != config.LogFormatText && *logFormat != config.LogFormatJSON {
+		fmt.Printf("-log-format must be %q or %q, got: %s\n", config.LogFormatText, config.LogFormatJSON, *logFormat)
+		os.Exit(1)
+	}
+
+	logger := logging.New(os.Stdout, &config.Config{LogFormat: *logFormat})
 	ctx := context.Background()
 
 	if *tableName != "" {
-		logger.Printf("Starting validation for table '%s'...\n", *tableName)
+		logger.Info("validation started", logging.Table(*tableName))
 		if err := validation.ValidateTableMigrationFromURLs(ctx, *sourceURL, *targetURL, *tableName, *validateChecksum, logger); err != nil {
-			logger.Fatalf("❌ Validation failed: %v", err)
+			fatal(logger, "validation failed", err)
 		}
-		logger.Printf("\n✓ All validations passed for table '%s'\n", *tableName)
+		logger.Info("all validations passed", logging.Table(*tableName))
 	} else {
-		logger.Println("Starting validation for all tables...")
-		if err := validation.ValidateAllTablesFromURLs(ctx, *sourceURL, *targetURL, logger); err != nil {
-			logger.Fatalf("❌ Validation failed: %v", err)
+		logger.Info("validation started")
+		filter := validation.TableFilter{
+			Include:     config.ParseList(*includeTables),
+			Exclude:     config.ParseList(*excludeTables),
+			ExcludeData: config.ParseList(*excludeTableData),
+		}
+		if *maskingFile != "" {
+			rules, err := masking.Load(*maskingFile)
+			if err != nil {
+				fatal(logger, "failed to load masking file", err)
+			}
+			filter.MaskedColumns = rules.ColumnNames()
+		}
+		if err := validation.ValidateAllTablesFromURLsWithFilter(ctx, *sourceURL, *targetURL, filter, logger); err != nil {
+			fatal(logger, "validation failed", err)
 		}
 	}
 }
+
+func fatal(logger *slog.Logger, msg string, err error) {
+	logger.Error(msg, logging.Err(err))
+	os.Exit(1)
+}
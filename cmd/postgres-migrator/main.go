@@ -2,15 +2,23 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"net"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/crisog/postgres-migrator/internal/config"
+	"github.com/crisog/postgres-migrator/internal/logging"
+	"github.com/crisog/postgres-migrator/internal/metrics"
+	"github.com/crisog/postgres-migrator/internal/server"
+	"github.com/crisog/postgres-migrator/internal/tracing"
+	"github.com/crisog/postgres-migrator/internal/webhook"
 	"github.com/crisog/postgres-migrator/pkg/migration"
-	"github.com/crisog/postgres-migrator/pkg/validation"
 )
 
 func main() {
@@ -18,13 +26,46 @@ func main() {
 	os.Exit(exitCode)
 }
 
+const (
+	tracingFlushTimeout  = 10 * time.Second
+	webhookFlushTimeout  = 30 * time.Second
+	serveShutdownTimeout = 10 * time.Second
+)
+
+const usage = "Usage: postgres-migrator [migrate|plan|dump|restore|cutover|cluster|serve]"
+
 func run() int {
-	cfg, err := config.LoadFromEnv()
+	command := "migrate"
+	if len(os.Args) > 1 {
+		command = os.Args[1]
+	}
+
+	var cfg *config.Config
+	var err error
+	switch command {
+	case "migrate", "plan", "cutover":
+		cfg, err = config.LoadFromEnv()
+	case "dump":
+		cfg, err = config.LoadDumpConfigFromEnv()
+	case "restore":
+		cfg, err = config.LoadRestoreConfigFromEnv()
+	case "cluster":
+		cfg, err = config.LoadClusterConfigFromEnv()
+	case "serve":
+		cfg, err = config.LoadServeConfigFromEnv()
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown command: %s\n%s\n", command, usage)
+		return 1
+	}
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Configuration error: %v\n", err)
 		return 1
 	}
 
+	runID := logging.NewRunID()
+	baseLogger := logging.New(os.Stdout, cfg)
+	logger := baseLogger.With(logging.KeyRunID, runID)
+
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
@@ -32,28 +73,110 @@ func run() int {
 	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
 	go func() {
 		sig := <-sigChan
-		log.Printf("\nReceived signal: %v. Cancelling operation...\n", sig)
+		logger.Warn("received signal, cancelling operation", "signal", sig.String())
 		cancel()
 	}()
 
-	logger := log.New(os.Stdout, "", log.Ldate|log.Ltime)
-	skippedMigration, err := migration.Run(ctx, cfg, logger)
+	logger = logger.With("command", command)
+
+	if cfg.MetricsAddr != "" {
+		server, err := metrics.Serve(cfg.MetricsAddr, logger)
+		if err != nil {
+			logger.Error("failed to start metrics server", logging.Err(err))
+			return 1
+		}
+		defer server.Close()
+		logger.Info("serving metrics", "addr", cfg.MetricsAddr, "path", "/metrics")
+	}
+
+	// The jobs of the API server put their own run_id on their spans
+	tracingRunID := runID
+	if command == "serve" {
+		tracingRunID = ""
+	}
+	shutdownTracing, err := tracing.Setup(ctx, cfg, tracingRunID)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "%v\n", err)
+		logger.Error("failed to set up tracing", logging.Err(err))
 		return 1
 	}
-
-	if skippedMigration || cfg.ValidateAfter {
-		if skippedMigration {
-			logger.Println("\nRunning validation on existing target database...")
-		} else {
-			logger.Println("\nRunning post-migration validation...")
-		}
-		if err := validation.ValidateAllTablesFromURLs(ctx, cfg.SourceDatabaseURL, cfg.TargetDatabaseURL, logger); err != nil {
-			fmt.Fprintf(os.Stderr, "Validation failed: %v\n", err)
-			return 1
+	defer func() {
+		// The run context may be cancelled by now, which must not drop the last spans
+		flushCtx, cancel := context.WithTimeout(context.Background(), tracingFlushTimeout)
+		defer cancel()
+		if err := shutdownTracing(flushCtx); err != nil {
+			logger.Warn("failed to flush traces", logging.Err(err))
 		}
+	}()
+
+	if command == "serve" {
+		// Every job logs with its own run_id and command
+		return serve(ctx, cancel, cfg, logger, baseLogger)
 	}
 
+	notifier := webhook.New(cfg, runID, logger)
+	defer func() {
+		// Deliveries still retrying when the timeout hits are dropped rather than holding the exit
+		flushCtx, cancel := context.WithTimeout(context.Background(), webhookFlushTimeout)
+		defer cancel()
+		notifier.Close(flushCtx)
+	}()
+	ctx = webhook.NewContext(ctx, notifier)
+
+	recorder := metrics.New(runID)
+	defer recorder.Close()
+	ctx = metrics.NewContext(ctx, recorder)
+
+	logger.Info("command started")
+	start := time.Now()
+
+	switch command {
+	case "plan":
+		err = migration.Plan(ctx, cfg, logger)
+	case "dump":
+		err = migration.Dump(ctx, cfg, logger)
+	case "restore":
+		err = migration.Restore(ctx, cfg, logger)
+	case "cutover":
+		err = migration.Cutover(ctx, cfg, logger)
+	case "cluster":
+		err = migration.Cluster(ctx, cfg, logger)
+	default:
+		err = migration.Migrate(ctx, cfg, logger)
+	}
+	if err != nil {
+		logger.Error("command failed", logging.Duration(time.Since(start)), logging.Err(err))
+		return 1
+	}
+
+	logger.Info("command completed", logging.Duration(time.Since(start)))
+	return 0
+}
+
+func serve(ctx context.Context, cancel context.CancelFunc, cfg *config.Config, logger, jobLogger *slog.Logger) int {
+	listener, err := net.Listen("tcp", cfg.ServeAddr)
+	if err != nil {
+		logger.Error("failed to start API listener", logging.Err(err))
+		return 1
+	}
+
+	srv := server.New(cfg, jobLogger)
+	httpServer := &http.Server{Handler: srv.Handler(), ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := httpServer.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Error("API server failed", logging.Err(err))
+			cancel()
+		}
+	}()
+	logger.Info("serving API", "addr", cfg.ServeAddr, "concurrency", cfg.ServeConcurrency)
+
+	// Returns once the signal handler cancels ctx and the running jobs have stopped
+	srv.Run(ctx)
+
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), serveShutdownTimeout)
+	defer cancelShutdown()
+	if err := httpServer.Shutdown(shutdownCtx); err != nil {
+		httpServer.Close()
+	}
+	logger.Info("API server stopped")
 	return 0
 }
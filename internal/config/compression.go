@@ -0,0 +1,107 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	CompressionGzip = "gzip"
+	CompressionLZ4  = "lz4"
+	CompressionZstd = "zstd"
+	CompressionNone = "none"
+)
+
+var compressionLevelRanges = map[string][2]int{
+	CompressionGzip: {1, 9},
+	CompressionLZ4:  {1, 12},
+	CompressionZstd: {1, 22},
+}
+
+type CompressionSpec struct {
+	Method string
+	Level  int
+}
+
+// ParseCompression accepts "none", a method with an optional level such as "zstd:9",
+// or a bare gzip level for compatibility with pg_dump -Z. An empty spec keeps
+// pg_dump's default.
+func ParseCompression(spec string) (CompressionSpec, error) {
+	spec = strings.ToLower(strings.TrimSpace(spec))
+	if spec == "" {
+		return CompressionSpec{}, nil
+	}
+
+	if level, err := strconv.Atoi(spec); err == nil {
+		if level == 0 {
+			return CompressionSpec{Method: CompressionNone}, nil
+		}
+		spec = fmt.Sprintf("%s:%d", CompressionGzip, level)
+	}
+
+	method, levelStr, hasLevel := strings.Cut(spec, ":")
+	if method == CompressionNone {
+		if hasLevel {
+			return CompressionSpec{}, fmt.Errorf("COMPRESSION %q: none does not take a level", spec)
+		}
+		return CompressionSpec{Method: CompressionNone}, nil
+	}
+
+	levelRange, ok := compressionLevelRanges[method]
+	if !ok {
+		return CompressionSpec{}, fmt.Errorf("COMPRESSION %q: method must be gzip, lz4, zstd or none", spec)
+	}
+
+	result := CompressionSpec{Method: method}
+	if hasLevel {
+		levelStr = strings.TrimPrefix(levelStr, "level=")
+		level, err := strconv.Atoi(levelStr)
+		if err != nil || level < levelRange[0] || level > levelRange[1] {
+			return CompressionSpec{}, fmt.Errorf("COMPRESSION %q: %s level must be between %d and %d", spec, method, levelRange[0], levelRange[1])
+		}
+		result.Level = level
+	}
+
+	return result, nil
+}
+
+func (c CompressionSpec) IsDefault() bool {
+	return c.Method == ""
+}
+
+// Arg returns the pg_dump --compress argument. gzip and none use the plain integer
+// form so they keep working with pg_dump releases older than 16.
+func (c CompressionSpec) Arg() string {
+	switch {
+	case c.Method == CompressionNone:
+		return "--compress=0"
+	case c.Method == CompressionGzip && c.Level > 0:
+		return fmt.Sprintf("--compress=%d", c.Level)
+	case c.Level > 0:
+		return fmt.Sprintf("--compress=%s:%d", c.Method, c.Level)
+	default:
+		return "--compress=" + c.Method
+	}
+}
+
+// MinPgDumpVersion is the oldest pg_dump major version that understands the spec
+func (c CompressionSpec) MinPgDumpVersion() int {
+	if c.Method == CompressionLZ4 || c.Method == CompressionZstd || (c.Method == CompressionGzip && c.Level == 0) {
+		return 16
+	}
+	return 0
+}
+
+func (c CompressionSpec) String() string {
+	switch {
+	case c.IsDefault():
+		return "gzip (pg_dump default level)"
+	case c.Method == CompressionNone:
+		return "none"
+	case c.Level > 0:
+		return fmt.Sprintf("%s level %d", c.Method, c.Level)
+	default:
+		return c.Method + " (default level)"
+	}
+}
@@ -2,9 +2,15 @@ package config
 
 import (
 	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
 	"os"
+	"slices"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 )
 
 type Config struct {
@@ -14,22 +20,223 @@ type Config struct {
 	NoOwner           bool
 	NoACL             bool
 	ValidateAfter     bool
+	LogFormat         string
+	LogLevel          string
+	MetricsAddr       string
+	OTLPEndpoint      string
+	WebhookURLs       []string
+	WebhookSecret     string
+	ServeAddr         string
+	ServeConcurrency  int
+	ServeToken        string
+	ServeJobRetention time.Duration
+	ServeJobLogLines  int
 	ExcludeSchemas    []string
+	IncludeTables     []string
+	ExcludeTables     []string
+	ExcludeTableData  []string
 	SkipVersionCheck  bool
 	DataOnly          bool
+	Stream            bool
+	DumpFormat        string
+	Engine            string
+	ChunkRows         int
+	ChunkRetries      int
+	Compression       string
+	StateFile         string
+	Resume            bool
+	ArchivePath       string
+	WorkDir           string
+	SkipDiskCheck     bool
+	ArchiveURI        string
+	S3Endpoint        string
+	S3Region          string
+	S3AccessKeyID     string
+	S3SecretAccessKey string
+	S3Insecure        bool
+
+	EncryptionPassphrase string
+	EncryptionRecipient  string
+	EncryptionIdentity   string
+
+	Online                bool
+	PublicationName       string
+	SubscriptionName      string
+	SubscriptionSourceURL string
+	CutoverTriggerFile    string
+	CutoverTimeout        time.Duration
+
+	SubsetFile  string
+	MaskingFile string
+
+	ParallelDatabases int
+	ExcludeDatabases  []string
+
+	MigrateGlobals         bool
+	GlobalsSkipSuperuser   bool
+	GlobalsSkipTablespaces bool
+	GlobalsPasswords       string
+
+	// SchemaOnly is not read from the environment, online mode sets it to copy the
+	// schema before replication fills in the data
+	SchemaOnly bool
+	// Section limits pg_restore to one archive section, the copy engine restores
+	// pre-data before copying rows and post-data after. Not read from the environment.
+	Section string
 }
 
+const (
+	DumpFormatCustom    = "custom"
+	DumpFormatDirectory = "directory"
+)
+
+const (
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+)
+
+const (
+	// GlobalsPasswordsCopy copies the role password hashes
+	GlobalsPasswordsCopy = "copy"
+	// GlobalsPasswordsPlaceholder gives new login roles a random password instead
+	GlobalsPasswordsPlaceholder = "placeholder"
+)
+
+const (
+	// EnginePgDump moves data with pg_dump and pg_restore
+	EnginePgDump = "pg_dump"
+	// EngineCopy creates the schema with pg_dump but copies rows with COPY over pgx
+	EngineCopy = "copy"
+)
+
 func LoadFromEnv() (*Config, error) {
-	cfg := &Config{
-		SourceDatabaseURL: os.Getenv("SOURCE_DATABASE_URL"),
-		TargetDatabaseURL: os.Getenv("TARGET_DATABASE_URL"),
-		ParallelJobs:      getEnvAsIntOrDefault("PARALLEL_JOBS", 1),
-		NoOwner:           os.Getenv("NO_OWNER") != "false",
-		NoACL:             os.Getenv("NO_ACL") != "false",
-		ValidateAfter:     os.Getenv("VALIDATE_AFTER") != "false",
-		ExcludeSchemas:    parseCommaSeparatedList(os.Getenv("EXCLUDE_SCHEMAS")),
-		SkipVersionCheck:  os.Getenv("SKIP_VERSION_CHECK") == "true",
-		DataOnly:          os.Getenv("DATA_ONLY") == "true",
+	cfg := fromEnv()
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+func LoadDumpConfigFromEnv() (*Config, error) {
+	cfg := fromEnv()
+
+	if err := cfg.ValidateDump(); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+func LoadRestoreConfigFromEnv() (*Config, error) {
+	cfg := fromEnv()
+
+	if err := cfg.ValidateRestore(); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+func LoadClusterConfigFromEnv() (*Config, error) {
+	cfg := fromEnv()
+
+	if err := cfg.ValidateCluster(); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+func LoadServeConfigFromEnv() (*Config, error) {
+	cfg := fromEnv()
+
+	if err := cfg.ValidateServe(); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// jobSettings are the settings a job submitted to the API server may supply. Paths on
+// the server, credentials of other services and the server's own settings stay with the
+// server's environment.
+var jobSettings = map[string]bool{
+	"SOURCE_DATABASE_URL":      true,
+	"TARGET_DATABASE_URL":      true,
+	"SUBSCRIPTION_SOURCE_URL":  true,
+	"PARALLEL_JOBS":            true,
+	"NO_OWNER":                 true,
+	"NO_ACL":                   true,
+	"VALIDATE_AFTER":           true,
+	"LOG_LEVEL":                true,
+	"EXCLUDE_SCHEMAS":          true,
+	"INCLUDE_TABLES":           true,
+	"EXCLUDE_TABLES":           true,
+	"EXCLUDE_TABLE_DATA":       true,
+	"SKIP_VERSION_CHECK":       true,
+	"DATA_ONLY":                true,
+	"STREAM":                   true,
+	"DUMP_FORMAT":              true,
+	"ENGINE":                   true,
+	"CHUNK_ROWS":               true,
+	"CHUNK_RETRIES":            true,
+	"COMPRESSION":              true,
+	"SKIP_DISK_CHECK":          true,
+	"ONLINE":                   true,
+	"PUBLICATION_NAME":         true,
+	"SUBSCRIPTION_NAME":        true,
+	"CUTOVER_TIMEOUT":          true,
+	"MIGRATE_GLOBALS":          true,
+	"GLOBALS_SKIP_SUPERUSER":   true,
+	"GLOBALS_SKIP_TABLESPACES": true,
+	"GLOBALS_PASSWORDS":        true,
+}
+
+// jobConnectionSettings are taken from the job alone as soon as it supplies one of them,
+// so a job cannot pair a database of its choosing with one the server holds credentials for
+var jobConnectionSettings = []string{"SOURCE_DATABASE_URL", "TARGET_DATABASE_URL", "SUBSCRIPTION_SOURCE_URL"}
+
+// LoadJobConfig returns the configuration of a job submitted to the API server. settings
+// are keyed by environment variable name and take precedence over the server's own
+// environment, which supplies the defaults. Only jobSettings may be given.
+func LoadJobConfig(settings map[string]string) (*Config, error) {
+	ownConnections := false
+	for _, key := range jobConnectionSettings {
+		if _, ok := settings[key]; ok {
+			ownConnections = true
+		}
+	}
+
+	read := make(map[string]bool)
+	cfg := load(func(key string) string {
+		read[key] = true
+		if value, ok := settings[key]; ok {
+			return value
+		}
+		if ownConnections && slices.Contains(jobConnectionSettings, key) {
+			return ""
+		}
+		return os.Getenv(key)
+	})
+
+	var unknown, forbidden []string
+	for key := range settings {
+		switch {
+		case !read[key]:
+			unknown = append(unknown, key)
+		case !jobSettings[key]:
+			forbidden = append(forbidden, key)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return nil, fmt.Errorf("unknown settings: %s", strings.Join(unknown, ", "))
+	}
+	if len(forbidden) > 0 {
+		sort.Strings(forbidden)
+		return nil, fmt.Errorf("settings that only the server's environment may set: %s", strings.Join(forbidden, ", "))
 	}
 
 	if err := cfg.Validate(); err != nil {
@@ -39,6 +246,78 @@ func LoadFromEnv() (*Config, error) {
 	return cfg, nil
 }
 
+func fromEnv() *Config {
+	return load(os.Getenv)
+}
+
+// load reads every setting through getenv, so API jobs can supply their own values
+func load(getenv func(string) string) *Config {
+	return &Config{
+		SourceDatabaseURL: getenv("SOURCE_DATABASE_URL"),
+		TargetDatabaseURL: getenv("TARGET_DATABASE_URL"),
+		ParallelJobs:      getEnvAsIntOrDefault(getenv, "PARALLEL_JOBS", 1),
+		NoOwner:           getenv("NO_OWNER") != "false",
+		NoACL:             getenv("NO_ACL") != "false",
+		ValidateAfter:     getenv("VALIDATE_AFTER") != "false",
+		LogFormat:         getEnvOrDefault(getenv, "LOG_FORMAT", LogFormatText),
+		LogLevel:          getEnvOrDefault(getenv, "LOG_LEVEL", "info"),
+		MetricsAddr:       getenv("METRICS_ADDR"),
+		OTLPEndpoint:      getenv("OTEL_EXPORTER_OTLP_ENDPOINT"),
+		WebhookURLs:       ParseList(getenv("WEBHOOK_URLS")),
+		WebhookSecret:     getenv("WEBHOOK_SECRET"),
+		ServeAddr:         getEnvOrDefault(getenv, "SERVE_ADDR", ":8080"),
+		ServeConcurrency:  getEnvAsIntOrDefault(getenv, "SERVE_CONCURRENCY", 1),
+		ServeToken:        getenv("SERVE_TOKEN"),
+		ServeJobRetention: getEnvAsDurationOrDefault(getenv, "SERVE_JOB_RETENTION", 24*time.Hour),
+		ServeJobLogLines:  getEnvAsIntOrDefault(getenv, "SERVE_JOB_LOG_LINES", 10000),
+		ExcludeSchemas:    ParseList(getenv("EXCLUDE_SCHEMAS")),
+		IncludeTables:     ParseList(getenv("INCLUDE_TABLES")),
+		ExcludeTables:     ParseList(getenv("EXCLUDE_TABLES")),
+		ExcludeTableData:  ParseList(getenv("EXCLUDE_TABLE_DATA")),
+		SkipVersionCheck:  getenv("SKIP_VERSION_CHECK") == "true",
+		DataOnly:          getenv("DATA_ONLY") == "true",
+		Stream:            getenv("STREAM") == "true",
+		DumpFormat:        getEnvOrDefault(getenv, "DUMP_FORMAT", DumpFormatCustom),
+		Engine:            getEnvOrDefault(getenv, "ENGINE", EnginePgDump),
+		ChunkRows:         getEnvAsIntOrDefault(getenv, "CHUNK_ROWS", 1000000),
+		ChunkRetries:      getEnvAsIntOrDefault(getenv, "CHUNK_RETRIES", 3),
+		Compression:       getenv("COMPRESSION"),
+		StateFile:         getenv("STATE_FILE"),
+		Resume:            getenv("RESUME") == "true",
+		ArchivePath:       getenv("ARCHIVE_PATH"),
+		WorkDir:           getenv("WORK_DIR"),
+		SkipDiskCheck:     getenv("SKIP_DISK_CHECK") == "true",
+		ArchiveURI:        getenv("ARCHIVE_URI"),
+		S3Endpoint:        getEnvOrDefault(getenv, "S3_ENDPOINT", "s3.amazonaws.com"),
+		S3Region:          getenv("S3_REGION"),
+		S3AccessKeyID:     getenv("S3_ACCESS_KEY_ID"),
+		S3SecretAccessKey: getenv("S3_SECRET_ACCESS_KEY"),
+		S3Insecure:        getenv("S3_INSECURE") == "true",
+
+		EncryptionPassphrase: getenv("ENCRYPTION_PASSPHRASE"),
+		EncryptionRecipient:  getenv("ENCRYPTION_RECIPIENT"),
+		EncryptionIdentity:   getenv("ENCRYPTION_IDENTITY"),
+
+		Online:                getenv("ONLINE") == "true",
+		PublicationName:       getEnvOrDefault(getenv, "PUBLICATION_NAME", "postgres_migrator"),
+		SubscriptionName:      getEnvOrDefault(getenv, "SUBSCRIPTION_NAME", "postgres_migrator"),
+		SubscriptionSourceURL: getenv("SUBSCRIPTION_SOURCE_URL"),
+		CutoverTriggerFile:    getenv("CUTOVER_TRIGGER_FILE"),
+		CutoverTimeout:        getEnvAsDurationOrDefault(getenv, "CUTOVER_TIMEOUT", 5*time.Minute),
+
+		SubsetFile:  getenv("SUBSET_FILE"),
+		MaskingFile: getenv("MASKING_FILE"),
+
+		ParallelDatabases: getEnvAsIntOrDefault(getenv, "PARALLEL_DATABASES", 1),
+		ExcludeDatabases:  ParseList(getenv("EXCLUDE_DATABASES")),
+
+		MigrateGlobals:         getenv("MIGRATE_GLOBALS") == "true",
+		GlobalsSkipSuperuser:   getenv("GLOBALS_SKIP_SUPERUSER") == "true",
+		GlobalsSkipTablespaces: getenv("GLOBALS_SKIP_TABLESPACES") == "true",
+		GlobalsPasswords:       getEnvOrDefault(getenv, "GLOBALS_PASSWORDS", GlobalsPasswordsCopy),
+	}
+}
+
 func (c *Config) Validate() error {
 	if c.SourceDatabaseURL == "" {
 		return fmt.Errorf("SOURCE_DATABASE_URL is required")
@@ -48,15 +327,288 @@ func (c *Config) Validate() error {
 		return fmt.Errorf("TARGET_DATABASE_URL is required")
 	}
 
+	// The temporary dump of a migration never leaves the machine
+	if c.ArchiveURI != "" {
+		return fmt.Errorf("ARCHIVE_URI is only used by the dump and restore commands, migrate does not upload its archive")
+	}
+
+	if c.EncryptionRecipient != "" && c.EncryptionIdentity == "" {
+		return fmt.Errorf("ENCRYPTION_RECIPIENT requires ENCRYPTION_IDENTITY to restore the archive in the same run")
+	}
+
+	if c.CutoverTimeout <= 0 {
+		return fmt.Errorf("CUTOVER_TIMEOUT must be a positive duration, got: %s", c.CutoverTimeout)
+	}
+
+	if c.SubsetFile != "" {
+		if _, err := os.Stat(c.SubsetFile); err != nil {
+			return fmt.Errorf("SUBSET_FILE is not accessible: %w", err)
+		}
+		if c.Online || c.DataOnly || c.Stream || c.StateFile != "" {
+			return fmt.Errorf("SUBSET_FILE cannot be combined with ONLINE, DATA_ONLY, STREAM or STATE_FILE")
+		}
+	}
+
+	switch c.Engine {
+	case "", EnginePgDump:
+	case EngineCopy:
+		if c.Online || c.Stream || c.StateFile != "" {
+			return fmt.Errorf("ENGINE=%s cannot be combined with ONLINE, STREAM or STATE_FILE", EngineCopy)
+		}
+	default:
+		return fmt.Errorf("ENGINE must be %q or %q, got: %s", EnginePgDump, EngineCopy, c.Engine)
+	}
+
+	if c.ChunkRows < 0 {
+		return fmt.Errorf("CHUNK_ROWS must not be negative, got: %d", c.ChunkRows)
+	}
+
+	if c.ChunkRetries < 0 {
+		return fmt.Errorf("CHUNK_RETRIES must not be negative, got: %d", c.ChunkRetries)
+	}
+
+	if c.MaskingFile != "" {
+		if _, err := os.Stat(c.MaskingFile); err != nil {
+			return fmt.Errorf("MASKING_FILE is not accessible: %w", err)
+		}
+		if c.Online || c.Stream || c.StateFile != "" {
+			return fmt.Errorf("MASKING_FILE cannot be combined with ONLINE, STREAM or STATE_FILE, masked rows are copied by the migrator itself")
+		}
+	}
+
+	switch c.GlobalsPasswords {
+	case "", GlobalsPasswordsCopy, GlobalsPasswordsPlaceholder:
+	default:
+		return fmt.Errorf("GLOBALS_PASSWORDS must be %q or %q, got: %s", GlobalsPasswordsCopy, GlobalsPasswordsPlaceholder, c.GlobalsPasswords)
+	}
+
+	if c.Online {
+		if c.DataOnly {
+			return fmt.Errorf("ONLINE cannot be combined with DATA_ONLY, replication copies the data itself")
+		}
+		if c.Stream || c.StateFile != "" {
+			return fmt.Errorf("ONLINE cannot be combined with STREAM or STATE_FILE, an interrupted online run resumes from the existing subscription")
+		}
+	}
+
+	return c.validateOptions()
+}
+
+func (c *Config) ValidateDump() error {
+	if c.SourceDatabaseURL == "" {
+		return fmt.Errorf("SOURCE_DATABASE_URL is required")
+	}
+
+	if c.ArchivePath == "" && c.ArchiveURI == "" {
+		return fmt.Errorf("ARCHIVE_PATH or ARCHIVE_URI is required")
+	}
+
+	if c.Stream {
+		return fmt.Errorf("STREAM cannot be used when writing an archive")
+	}
+
+	return c.validateOptions()
+}
+
+func (c *Config) ValidateRestore() error {
+	if c.TargetDatabaseURL == "" {
+		return fmt.Errorf("TARGET_DATABASE_URL is required")
+	}
+
+	if c.ArchivePath == "" && c.ArchiveURI == "" {
+		return fmt.Errorf("ARCHIVE_PATH or ARCHIVE_URI is required")
+	}
+
+	if c.Stream {
+		return fmt.Errorf("STREAM cannot be used when restoring an archive")
+	}
+
+	if c.StateFile != "" && c.ArchiveURI != "" && c.ArchivePath == "" {
+		return fmt.Errorf("STATE_FILE with ARCHIVE_URI requires ARCHIVE_PATH, so the fetched archive is kept for resuming")
+	}
+
+	return c.validateOptions()
+}
+
+// ValidateCluster checks a cluster run, whose URLs point at the servers rather than at a
+// database. Options that only make sense for one database are rejected.
+func (c *Config) ValidateCluster() error {
+	if c.ParallelDatabases < 1 {
+		return fmt.Errorf("PARALLEL_DATABASES must be at least 1, got: %d", c.ParallelDatabases)
+	}
+
+	// Every database would be uploaded to the same object
+	if c.Online || c.StateFile != "" || c.SubsetFile != "" || c.ArchiveURI != "" {
+		return fmt.Errorf("cluster migration cannot be combined with ONLINE, STATE_FILE, SUBSET_FILE or ARCHIVE_URI")
+	}
+
+	return c.Validate()
+}
+
+// ValidateServe checks the API server's settings. Jobs run with the server's database
+// credentials, so the API must not be reachable from other hosts without SERVE_TOKEN.
+func (c *Config) ValidateServe() error {
+	host, _, err := net.SplitHostPort(c.ServeAddr)
+	if err != nil {
+		return fmt.Errorf("SERVE_ADDR must be host:port or :port, got: %s", c.ServeAddr)
+	}
+
+	if c.ServeToken == "" && !isLoopback(host) {
+		return fmt.Errorf("SERVE_TOKEN is required unless SERVE_ADDR is a loopback address such as 127.0.0.1:8080, got: %s", c.ServeAddr)
+	}
+
+	if c.ServeConcurrency < 1 {
+		return fmt.Errorf("SERVE_CONCURRENCY must be at least 1, got: %d", c.ServeConcurrency)
+	}
+
+	if c.ServeJobRetention <= 0 {
+		return fmt.Errorf("SERVE_JOB_RETENTION must be positive, got: %s", c.ServeJobRetention)
+	}
+
+	if c.ServeJobLogLines < 1 {
+		return fmt.Errorf("SERVE_JOB_LOG_LINES must be at least 1, got: %d", c.ServeJobLogLines)
+	}
+
+	return c.validateOptions()
+}
+
+func (c *Config) validateOptions() error {
 	if c.ParallelJobs < 1 {
 		return fmt.Errorf("PARALLEL_JOBS must be at least 1, got: %d", c.ParallelJobs)
 	}
 
+	switch c.LogFormat {
+	case "", LogFormatText, LogFormatJSON:
+	default:
+		return fmt.Errorf("LOG_FORMAT must be %q or %q, got: %s", LogFormatText, LogFormatJSON, c.LogFormat)
+	}
+
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.LogLevel)); c.LogLevel != "" && err != nil {
+		return fmt.Errorf("LOG_LEVEL must be debug, info, warn or error, got: %s", c.LogLevel)
+	}
+
+	if c.MetricsAddr != "" {
+		if _, _, err := net.SplitHostPort(c.MetricsAddr); err != nil {
+			return fmt.Errorf("METRICS_ADDR must be host:port or :port, got: %s", c.MetricsAddr)
+		}
+	}
+
+	if c.OTLPEndpoint != "" {
+		if endpoint, err := url.Parse(c.OTLPEndpoint); err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") || endpoint.Host == "" {
+			return fmt.Errorf("OTEL_EXPORTER_OTLP_ENDPOINT must be an http or https URL, got: %s", c.OTLPEndpoint)
+		}
+	}
+
+	for _, webhook := range c.WebhookURLs {
+		if u, err := url.Parse(webhook); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("WEBHOOK_URLS must be http or https URLs, got: %s", webhook)
+		}
+	}
+	if len(c.WebhookURLs) > 0 && c.WebhookSecret == "" {
+		return fmt.Errorf("WEBHOOK_SECRET is required to sign webhook payloads")
+	}
+
+	switch c.DumpFormat {
+	case "", DumpFormatCustom, DumpFormatDirectory:
+	default:
+		return fmt.Errorf("DUMP_FORMAT must be %q or %q, got: %s", DumpFormatCustom, DumpFormatDirectory, c.DumpFormat)
+	}
+
+	if c.WorkDir != "" {
+		info, err := os.Stat(c.WorkDir)
+		if err != nil {
+			return fmt.Errorf("WORK_DIR is not accessible: %w", err)
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("WORK_DIR must be a directory, got: %s", c.WorkDir)
+		}
+	}
+
+	if _, err := ParseCompression(c.Compression); err != nil {
+		return err
+	}
+
+	if c.Stream && c.DumpFormat == DumpFormatDirectory {
+		return fmt.Errorf("STREAM cannot be combined with DUMP_FORMAT=%s", DumpFormatDirectory)
+	}
+
+	if c.ArchiveURI != "" {
+		if !strings.HasPrefix(c.ArchiveURI, "s3://") {
+			return fmt.Errorf("ARCHIVE_URI must be an s3://bucket/key URI, got: %s", c.ArchiveURI)
+		}
+		if c.DumpFormat == DumpFormatDirectory {
+			return fmt.Errorf("ARCHIVE_URI requires DUMP_FORMAT=%s, a directory archive cannot be uploaded as one object", DumpFormatCustom)
+		}
+	}
+
+	if c.EncryptionPassphrase != "" && c.EncryptionRecipient != "" {
+		return fmt.Errorf("ENCRYPTION_PASSPHRASE and ENCRYPTION_RECIPIENT cannot be combined")
+	}
+
+	if c.Encrypted() {
+		if c.DumpFormat == DumpFormatDirectory {
+			return fmt.Errorf("encryption requires DUMP_FORMAT=%s", DumpFormatCustom)
+		}
+		if c.StateFile != "" {
+			return fmt.Errorf("encryption cannot be combined with STATE_FILE, encrypted archives can only be restored in one pass")
+		}
+	}
+
+	if c.Resume && c.StateFile == "" {
+		return fmt.Errorf("RESUME requires STATE_FILE to be set")
+	}
+
+	if c.Stream && c.StateFile != "" {
+		return fmt.Errorf("STREAM cannot be combined with STATE_FILE, resuming needs a kept dump archive")
+	}
+
 	return nil
 }
 
-func getEnvAsIntOrDefault(key string, defaultValue int) int {
-	valueStr := os.Getenv(key)
+func isLoopback(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// CompressionSpec returns the parsed COMPRESSION setting, which Validate has already checked
+func (c *Config) CompressionSpec() CompressionSpec {
+	spec, _ := ParseCompression(c.Compression)
+	return spec
+}
+
+// Encrypted reports whether archives written by the dump are encrypted
+func (c *Config) Encrypted() bool {
+	return c.EncryptionPassphrase != "" || c.EncryptionRecipient != ""
+}
+
+// SlogLevel returns the LOG_LEVEL setting, which validation has already checked
+func (c *Config) SlogLevel() slog.Level {
+	var level slog.Level
+	if c.LogLevel != "" {
+		_ = level.UnmarshalText([]byte(c.LogLevel))
+	}
+	return level
+}
+
+// CopyEngine reports whether rows are copied with COPY instead of pg_restore. Masking
+// rewrites rows in flight, so it always uses the copy engine.
+func (c *Config) CopyEngine() bool {
+	return c.Engine == EngineCopy || c.MaskingFile != ""
+}
+
+func getEnvOrDefault(getenv func(string) string, key, defaultValue string) string {
+	if value := getenv(key); value != "" {
+		return value
+	}
+	return defaultValue
+}
+
+func getEnvAsIntOrDefault(getenv func(string) string, key string, defaultValue int) int {
+	valueStr := getenv(key)
 	if valueStr == "" {
 		return defaultValue
 	}
@@ -69,7 +621,22 @@ func getEnvAsIntOrDefault(key string, defaultValue int) int {
 	return value
 }
 
-func parseCommaSeparatedList(value string) []string {
+func getEnvAsDurationOrDefault(getenv func(string) string, key string, defaultValue time.Duration) time.Duration {
+	valueStr := getenv(key)
+	if valueStr == "" {
+		return defaultValue
+	}
+
+	value, err := time.ParseDuration(valueStr)
+	if err != nil {
+		return defaultValue
+	}
+
+	return value
+}
+
+// ParseList splits a comma-separated setting, dropping blank items
+func ParseList(value string) []string {
 	if value == "" {
 		return nil
 	}
@@ -0,0 +1,113 @@
+package copier
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/crisog/postgres-migrator/internal/database"
+	"github.com/jackc/pgx/v5"
+)
+
+// Chunk is a slice of one table that is copied with a single COPY
+type Chunk struct {
+	Table database.TableInfo
+	// Where selects the chunk's rows, empty for a table copied in one piece
+	Where string
+	// Index and Count number the chunk among its table's chunks, starting at 1
+	Index, Count int
+}
+
+// planChunks splits a table into about EstimatedRows/chunkRows ranges. Tables with a
+// single integer primary key are split by key range, others by ctid page range, which
+// PostgreSQL 14+ reads with a TID range scan.
+func planChunks(ctx context.Context, tx pgx.Tx, table database.TableInfo, chunkRows int64) ([]Chunk, error) {
+	if chunkRows <= 0 || table.EstimatedRows <= chunkRows {
+		return []Chunk{{Table: table, Index: 1, Count: 1}}, nil
+	}
+	count := (table.EstimatedRows + chunkRows - 1) / chunkRows
+
+	name := qualifiedName(table)
+
+	var key string
+	err := tx.QueryRow(ctx, `
+		SELECT a.attname
+		FROM pg_index i
+		JOIN pg_attribute a ON a.attrelid = i.indrelid AND a.attnum = i.indkey[0]
+		WHERE i.indrelid = $1::regclass AND i.indisprimary AND i.indnkeyatts = 1
+			AND a.atttypid IN ('int2'::regtype, 'int4'::regtype, 'int8'::regtype)`, name).Scan(&key)
+	switch {
+	case errors.Is(err, pgx.ErrNoRows):
+		return planPageChunks(ctx, tx, table, count)
+	case err != nil:
+		return nil, fmt.Errorf("failed to look up primary key of %s: %w", name, err)
+	}
+
+	column := pgx.Identifier{key}.Sanitize()
+
+	var low, high *int64
+	if err := tx.QueryRow(ctx, fmt.Sprintf("SELECT min(%s), max(%s) FROM %s", column, column, name)).Scan(&low, &high); err != nil {
+		return nil, fmt.Errorf("failed to read key range of %s: %w", name, err)
+	}
+	if low == nil {
+		return []Chunk{{Table: table, Index: 1, Count: 1}}, nil
+	}
+
+	// Ranges are sized by key span, so gaps in the keys make some chunks smaller. A span
+	// that overflows int64 falls back to page ranges.
+	span := *high - *low
+	if span < 0 {
+		return planPageChunks(ctx, tx, table, count)
+	}
+	width := span/count + 1
+	var chunks []Chunk
+	for start := *low; start <= *high; start += width {
+		end := start + width - 1
+		if end > *high || end < start {
+			end = *high
+		}
+		chunks = append(chunks, Chunk{Table: table, Where: fmt.Sprintf("%s BETWEEN %d AND %d", column, start, end)})
+		if end == *high {
+			break
+		}
+	}
+	return numberChunks(chunks), nil
+}
+
+func planPageChunks(ctx context.Context, tx pgx.Tx, table database.TableInfo, count int64) ([]Chunk, error) {
+	var pages int64
+	if err := tx.QueryRow(ctx, "SELECT relpages FROM pg_class WHERE oid = $1::regclass", qualifiedName(table)).Scan(&pages); err != nil {
+		return nil, fmt.Errorf("failed to read page count of %s: %w", qualifiedName(table), err)
+	}
+	if pages < count {
+		count = pages
+	}
+	if count <= 1 {
+		return []Chunk{{Table: table, Index: 1, Count: 1}}, nil
+	}
+
+	// relpages is an estimate, so the first and last chunks are open-ended
+	width := (pages + count - 1) / count
+	var chunks []Chunk
+	for i := int64(0); i < count; i++ {
+		var where string
+		switch {
+		case i == 0:
+			where = fmt.Sprintf("ctid < '(%d,0)'::tid", width)
+		case i == count-1:
+			where = fmt.Sprintf("ctid >= '(%d,0)'::tid", i*width)
+		default:
+			where = fmt.Sprintf("ctid >= '(%d,0)'::tid AND ctid < '(%d,0)'::tid", i*width, (i+1)*width)
+		}
+		chunks = append(chunks, Chunk{Table: table, Where: where})
+	}
+	return numberChunks(chunks), nil
+}
+
+func numberChunks(chunks []Chunk) []Chunk {
+	for i := range chunks {
+		chunks[i].Index = i + 1
+		chunks[i].Count = len(chunks)
+	}
+	return chunks
+}
@@ -0,0 +1,230 @@
+package copier
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+
+	"github.com/crisog/postgres-migrator/internal/database"
+	"github.com/crisog/postgres-migrator/internal/masking"
+	"github.com/crisog/postgres-migrator/internal/tracing"
+	"github.com/jackc/pgx/v5"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Copier moves table rows from source to target with COPY, reading every table inside
+// one repeatable read transaction so the copy is a consistent snapshot. Masked columns
+// are rewritten in the stream, so their raw values never reach the target.
+type Copier struct {
+	sourceURL string
+	targetURL string
+	opts      Options
+	source    *pgx.Conn
+	tx        pgx.Tx
+	target    *pgx.Conn
+}
+
+type Options struct {
+	// Masking may be nil when nothing is masked
+	Masking *masking.Rules
+	// DisableTriggers loads rows with triggers and foreign keys off, for targets whose
+	// constraints already exist. It requires a superuser on the target.
+	DisableTriggers bool
+	// ChunkRows splits CopyTables' tables with more estimated rows into ranges copied
+	// concurrently, 0 copies every table in one piece
+	ChunkRows int64
+	// Retries is how often CopyTables retries a failed chunk on a fresh connection
+	Retries int
+	// Logger receives chunk progress and retries, it may be nil
+	Logger *slog.Logger
+}
+
+// Open connects to both databases
+func Open(ctx context.Context, sourceURL, targetURL string, opts Options) (*Copier, error) {
+	return open(ctx, sourceURL, targetURL, "", opts)
+}
+
+// open starts the source transaction, importing snapshot when one is given
+func open(ctx context.Context, sourceURL, targetURL, snapshot string, opts Options) (*Copier, error) {
+	source, err := connect(ctx, sourceURL)
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to source database: %w", err)
+	}
+
+	tx, err := source.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.RepeatableRead})
+	if err != nil {
+		source.Close(ctx)
+		return nil, fmt.Errorf("failed to start source transaction: %w", err)
+	}
+
+	if snapshot != "" {
+		if _, err := tx.Exec(ctx, "SET TRANSACTION SNAPSHOT "+quoteLiteral(snapshot)); err != nil {
+			source.Close(ctx)
+			return nil, fmt.Errorf("failed to import source snapshot %s: %w", snapshot, err)
+		}
+	}
+
+	target, err := connect(ctx, targetURL)
+	if err != nil {
+		source.Close(ctx)
+		return nil, fmt.Errorf("unable to connect to target database: %w", err)
+	}
+
+	// Tables are loaded in catalog order, so foreign key triggers are disabled for this
+	// session the same way pg_restore --disable-triggers does
+	if opts.DisableTriggers {
+		if _, err := target.Exec(ctx, "SET session_replication_role = replica"); err != nil {
+			source.Close(ctx)
+			target.Close(ctx)
+			return nil, fmt.Errorf("failed to disable triggers on the target (requires superuser): %w", err)
+		}
+	}
+
+	return &Copier{sourceURL: sourceURL, targetURL: targetURL, opts: opts, source: source, tx: tx, target: target}, nil
+}
+
+// connect opens a COPY session. Dates and intervals are exchanged in ISO and postgres
+// style whatever DateStyle the database has, so masking can parse them and the target
+// reads them back unambiguously.
+func connect(ctx context.Context, databaseURL string) (*pgx.Conn, error) {
+	config, err := pgx.ParseConfig(databaseURL)
+	if err != nil {
+		return nil, err
+	}
+	config.RuntimeParams["DateStyle"] = "ISO"
+	config.RuntimeParams["IntervalStyle"] = "postgres"
+	return pgx.ConnectConfig(ctx, config)
+}
+
+// Source returns the snapshot transaction, for queries that must see the copied data
+func (c *Copier) Source() pgx.Tx {
+	return c.tx
+}
+
+func (c *Copier) Close(ctx context.Context) {
+	c.tx.Rollback(ctx)
+	c.source.Close(ctx)
+	c.target.Close(ctx)
+}
+
+// CopyTable copies the rows of table matching where, or all rows when where is empty,
+// and returns how many were written
+func (c *Copier) CopyTable(ctx context.Context, table database.TableInfo, where string) (n int64, err error) {
+	name := qualifiedName(table)
+
+	ctx, span := tracing.Start(ctx, "Copier.CopyTable", tracing.Table(name), attribute.String("where", where))
+	defer func() {
+		span.SetAttributes(tracing.Rows(n))
+		tracing.End(span, err)
+	}()
+
+	columns, err := insertableColumns(ctx, c.tx, name)
+	if err != nil {
+		return 0, err
+	}
+
+	quoted := make([]string, len(columns))
+	for i, column := range columns {
+		quoted[i] = pgx.Identifier{column}.Sanitize()
+	}
+	columnList := strings.Join(quoted, ", ")
+
+	query := fmt.Sprintf("SELECT %s FROM %s", columnList, name)
+	if where != "" {
+		query += " WHERE " + where
+	}
+
+	pr, pw := io.Pipe()
+	copyErr := make(chan error, 1)
+	go func() {
+		_, err := c.tx.Conn().PgConn().CopyTo(ctx, pw, fmt.Sprintf("COPY (%s) TO STDOUT", query))
+		pw.CloseWithError(err)
+		copyErr <- err
+	}()
+
+	var rows io.Reader = pr
+	if masker := c.opts.Masking.ForTable(table.Schema, table.Name, columns); masker != nil {
+		rows = masker.Reader(pr)
+	}
+
+	tag, err := c.target.PgConn().CopyFrom(ctx, rows, fmt.Sprintf("COPY %s (%s) FROM STDIN", name, columnList))
+	pr.Close()
+
+	// A failed read also breaks the write, so the source error is the root cause unless
+	// it only reports the pipe closed by a failed write
+	if sourceErr := <-copyErr; sourceErr != nil && !errors.Is(sourceErr, io.ErrClosedPipe) {
+		return 0, sourceErr
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return tag.RowsAffected(), nil
+}
+
+// CheckMasking fails when a masking rule names no column copied from tables, for example
+// a misspelled or generated column or one of a table that is not copied. rules may be nil.
+func CheckMasking(ctx context.Context, sourceURL string, rules *masking.Rules, tables []database.TableInfo) error {
+	if rules == nil {
+		return nil
+	}
+
+	conn, err := pgx.Connect(ctx, sourceURL)
+	if err != nil {
+		return fmt.Errorf("unable to connect to source database: %w", err)
+	}
+	defer conn.Close(ctx)
+
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to start source transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	masked := rules.Tables()
+	copied := make(map[string][]string)
+	for _, table := range tables {
+		key := table.Schema + "." + table.Name
+		if !masked[key] {
+			continue
+		}
+		if copied[key], err = insertableColumns(ctx, tx, qualifiedName(table)); err != nil {
+			return err
+		}
+	}
+	return rules.Check(copied)
+}
+
+// insertableColumns skips generated columns, which the target computes itself
+func insertableColumns(ctx context.Context, tx pgx.Tx, table string) ([]string, error) {
+	rows, err := tx.Query(ctx, `
+		SELECT attname
+		FROM pg_attribute
+		WHERE attrelid = $1::regclass AND attnum > 0 AND NOT attisdropped AND attgenerated = ''
+		ORDER BY attnum`, table)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list columns of %s: %w", table, err)
+	}
+	defer rows.Close()
+
+	var columns []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		columns = append(columns, name)
+	}
+	return columns, rows.Err()
+}
+
+func qualifiedName(table database.TableInfo) string {
+	return pgx.Identifier{table.Schema, table.Name}.Sanitize()
+}
+
+func quoteLiteral(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+}
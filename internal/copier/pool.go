@@ -0,0 +1,184 @@
+package copier
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/crisog/postgres-migrator/internal/database"
+	"github.com/crisog/postgres-migrator/internal/logging"
+	"github.com/crisog/postgres-migrator/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const retryBackoff = 2 * time.Second
+
+// CopyTables copies every table on up to workers connection pairs and returns the total
+// number of rows. Tables larger than ChunkRows are split into ranges that are copied
+// concurrently. Every worker reads through the snapshot exported by this copier, so all
+// chunks are copied as of the same moment, and a failed chunk is retried on a fresh
+// connection. done is called once per table, after its last chunk.
+func (c *Copier) CopyTables(ctx context.Context, tables []database.TableInfo, workers int, done func(table database.TableInfo, rows int64)) (total int64, err error) {
+	ctx, span := tracing.Start(ctx, "Copier.CopyTables", attribute.Int("tables", len(tables)), attribute.Int("workers", workers))
+	defer func() {
+		span.SetAttributes(tracing.Rows(total))
+		tracing.End(span, err)
+	}()
+
+	// Largest first, so one big table does not start last and hold up the run
+	ordered := append([]database.TableInfo(nil), tables...)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return ordered[i].TotalBytes > ordered[j].TotalBytes
+	})
+
+	var chunks []Chunk
+	for _, table := range ordered {
+		tableChunks, err := planChunks(ctx, c.tx, table, c.opts.ChunkRows)
+		if err != nil {
+			return 0, err
+		}
+		if len(tableChunks) > 1 {
+			c.logger().Info("table split into chunks", logging.Table(qualifiedName(table)), "chunks", len(tableChunks))
+		}
+		chunks = append(chunks, tableChunks...)
+	}
+
+	if workers > len(chunks) {
+		workers = len(chunks)
+	}
+	if workers < 1 {
+		return 0, nil
+	}
+
+	var snapshot string
+	if err := c.tx.QueryRow(ctx, "SELECT pg_export_snapshot()").Scan(&snapshot); err != nil {
+		return 0, fmt.Errorf("failed to export source snapshot: %w", err)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	queue := make(chan Chunk)
+	var (
+		mu        sync.Mutex
+		remaining = make(map[string]int, len(ordered))
+		tableRows = make(map[string]int64, len(ordered))
+		firstErr  error
+		wg        sync.WaitGroup
+	)
+	for _, chunk := range chunks {
+		remaining[qualifiedName(chunk.Table)]++
+	}
+	fail := func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if firstErr == nil {
+			firstErr = err
+			cancel()
+		}
+	}
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			var worker *Copier
+			defer func() {
+				if worker != nil {
+					worker.Close(context.Background())
+				}
+			}()
+
+			for chunk := range queue {
+				n, err := c.copyChunk(ctx, &worker, snapshot, chunk)
+				if err != nil {
+					fail(err)
+					return
+				}
+
+				name := qualifiedName(chunk.Table)
+				mu.Lock()
+				total += n
+				tableRows[name] += n
+				remaining[name]--
+				if chunk.Count > 1 {
+					c.logger().Info("chunk copied", logging.Table(name), "chunk", chunk.Index, "chunks", chunk.Count, logging.Rows(n))
+				}
+				if remaining[name] == 0 {
+					done(chunk.Table, tableRows[name])
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+feed:
+	for _, chunk := range chunks {
+		select {
+		case queue <- chunk:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(queue)
+	wg.Wait()
+
+	if firstErr != nil {
+		return 0, firstErr
+	}
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	return total, nil
+}
+
+// copyChunk copies one chunk on *worker, opening it first if needed. A COPY that fails
+// is rolled back on the target, so the chunk can be retried as a whole; the connection is
+// replaced since the failure may have broken it.
+func (c *Copier) copyChunk(ctx context.Context, worker **Copier, snapshot string, chunk Chunk) (int64, error) {
+	name := qualifiedName(chunk.Table)
+
+	for attempt := 0; ; attempt++ {
+		var err error
+		if *worker == nil {
+			*worker, err = open(ctx, c.sourceURL, c.targetURL, snapshot, c.opts)
+		}
+
+		var n int64
+		if err == nil {
+			n, err = (*worker).CopyTable(ctx, chunk.Table, chunk.Where)
+			if err == nil {
+				return n, nil
+			}
+			(*worker).Close(context.Background())
+			*worker = nil
+		}
+
+		if chunk.Count > 1 {
+			err = fmt.Errorf("failed to copy chunk %d/%d of %s: %w", chunk.Index, chunk.Count, name, err)
+		} else {
+			err = fmt.Errorf("failed to copy %s: %w", name, err)
+		}
+		if attempt >= c.opts.Retries || ctx.Err() != nil {
+			return 0, err
+		}
+
+		c.logger().Warn("chunk copy failed, retrying", logging.Table(name), "chunk", chunk.Index, "attempt", attempt+1, "retries", c.opts.Retries, logging.Err(err))
+		select {
+		case <-ctx.Done():
+			return 0, err
+		case <-time.After(retryBackoff * time.Duration(attempt+1)):
+		}
+	}
+}
+
+func (c *Copier) logger() *slog.Logger {
+	if c.opts.Logger == nil {
+		return logging.Discard()
+	}
+	return c.opts.Logger
+}
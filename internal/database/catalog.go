@@ -0,0 +1,133 @@
+package database
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+)
+
+type TableInfo struct {
+	Schema        string
+	Name          string
+	Owner         string
+	EstimatedRows int64
+	TotalBytes    int64
+	HasACL        bool
+	Partitioned   bool
+}
+
+type Inventory struct {
+	Schemas      []string
+	Tables       []TableInfo
+	Sequences    []string
+	Extensions   []string
+	LargeObjects int
+}
+
+func (i *Inventory) TotalBytes() int64 {
+	var total int64
+	for _, table := range i.Tables {
+		total += table.TotalBytes
+	}
+	return total
+}
+
+const systemSchemaFilter = `nspname NOT IN ('pg_catalog', 'information_schema', 'pg_toast')
+		AND nspname NOT LIKE 'pg_temp_%'
+		AND nspname NOT LIKE 'pg_toast_temp_%'
+		AND NOT (nspname = ANY($1))`
+
+func GetInventory(ctx context.Context, databaseURL string, excludeSchemas []string) (*Inventory, error) {
+	conn, err := pgx.Connect(ctx, databaseURL)
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to database: %w", err)
+	}
+	defer conn.Close(ctx)
+
+	if excludeSchemas == nil {
+		excludeSchemas = []string{}
+	}
+
+	inventory := &Inventory{}
+
+	inventory.Schemas, err = queryStrings(ctx, conn, `
+		SELECT nspname
+		FROM pg_namespace
+		WHERE `+systemSchemaFilter+`
+		ORDER BY nspname`, excludeSchemas)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list schemas: %w", err)
+	}
+
+	rows, err := conn.Query(ctx, `
+		SELECT n.nspname, c.relname, pg_get_userbyid(c.relowner),
+			GREATEST(c.reltuples, 0)::bigint, pg_total_relation_size(c.oid), c.relacl IS NOT NULL,
+			c.relkind = 'p'
+		FROM pg_class c
+		JOIN pg_namespace n ON n.oid = c.relnamespace
+		WHERE c.relkind IN ('r', 'p')
+		AND `+systemSchemaFilter+`
+		ORDER BY n.nspname, c.relname`, excludeSchemas)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tables: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var table TableInfo
+		if err := rows.Scan(&table.Schema, &table.Name, &table.Owner, &table.EstimatedRows, &table.TotalBytes, &table.HasACL, &table.Partitioned); err != nil {
+			return nil, fmt.Errorf("failed to scan table: %w", err)
+		}
+		inventory.Tables = append(inventory.Tables, table)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating tables: %w", err)
+	}
+
+	inventory.Sequences, err = queryStrings(ctx, conn, `
+		SELECT n.nspname || '.' || c.relname
+		FROM pg_class c
+		JOIN pg_namespace n ON n.oid = c.relnamespace
+		WHERE c.relkind = 'S'
+		AND `+systemSchemaFilter+`
+		ORDER BY 1`, excludeSchemas)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sequences: %w", err)
+	}
+
+	inventory.Extensions, err = queryStrings(ctx, conn, `
+		SELECT e.extname || ' ' || e.extversion
+		FROM pg_extension e
+		JOIN pg_namespace n ON n.oid = e.extnamespace
+		WHERE e.extname <> 'plpgsql'
+		AND NOT (n.nspname = ANY($1))
+		ORDER BY 1`, excludeSchemas)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list extensions: %w", err)
+	}
+
+	if err := conn.QueryRow(ctx, "SELECT COUNT(*) FROM pg_largeobject_metadata").Scan(&inventory.LargeObjects); err != nil {
+		return nil, fmt.Errorf("failed to count large objects: %w", err)
+	}
+
+	return inventory, nil
+}
+
+func queryStrings(ctx context.Context, conn *pgx.Conn, query string, args ...any) ([]string, error) {
+	rows, err := conn.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var values []string
+	for rows.Next() {
+		var value string
+		if err := rows.Scan(&value); err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	return values, rows.Err()
+}
@@ -0,0 +1,119 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// DatabaseInfo holds what CREATE DATABASE needs to recreate a database on another server
+type DatabaseInfo struct {
+	Name     string
+	Owner    string
+	Encoding string
+	Collate  string
+	Ctype    string
+}
+
+// ListDatabases returns the databases of the server that are not templates and accept
+// connections, ordered by name
+func ListDatabases(ctx context.Context, serverURL string) ([]DatabaseInfo, error) {
+	conn, err := pgx.Connect(ctx, serverURL)
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to database: %w", err)
+	}
+	defer conn.Close(ctx)
+
+	rows, err := conn.Query(ctx, `
+		SELECT d.datname, r.rolname, pg_encoding_to_char(d.encoding), d.datcollate, d.datctype
+		FROM pg_database d
+		JOIN pg_roles r ON r.oid = d.datdba
+		WHERE NOT d.datistemplate AND d.datallowconn
+		ORDER BY d.datname`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list databases: %w", err)
+	}
+	defer rows.Close()
+
+	var databases []DatabaseInfo
+	for rows.Next() {
+		var db DatabaseInfo
+		if err := rows.Scan(&db.Name, &db.Owner, &db.Encoding, &db.Collate, &db.Ctype); err != nil {
+			return nil, fmt.Errorf("failed to scan database: %w", err)
+		}
+		databases = append(databases, db)
+	}
+	return databases, rows.Err()
+}
+
+func DatabaseExists(ctx context.Context, serverURL, name string) (bool, error) {
+	return exists(ctx, serverURL, "SELECT EXISTS (SELECT 1 FROM pg_database WHERE datname = $1)", name)
+}
+
+// CreateDatabase creates the database from template0 with the same encoding and locale.
+// The owner is only set when a role of that name exists on the server, otherwise the
+// connecting user owns the database and ownerSet is false.
+func CreateDatabase(ctx context.Context, serverURL string, db DatabaseInfo) (ownerSet bool, err error) {
+	conn, err := pgx.Connect(ctx, serverURL)
+	if err != nil {
+		return false, fmt.Errorf("unable to connect to database: %w", err)
+	}
+	defer conn.Close(ctx)
+
+	if err := conn.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM pg_roles WHERE rolname = $1)", db.Owner).Scan(&ownerSet); err != nil {
+		return false, fmt.Errorf("failed to look up role %s: %w", db.Owner, err)
+	}
+
+	query := fmt.Sprintf("CREATE DATABASE %s WITH TEMPLATE template0 ENCODING %s LC_COLLATE %s LC_CTYPE %s",
+		pgx.Identifier{db.Name}.Sanitize(), quoteLiteral(db.Encoding), quoteLiteral(db.Collate), quoteLiteral(db.Ctype))
+	if ownerSet {
+		query += " OWNER " + pgx.Identifier{db.Owner}.Sanitize()
+	}
+	if _, err := conn.Exec(ctx, query); err != nil {
+		return false, fmt.Errorf("failed to create database %s: %w", db.Name, err)
+	}
+	return ownerSet, nil
+}
+
+// SetDatabaseOwner hands the database to its owner role, if that role exists on the
+// server by now
+func SetDatabaseOwner(ctx context.Context, serverURL string, db DatabaseInfo) (ownerSet bool, err error) {
+	conn, err := pgx.Connect(ctx, serverURL)
+	if err != nil {
+		return false, fmt.Errorf("unable to connect to database: %w", err)
+	}
+	defer conn.Close(ctx)
+
+	if err := conn.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM pg_roles WHERE rolname = $1)", db.Owner).Scan(&ownerSet); err != nil {
+		return false, fmt.Errorf("failed to look up role %s: %w", db.Owner, err)
+	}
+	if !ownerSet {
+		return false, nil
+	}
+
+	query := fmt.Sprintf("ALTER DATABASE %s OWNER TO %s", pgx.Identifier{db.Name}.Sanitize(), pgx.Identifier{db.Owner}.Sanitize())
+	if _, err := conn.Exec(ctx, query); err != nil {
+		return false, fmt.Errorf("failed to set owner of database %s: %w", db.Name, err)
+	}
+	return true, nil
+}
+
+// DatabaseURL points a server connection string, in URL or keyword format, at another
+// database on the same server
+func DatabaseURL(serverURL, name string) (string, error) {
+	if !strings.Contains(serverURL, "://") {
+		value := strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(name)
+		return strings.TrimSpace(serverURL) + " dbname='" + value + "'", nil
+	}
+
+	u, err := url.Parse(serverURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid connection string: %w", err)
+	}
+	u.Path = "/" + name
+	u.RawPath = ""
+	return u.String(), nil
+}
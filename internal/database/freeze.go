@@ -0,0 +1,123 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// FreezeWrites makes new sessions on the database read-only and returns the server time
+// the freeze took effect. Sessions opened before then keep their old default.
+func FreezeWrites(ctx context.Context, databaseURL string) (time.Time, error) {
+	conn, err := connectReadWrite(ctx, databaseURL)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer conn.Close(ctx)
+
+	name, err := currentDatabase(ctx, conn)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	if _, err := conn.Exec(ctx, fmt.Sprintf("ALTER DATABASE %s SET default_transaction_read_only = on", name)); err != nil {
+		return time.Time{}, fmt.Errorf("failed to make database read-only: %w", err)
+	}
+
+	var frozenAt time.Time
+	if err := conn.QueryRow(ctx, "SELECT clock_timestamp()").Scan(&frozenAt); err != nil {
+		return time.Time{}, fmt.Errorf("failed to read server time: %w", err)
+	}
+
+	return frozenAt, nil
+}
+
+func UnfreezeWrites(ctx context.Context, databaseURL string) error {
+	conn, err := connectReadWrite(ctx, databaseURL)
+	if err != nil {
+		return err
+	}
+	defer conn.Close(ctx)
+
+	name, err := currentDatabase(ctx, conn)
+	if err != nil {
+		return err
+	}
+
+	if _, err := conn.Exec(ctx, fmt.Sprintf("ALTER DATABASE %s RESET default_transaction_read_only", name)); err != nil {
+		return fmt.Errorf("failed to make database writable: %w", err)
+	}
+
+	return nil
+}
+
+// InFlightTransactions counts client transactions on the database that started before the given time
+func InFlightTransactions(ctx context.Context, databaseURL string, before time.Time) (int, error) {
+	conn, err := pgx.Connect(ctx, databaseURL)
+	if err != nil {
+		return 0, fmt.Errorf("unable to connect to database: %w", err)
+	}
+	defer conn.Close(ctx)
+
+	var count int
+	err = conn.QueryRow(ctx, `
+		SELECT COUNT(*)
+		FROM pg_stat_activity
+		WHERE datname = current_database()
+		AND pid <> pg_backend_pid()
+		AND backend_type = 'client backend'
+		AND xact_start < $1`, before).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count in-flight transactions: %w", err)
+	}
+
+	return count, nil
+}
+
+// TerminateSessions disconnects client sessions opened before the given time, so that
+// reconnecting clients pick up the read-only default
+func TerminateSessions(ctx context.Context, databaseURL string, before time.Time) (int, error) {
+	conn, err := pgx.Connect(ctx, databaseURL)
+	if err != nil {
+		return 0, fmt.Errorf("unable to connect to database: %w", err)
+	}
+	defer conn.Close(ctx)
+
+	var count int
+	err = conn.QueryRow(ctx, `
+		SELECT COUNT(*) FILTER (WHERE pg_terminate_backend(pid))
+		FROM pg_stat_activity
+		WHERE datname = current_database()
+		AND pid <> pg_backend_pid()
+		AND backend_type = 'client backend'
+		AND backend_start < $1`, before).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("failed to terminate sessions: %w", err)
+	}
+
+	return count, nil
+}
+
+func connectReadWrite(ctx context.Context, databaseURL string) (*pgx.Conn, error) {
+	connConfig, err := pgx.ParseConfig(databaseURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid connection string: %w", err)
+	}
+	connConfig.RuntimeParams["default_transaction_read_only"] = "off"
+
+	conn, err := pgx.ConnectConfig(ctx, connConfig)
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to database: %w", err)
+	}
+	return conn, nil
+}
+
+func currentDatabase(ctx context.Context, conn *pgx.Conn) (string, error) {
+	var name string
+	if err := conn.QueryRow(ctx, "SELECT current_database()").Scan(&name); err != nil {
+		return "", fmt.Errorf("failed to read database name: %w", err)
+	}
+	return pgx.Identifier{name}.Sanitize(), nil
+}
@@ -0,0 +1,252 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// validateReplication checks that the source can publish and the target can subscribe:
+// logical wal_level, replication privileges and a replica identity on every published table
+func validateReplication(ctx context.Context, sourceURL, targetURL string, excludeSchemas []string, published func(schema, table string) bool) error {
+	sourceConn, err := pgx.Connect(ctx, sourceURL)
+	if err != nil {
+		return fmt.Errorf("unable to connect to source database: %w", err)
+	}
+	defer sourceConn.Close(ctx)
+
+	var walLevel string
+	if err := sourceConn.QueryRow(ctx, "SHOW wal_level").Scan(&walLevel); err != nil {
+		return fmt.Errorf("unable to read source wal_level: %w", err)
+	}
+	if walLevel != "logical" {
+		return fmt.Errorf("source wal_level is %q, logical replication requires wal_level = logical", walLevel)
+	}
+
+	var canReplicate bool
+	err = sourceConn.QueryRow(ctx, `
+		SELECT r.rolsuper OR r.rolreplication OR EXISTS (
+			SELECT 1 FROM pg_roles m
+			WHERE m.rolname = 'rds_replication' AND pg_has_role(current_user, m.oid, 'member')
+		)
+		FROM pg_roles r
+		WHERE r.rolname = current_user`).Scan(&canReplicate)
+	if err != nil {
+		return fmt.Errorf("unable to check source replication privileges: %w", err)
+	}
+	if !canReplicate {
+		return fmt.Errorf("source user lacks the REPLICATION privilege required for logical replication")
+	}
+
+	if excludeSchemas == nil {
+		excludeSchemas = []string{}
+	}
+	rows, err := sourceConn.Query(ctx, `
+		SELECT n.nspname, c.relname
+		FROM pg_class c
+		JOIN pg_namespace n ON n.oid = c.relnamespace
+		WHERE c.relkind = 'r'
+		AND `+systemSchemaFilter+`
+		AND (
+			c.relreplident = 'n'
+			OR (c.relreplident = 'd' AND NOT EXISTS (
+				SELECT 1 FROM pg_index i WHERE i.indrelid = c.oid AND i.indisprimary
+			))
+		)
+		ORDER BY 1, 2`, excludeSchemas)
+	if err != nil {
+		return fmt.Errorf("unable to check replica identities: %w", err)
+	}
+	var missingIdentity []string
+	var schema, table string
+	_, err = pgx.ForEachRow(rows, []any{&schema, &table}, func() error {
+		if published == nil || published(schema, table) {
+			missingIdentity = append(missingIdentity, schema+"."+table)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("unable to check replica identities: %w", err)
+	}
+	if len(missingIdentity) > 0 {
+		return fmt.Errorf("tables without a primary key or replica identity cannot replicate updates and deletes: %s (add a primary key or ALTER TABLE ... REPLICA IDENTITY FULL)", strings.Join(missingIdentity, ", "))
+	}
+
+	targetConn, err := pgx.Connect(ctx, targetURL)
+	if err != nil {
+		return fmt.Errorf("unable to connect to target database: %w", err)
+	}
+	defer targetConn.Close(ctx)
+
+	var canSubscribe bool
+	err = targetConn.QueryRow(ctx, `
+		SELECT r.rolsuper OR EXISTS (
+			SELECT 1 FROM pg_roles m
+			WHERE m.rolname = 'pg_create_subscription' AND pg_has_role(current_user, m.oid, 'member')
+		)
+		FROM pg_roles r
+		WHERE r.rolname = current_user`).Scan(&canSubscribe)
+	if err != nil {
+		return fmt.Errorf("unable to check target subscription privileges: %w", err)
+	}
+	if !canSubscribe {
+		return fmt.Errorf("target user must be a superuser or a member of pg_create_subscription to create a subscription")
+	}
+
+	return nil
+}
+
+func PublicationExists(ctx context.Context, sourceURL, name string) (bool, error) {
+	return exists(ctx, sourceURL, "SELECT EXISTS (SELECT 1 FROM pg_publication WHERE pubname = $1)", name)
+}
+
+func SubscriptionExists(ctx context.Context, targetURL, name string) (bool, error) {
+	return exists(ctx, targetURL, "SELECT EXISTS (SELECT 1 FROM pg_subscription WHERE subname = $1)", name)
+}
+
+func CreatePublication(ctx context.Context, sourceURL, name string, tables []TableInfo) error {
+	if len(tables) == 0 {
+		return fmt.Errorf("no tables to publish")
+	}
+
+	qualified := make([]string, len(tables))
+	for i, table := range tables {
+		qualified[i] = pgx.Identifier{table.Schema, table.Name}.Sanitize()
+	}
+
+	query := fmt.Sprintf("CREATE PUBLICATION %s FOR TABLE %s", pgx.Identifier{name}.Sanitize(), strings.Join(qualified, ", "))
+	return execute(ctx, sourceURL, query)
+}
+
+func CreateSubscription(ctx context.Context, targetURL, name, sourceConnInfo, publication string) error {
+	query := fmt.Sprintf("CREATE SUBSCRIPTION %s CONNECTION %s PUBLICATION %s WITH (copy_data = true)",
+		pgx.Identifier{name}.Sanitize(), quoteLiteral(sourceConnInfo), pgx.Identifier{publication}.Sanitize())
+	return execute(ctx, targetURL, query)
+}
+
+// SubscriptionSyncState returns how many of the subscribed tables have finished their
+// initial copy and are streaming changes
+func SubscriptionSyncState(ctx context.Context, targetURL, name string) (ready, total int, err error) {
+	conn, err := pgx.Connect(ctx, targetURL)
+	if err != nil {
+		return 0, 0, fmt.Errorf("unable to connect to target database: %w", err)
+	}
+	defer conn.Close(ctx)
+
+	err = conn.QueryRow(ctx, `
+		SELECT COUNT(*) FILTER (WHERE sr.srsubstate IN ('r', 's')), COUNT(*)
+		FROM pg_subscription_rel sr
+		JOIN pg_subscription s ON s.oid = sr.srsubid
+		WHERE s.subname = $1`, name).Scan(&ready, &total)
+	if err != nil {
+		return 0, 0, fmt.Errorf("unable to read subscription state: %w", err)
+	}
+
+	return ready, total, nil
+}
+
+// ReplicationLag returns how many bytes of WAL the subscription's slot on the source
+// has not yet confirmed
+func ReplicationLag(ctx context.Context, sourceURL, slotName string) (int64, error) {
+	conn, err := pgx.Connect(ctx, sourceURL)
+	if err != nil {
+		return 0, fmt.Errorf("unable to connect to source database: %w", err)
+	}
+	defer conn.Close(ctx)
+
+	var lag *int64
+	err = conn.QueryRow(ctx, `
+		SELECT pg_wal_lsn_diff(pg_current_wal_lsn(), confirmed_flush_lsn)::bigint
+		FROM pg_replication_slots
+		WHERE slot_name = $1`, slotName).Scan(&lag)
+	if err == pgx.ErrNoRows {
+		return 0, fmt.Errorf("replication slot %s not found on source", slotName)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("unable to read replication lag: %w", err)
+	}
+	if lag == nil {
+		return 0, nil
+	}
+
+	return *lag, nil
+}
+
+func DropSubscription(ctx context.Context, targetURL, name string) error {
+	return execute(ctx, targetURL, fmt.Sprintf("DROP SUBSCRIPTION IF EXISTS %s", pgx.Identifier{name}.Sanitize()))
+}
+
+func DropPublication(ctx context.Context, sourceURL, name string) error {
+	return execute(ctx, sourceURL, fmt.Sprintf("DROP PUBLICATION IF EXISTS %s", pgx.Identifier{name}.Sanitize()))
+}
+
+func CurrentWALPosition(ctx context.Context, databaseURL string) (string, error) {
+	conn, err := pgx.Connect(ctx, databaseURL)
+	if err != nil {
+		return "", fmt.Errorf("unable to connect to database: %w", err)
+	}
+	defer conn.Close(ctx)
+
+	var lsn string
+	if err := conn.QueryRow(ctx, "SELECT pg_current_wal_lsn()::text").Scan(&lsn); err != nil {
+		return "", fmt.Errorf("unable to read WAL position: %w", err)
+	}
+	return lsn, nil
+}
+
+// ReplicationCaughtUp reports whether the slot has confirmed everything up to lsn
+func ReplicationCaughtUp(ctx context.Context, sourceURL, slotName, lsn string) (bool, error) {
+	conn, err := pgx.Connect(ctx, sourceURL)
+	if err != nil {
+		return false, fmt.Errorf("unable to connect to source database: %w", err)
+	}
+	defer conn.Close(ctx)
+
+	var caughtUp *bool
+	err = conn.QueryRow(ctx, `
+		SELECT confirmed_flush_lsn >= $2::pg_lsn
+		FROM pg_replication_slots
+		WHERE slot_name = $1`, slotName, lsn).Scan(&caughtUp)
+	if err == pgx.ErrNoRows {
+		return false, fmt.Errorf("replication slot %s not found on source", slotName)
+	}
+	if err != nil {
+		return false, fmt.Errorf("unable to read replication position: %w", err)
+	}
+
+	return caughtUp != nil && *caughtUp, nil
+}
+
+func exists(ctx context.Context, databaseURL, query string, args ...any) (bool, error) {
+	conn, err := pgx.Connect(ctx, databaseURL)
+	if err != nil {
+		return false, fmt.Errorf("unable to connect to database: %w", err)
+	}
+	defer conn.Close(ctx)
+
+	var found bool
+	if err := conn.QueryRow(ctx, query, args...).Scan(&found); err != nil {
+		return false, err
+	}
+	return found, nil
+}
+
+// execute runs DDL, overriding a read-only default so it also works on a frozen source
+func execute(ctx context.Context, databaseURL, query string) error {
+	conn, err := connectReadWrite(ctx, databaseURL)
+	if err != nil {
+		return err
+	}
+	defer conn.Close(ctx)
+
+	if _, err := conn.Exec(ctx, query); err != nil {
+		return err
+	}
+	return nil
+}
+
+func quoteLiteral(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+}
@@ -0,0 +1,48 @@
+package database
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// RoleNames returns the names of every role on the server
+func RoleNames(ctx context.Context, databaseURL string) (map[string]bool, error) {
+	conn, err := pgx.Connect(ctx, databaseURL)
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to database: %w", err)
+	}
+	defer conn.Close(ctx)
+
+	names, err := queryStrings(ctx, conn, "SELECT rolname FROM pg_roles")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list roles: %w", err)
+	}
+
+	roles := make(map[string]bool, len(names))
+	for _, name := range names {
+		roles[name] = true
+	}
+	return roles, nil
+}
+
+// ExecEach runs the statements one at a time on a single session, so SET statements
+// carry over and a failed statement does not undo the earlier ones. failed is called
+// for each statement that errors; when it returns an error the run stops with it.
+func ExecEach(ctx context.Context, databaseURL string, statements []string, failed func(i int, err error) error) error {
+	conn, err := connectReadWrite(ctx, databaseURL)
+	if err != nil {
+		return err
+	}
+	defer conn.Close(ctx)
+
+	for i, statement := range statements {
+		if _, err := conn.Exec(ctx, statement); err != nil {
+			if err := failed(i, err); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
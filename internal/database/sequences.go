@@ -0,0 +1,161 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+)
+
+type SequenceState struct {
+	Schema     string
+	Name       string
+	StartValue int64
+	// LastValue is nil when the sequence has never been used, or when it is not Readable
+	LastValue *int64
+	// Readable is false when the connecting user lacks USAGE and SELECT on the sequence,
+	// pg_sequences then hides its value
+	Readable bool
+	// OwnerTable and OwnerColumn identify the serial or identity column the sequence
+	// belongs to, if any
+	OwnerTable  string
+	OwnerColumn string
+}
+
+func (s SequenceState) QualifiedName() string {
+	return pgx.Identifier{s.Schema, s.Name}.Sanitize()
+}
+
+func GetSequenceStates(ctx context.Context, databaseURL string, excludeSchemas []string) ([]SequenceState, error) {
+	conn, err := pgx.Connect(ctx, databaseURL)
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to database: %w", err)
+	}
+	defer conn.Close(ctx)
+
+	if excludeSchemas == nil {
+		excludeSchemas = []string{}
+	}
+
+	rows, err := conn.Query(ctx, `
+		SELECT s.schemaname, s.sequencename, s.start_value, s.last_value,
+			has_sequence_privilege(format('%I.%I', s.schemaname, s.sequencename), 'USAGE, SELECT'),
+			COALESCE(owner.table_name, ''), COALESCE(owner.column_name, '')
+		FROM pg_sequences s
+		LEFT JOIN LATERAL (
+			SELECT format('%I.%I', n.nspname, t.relname) AS table_name, a.attname AS column_name
+			FROM pg_depend d
+			JOIN pg_class t ON t.oid = d.refobjid
+			JOIN pg_namespace n ON n.oid = t.relnamespace
+			JOIN pg_attribute a ON a.attrelid = d.refobjid AND a.attnum = d.refobjsubid
+			WHERE d.classid = 'pg_class'::regclass
+			AND d.refclassid = 'pg_class'::regclass
+			AND d.objid = format('%I.%I', s.schemaname, s.sequencename)::regclass
+			AND d.deptype IN ('a', 'i')
+			LIMIT 1
+		) owner ON true
+		WHERE NOT (s.schemaname = ANY($1))
+		AND s.schemaname NOT IN ('pg_catalog', 'information_schema')
+		ORDER BY s.schemaname, s.sequencename`, excludeSchemas)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sequences: %w", err)
+	}
+	defer rows.Close()
+
+	var states []SequenceState
+	for rows.Next() {
+		var state SequenceState
+		if err := rows.Scan(&state.Schema, &state.Name, &state.StartValue, &state.LastValue, &state.Readable, &state.OwnerTable, &state.OwnerColumn); err != nil {
+			return nil, fmt.Errorf("failed to scan sequence: %w", err)
+		}
+		states = append(states, state)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating sequences: %w", err)
+	}
+
+	return states, nil
+}
+
+// ApplySequenceStates sets each sequence on the target to the given state. A sequence is
+// matched by name, or else through the serial or identity column that owns it, since
+// the target may have named it differently. Sequences matched neither way are returned.
+// Nothing is changed when a state was not readable on the source, since its missing
+// value would otherwise rewind the target sequence to its start.
+func ApplySequenceStates(ctx context.Context, databaseURL string, states []SequenceState) (unmapped []string, err error) {
+	var unreadable []string
+	for _, state := range states {
+		if !state.Readable {
+			unreadable = append(unreadable, state.QualifiedName())
+		}
+	}
+	if len(unreadable) > 0 {
+		return nil, fmt.Errorf("the source user cannot read sequences %s, grant it USAGE or SELECT on them", strings.Join(unreadable, ", "))
+	}
+
+	conn, err := pgx.Connect(ctx, databaseURL)
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to database: %w", err)
+	}
+	defer conn.Close(ctx)
+
+	for _, state := range states {
+		target, err := resolveSequence(ctx, conn, state)
+		if err != nil {
+			return nil, err
+		}
+		if target == "" {
+			name := state.QualifiedName()
+			if state.OwnerTable != "" {
+				name += fmt.Sprintf(" (owned by %s.%s)", state.OwnerTable, state.OwnerColumn)
+			}
+			unmapped = append(unmapped, name)
+			continue
+		}
+
+		// An unused sequence is reset so its next value is the start value again
+		value, isCalled := state.StartValue, false
+		if state.LastValue != nil {
+			value, isCalled = *state.LastValue, true
+		}
+
+		if _, err := conn.Exec(ctx, "SELECT setval($1::regclass, $2, $3)", target, value, isCalled); err != nil {
+			return nil, fmt.Errorf("failed to set sequence %s: %w", target, err)
+		}
+	}
+
+	return unmapped, nil
+}
+
+func resolveSequence(ctx context.Context, conn *pgx.Conn, state SequenceState) (string, error) {
+	var exists bool
+	if err := conn.QueryRow(ctx, "SELECT to_regclass($1) IS NOT NULL", state.QualifiedName()).Scan(&exists); err != nil {
+		return "", fmt.Errorf("failed to look up sequence %s: %w", state.QualifiedName(), err)
+	}
+	if exists {
+		return state.QualifiedName(), nil
+	}
+
+	if state.OwnerTable == "" {
+		return "", nil
+	}
+
+	var owned *string
+	err := conn.QueryRow(ctx, `
+		SELECT pg_get_serial_sequence($1, $2)
+		WHERE EXISTS (
+			SELECT 1 FROM pg_attribute
+			WHERE attrelid = to_regclass($1) AND attname = $2 AND NOT attisdropped
+		)`, state.OwnerTable, state.OwnerColumn).Scan(&owned)
+	if err == pgx.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to look up sequence for %s.%s: %w", state.OwnerTable, state.OwnerColumn, err)
+	}
+	if owned == nil {
+		return "", nil
+	}
+	return *owned, nil
+}
@@ -3,12 +3,14 @@ package database
 import (
 	"context"
 	"fmt"
-	"log"
+	"log/slog"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/crisog/postgres-migrator/internal/tracing"
 	"github.com/jackc/pgx/v5"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 func ValidateConnection(ctx context.Context, databaseURL string) error {
@@ -63,6 +65,22 @@ func GetVersion(ctx context.Context, databaseURL string) (string, error) {
 	return version, nil
 }
 
+func GetDatabaseSize(ctx context.Context, databaseURL string) (int64, error) {
+	conn, err := pgx.Connect(ctx, databaseURL)
+	if err != nil {
+		return 0, fmt.Errorf("unable to connect to database: %w", err)
+	}
+	defer conn.Close(ctx)
+
+	var size int64
+	err = conn.QueryRow(ctx, "SELECT pg_database_size(current_database())").Scan(&size)
+	if err != nil {
+		return 0, fmt.Errorf("unable to get database size: %w", err)
+	}
+
+	return size, nil
+}
+
 func extractMajorVersion(version string) (int, error) {
 	parts := strings.Split(version, ".")
 	if len(parts) == 0 {
@@ -77,11 +95,17 @@ func extractMajorVersion(version string) (int, error) {
 	return major, nil
 }
 
-func ValidateBothConnections(logger *log.Logger, sourceURL, targetURL string, skipVersionCheck bool) (targetTableCount int, err error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+// ValidateBothConnections checks connectivity and versions. With checkReplication it also checks
+// the logical replication prerequisites for the tables outside excludeSchemas that published
+// accepts, or all of them when published is nil.
+func ValidateBothConnections(ctx context.Context, logger *slog.Logger, sourceURL, targetURL string, skipVersionCheck, checkReplication bool, excludeSchemas []string, published func(schema, table string) bool) (targetTableCount int, err error) {
+	ctx, span := tracing.Start(ctx, "database.ValidateBothConnections", attribute.Bool("check_replication", checkReplication))
+	defer func() { tracing.End(span, err) }()
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
-	logger.Println("Validating source database connection...")
+	logger.Info("validating source database connection")
 
 	if err := ValidateConnection(ctx, sourceURL); err != nil {
 		return 0, fmt.Errorf("source database validation failed: %w", err)
@@ -91,9 +115,9 @@ func ValidateBothConnections(logger *log.Logger, sourceURL, targetURL string, sk
 	if err != nil {
 		return 0, fmt.Errorf("unable to get source database version: %w", err)
 	}
-	logger.Printf("Source database: PostgreSQL %s\n", sourceVersion)
+	logger.Info("source database connected", "version", sourceVersion)
 
-	logger.Println("Validating target database connection...")
+	logger.Info("validating target database connection")
 
 	if err := ValidateConnection(ctx, targetURL); err != nil {
 		return 0, fmt.Errorf("target database validation failed: %w", err)
@@ -103,7 +127,7 @@ func ValidateBothConnections(logger *log.Logger, sourceURL, targetURL string, sk
 	if err != nil {
 		return 0, fmt.Errorf("unable to get target database version: %w", err)
 	}
-	logger.Printf("Target database: PostgreSQL %s\n", targetVersion)
+	logger.Info("target database connected", "version", targetVersion)
 
 	sourceMajor, err := extractMajorVersion(sourceVersion)
 	if err != nil {
@@ -117,18 +141,27 @@ func ValidateBothConnections(logger *log.Logger, sourceURL, targetURL string, sk
 
 	if sourceMajor != targetMajor {
 		if skipVersionCheck {
-			logger.Printf("WARNING: major version mismatch: source is PostgreSQL %d, target is PostgreSQL %d (proceeding because SKIP_VERSION_CHECK is enabled)\n", sourceMajor, targetMajor)
+			logger.Warn("major version mismatch, proceeding because SKIP_VERSION_CHECK is enabled", "source_major", sourceMajor, "target_major", targetMajor)
 		} else {
 			return 0, fmt.Errorf("major version mismatch: source is PostgreSQL %d, target is PostgreSQL %d (must be same major version)", sourceMajor, targetMajor)
 		}
 	} else {
-		logger.Printf("Version check passed: both databases are PostgreSQL %d\n", sourceMajor)
+		logger.Info("version check passed", "major", sourceMajor)
+	}
+
+	if checkReplication {
+		logger.Info("checking logical replication prerequisites")
+		if err := validateReplication(ctx, sourceURL, targetURL, excludeSchemas, published); err != nil {
+			return 0, fmt.Errorf("logical replication check failed: %w", err)
+		}
+		logger.Info("logical replication check passed")
 	}
 
 	targetTableCount, err = GetTargetTableCount(ctx, targetURL)
 	if err != nil {
 		return 0, err
 	}
+	span.SetAttributes(attribute.Int("target_tables", targetTableCount))
 
 	return targetTableCount, nil
 }
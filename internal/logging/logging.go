@@ -0,0 +1,98 @@
+package logging
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"log/slog"
+	"time"
+
+	"github.com/crisog/postgres-migrator/internal/config"
+)
+
+// Field keys shared by every component, so log pipelines can index them
+const (
+	KeyRunID       = "run_id"
+	KeyPhase       = "phase"
+	KeyDatabase    = "database"
+	KeyTable       = "table"
+	KeyTables      = "tables"
+	KeyFile        = "file"
+	KeyJobs        = "jobs"
+	KeyCompression = "compression"
+	KeyStatePhase  = "state_phase"
+	KeyDuration    = "duration_ms"
+	KeyBytes       = "bytes"
+	KeyRows        = "rows"
+	KeyError       = "error"
+)
+
+// New returns a logger writing records to w in the LOG_FORMAT of cfg
+func New(w io.Writer, cfg *config.Config) *slog.Logger {
+	opts := &slog.HandlerOptions{Level: cfg.SlogLevel()}
+	if cfg.LogFormat == config.LogFormatJSON {
+		return slog.New(slog.NewJSONHandler(w, opts))
+	}
+	return slog.New(slog.NewTextHandler(w, opts))
+}
+
+// Discard returns a logger that drops every record
+func Discard() *slog.Logger {
+	return slog.New(slog.DiscardHandler)
+}
+
+// NewRunID returns a random identifier that tells the logs of separate runs apart
+func NewRunID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func Phase(name string) slog.Attr {
+	return slog.String(KeyPhase, name)
+}
+
+func Database(name string) slog.Attr {
+	return slog.String(KeyDatabase, name)
+}
+
+func Table(name string) slog.Attr {
+	return slog.String(KeyTable, name)
+}
+
+func Tables(n int) slog.Attr {
+	return slog.Int(KeyTables, n)
+}
+
+func File(path string) slog.Attr {
+	return slog.String(KeyFile, path)
+}
+
+func Jobs(n int) slog.Attr {
+	return slog.Int(KeyJobs, n)
+}
+
+func Compression(spec string) slog.Attr {
+	return slog.String(KeyCompression, spec)
+}
+
+// StatePhase is the phase recorded in STATE_FILE, which differs from the run's phase
+func StatePhase(phase string) slog.Attr {
+	return slog.String(KeyStatePhase, phase)
+}
+
+func Duration(d time.Duration) slog.Attr {
+	return slog.Int64(KeyDuration, d.Milliseconds())
+}
+
+func Bytes(n int64) slog.Attr {
+	return slog.Int64(KeyBytes, n)
+}
+
+func Rows(n int64) slog.Attr {
+	return slog.Int64(KeyRows, n)
+}
+
+func Err(err error) slog.Attr {
+	return slog.String(KeyError, err.Error())
+}
@@ -0,0 +1,141 @@
+package masking
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+)
+
+const (
+	TransformerHash       = "hash"
+	TransformerFakeEmail  = "fake_email"
+	TransformerNull       = "null"
+	TransformerFixed      = "fixed"
+	TransformerKeepFormat = "keep_format"
+	TransformerShiftDates = "shift_dates"
+)
+
+const defaultShiftDays = 30
+
+type Rule struct {
+	Transformer string `json:"transformer"`
+	// Value is the replacement for the fixed transformer
+	Value string `json:"value,omitempty"`
+	// Days bounds the shift_dates offset in either direction
+	Days int `json:"days,omitempty"`
+}
+
+// Rules maps schema.table.column to the transformer applied to that column. Transformers
+// are deterministic for a given salt, so a value masked in two tables still joins.
+type Rules struct {
+	Salt    string          `json:"salt"`
+	Columns map[string]Rule `json:"columns"`
+}
+
+func Load(path string) (*Rules, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read masking file: %w", err)
+	}
+
+	var raw Rules
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("invalid masking file %s: %w", path, err)
+	}
+
+	rules := &Rules{Salt: raw.Salt, Columns: make(map[string]Rule, len(raw.Columns))}
+	for name, rule := range raw.Columns {
+		key, err := normalizeColumn(name)
+		if err != nil {
+			return nil, fmt.Errorf("masking file %s: %w", path, err)
+		}
+
+		switch rule.Transformer {
+		case TransformerHash, TransformerFakeEmail, TransformerNull, TransformerFixed, TransformerKeepFormat:
+		case TransformerShiftDates:
+			if rule.Days < 0 {
+				return nil, fmt.Errorf("masking file %s: %s: days must not be negative", path, name)
+			}
+			if rule.Days == 0 {
+				rule.Days = defaultShiftDays
+			}
+		default:
+			return nil, fmt.Errorf("masking file %s: %s: unknown transformer %q (use hash, fake_email, null, fixed, keep_format or shift_dates)", path, name, rule.Transformer)
+		}
+
+		rules.Columns[key] = rule
+	}
+
+	return rules, nil
+}
+
+// ColumnNames returns the masked columns as sorted schema.table.column names
+func (r *Rules) ColumnNames() []string {
+	names := make([]string, 0, len(r.Columns))
+	for name := range r.Columns {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Tables returns the schema.table names that have masked columns
+func (r *Rules) Tables() map[string]bool {
+	tables := make(map[string]bool)
+	for name := range r.Columns {
+		tables[name[:strings.LastIndex(name, ".")]] = true
+	}
+	return tables
+}
+
+// Check fails unless every rule names one of the copied columns, given per schema.table.
+// Names match exactly, so a rule with a typo, another case or quotes around a name is
+// reported instead of leaving the real column unmasked.
+func (r *Rules) Check(copied map[string][]string) error {
+	var unmatched []string
+	for _, name := range r.ColumnNames() {
+		i := strings.LastIndex(name, ".")
+		if !slices.Contains(copied[name[:i]], name[i+1:]) {
+			unmatched = append(unmatched, name)
+		}
+	}
+	if len(unmatched) > 0 {
+		return fmt.Errorf("masking rules match no copied column, names are case-sensitive and unquoted: %s", strings.Join(unmatched, ", "))
+	}
+	return nil
+}
+
+// ForTable returns a masker for a COPY stream of the given columns, or nil when none of
+// them are masked
+func (r *Rules) ForTable(schema, table string, columns []string) *TableMasker {
+	if r == nil {
+		return nil
+	}
+
+	masker := &TableMasker{salt: r.Salt, table: schema + "." + table, columns: columns, rules: make(map[int]Rule)}
+	for i, column := range columns {
+		if rule, ok := r.Columns[schema+"."+table+"."+column]; ok {
+			masker.rules[i] = rule
+		}
+	}
+	if len(masker.rules) == 0 {
+		return nil
+	}
+	return masker
+}
+
+// normalizeColumn accepts schema.table.column or table.column for the public schema
+func normalizeColumn(name string) (string, error) {
+	parts := strings.Split(name, ".")
+	switch len(parts) {
+	case 2:
+		return "public." + name, nil
+	case 3:
+		return name, nil
+	default:
+		return "", fmt.Errorf("column %q must be schema.table.column or table.column", name)
+	}
+}
@@ -0,0 +1,247 @@
+package masking
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// TableMasker rewrites the masked fields of rows in COPY text format
+type TableMasker struct {
+	salt    string
+	table   string
+	columns []string
+	rules   map[int]Rule
+}
+
+// Reader wraps a COPY text stream so every row it yields is already masked
+func (m *TableMasker) Reader(r io.Reader) io.Reader {
+	return &maskingReader{source: bufio.NewReaderSize(r, 64*1024), masker: m}
+}
+
+type maskingReader struct {
+	source  *bufio.Reader
+	masker  *TableMasker
+	pending []byte
+	err     error
+}
+
+func (r *maskingReader) Read(p []byte) (int, error) {
+	for len(r.pending) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		line, err := r.source.ReadBytes('\n')
+		if len(line) > 0 {
+			var maskErr error
+			if r.pending, maskErr = r.masker.maskRow(line); maskErr != nil {
+				r.pending, r.err = nil, maskErr
+				continue
+			}
+		}
+		r.err = err
+	}
+
+	n := copy(p, r.pending)
+	r.pending = r.pending[n:]
+	return n, nil
+}
+
+// maskRow rewrites one row. Fields are split on tabs, which COPY always escapes inside
+// values, so only masked fields need decoding.
+func (m *TableMasker) maskRow(line []byte) ([]byte, error) {
+	body := bytes.TrimSuffix(line, []byte("\n"))
+	fields := bytes.Split(body, []byte("\t"))
+
+	for i, rule := range m.rules {
+		if i >= len(fields) {
+			continue
+		}
+		if string(fields[i]) == `\N` {
+			continue
+		}
+
+		value, isNull, err := m.apply(rule, decodeField(fields[i]))
+		if err != nil {
+			return nil, fmt.Errorf("failed to mask %s.%s: %w", m.table, m.columns[i], err)
+		}
+		if isNull {
+			fields[i] = []byte(`\N`)
+		} else {
+			fields[i] = encodeField(value)
+		}
+	}
+
+	out := bytes.Join(fields, []byte("\t"))
+	if len(body) < len(line) {
+		out = append(out, '\n')
+	}
+	return out, nil
+}
+
+// apply returns the masked value and whether it is NULL
+func (m *TableMasker) apply(rule Rule, value string) (string, bool, error) {
+	switch rule.Transformer {
+	case TransformerNull:
+		return "", true, nil
+	case TransformerFixed:
+		return rule.Value, false, nil
+	case TransformerHash:
+		return hex.EncodeToString(m.digest(value, 0)[:16]), false, nil
+	case TransformerFakeEmail:
+		return "user_" + hex.EncodeToString(m.digest(value, 0)[:16]) + "@example.com", false, nil
+	case TransformerKeepFormat:
+		return m.keepFormat(value), false, nil
+	case TransformerShiftDates:
+		shifted, err := m.shiftDate(value, rule.Days)
+		return shifted, false, err
+	}
+	return value, false, nil
+}
+
+func (m *TableMasker) digest(value string, counter uint32) []byte {
+	h := sha256.New()
+	h.Write([]byte(m.salt))
+	h.Write([]byte{0})
+	h.Write([]byte(value))
+	var c [4]byte
+	binary.BigEndian.PutUint32(c[:], counter)
+	h.Write(c[:])
+	return h.Sum(nil)
+}
+
+// keepFormat replaces letters and digits while keeping their case, the length and any
+// punctuation, so values still pass format checks on the target
+func (m *TableMasker) keepFormat(value string) string {
+	var stream []byte
+	var counter uint32
+	next := func() byte {
+		if len(stream) == 0 {
+			stream = m.digest(value, counter)
+			counter++
+		}
+		b := stream[0]
+		stream = stream[1:]
+		return b
+	}
+
+	var out strings.Builder
+	for _, r := range value {
+		switch {
+		case r >= '0' && r <= '9':
+			out.WriteByte('0' + next()%10)
+		case r < unicode.MaxASCII && unicode.IsUpper(r):
+			out.WriteByte('A' + next()%26)
+		case r < unicode.MaxASCII && unicode.IsLower(r):
+			out.WriteByte('a' + next()%26)
+		default:
+			out.WriteRune(r)
+		}
+	}
+	return out.String()
+}
+
+// shiftDate moves the leading YYYY-MM-DD of a date or timestamp by a deterministic
+// number of days within ±days, leaving any time and zone suffix untouched. infinity and
+// -infinity are kept. Any other value must start with an ISO date, the copier's
+// DateStyle; the value is left out of the error since it is what masking hides.
+func (m *TableMasker) shiftDate(value string, days int) (string, error) {
+	if value == "infinity" || value == "-infinity" {
+		return value, nil
+	}
+	date, err := time.Parse("2006-01-02", value[:min(len(value), 10)])
+	if err != nil {
+		return "", fmt.Errorf("shift_dates needs a value starting with a YYYY-MM-DD date")
+	}
+
+	span := uint64(2*days + 1)
+	offset := int(binary.BigEndian.Uint64(m.digest(value, 0)[:8])%span) - days
+	return date.AddDate(0, 0, offset).Format("2006-01-02") + value[10:], nil
+}
+
+// decodeField undoes COPY text escaping
+func decodeField(field []byte) string {
+	if bytes.IndexByte(field, '\\') < 0 {
+		return string(field)
+	}
+
+	var out strings.Builder
+	for i := 0; i < len(field); i++ {
+		c := field[i]
+		if c != '\\' || i+1 == len(field) {
+			out.WriteByte(c)
+			continue
+		}
+
+		i++
+		switch c = field[i]; c {
+		case 'b':
+			out.WriteByte('\b')
+		case 'f':
+			out.WriteByte('\f')
+		case 'n':
+			out.WriteByte('\n')
+		case 'r':
+			out.WriteByte('\r')
+		case 't':
+			out.WriteByte('\t')
+		case 'v':
+			out.WriteByte('\v')
+		case 'x':
+			end := i + 1
+			for end < len(field) && end < i+3 && isHex(field[end]) {
+				end++
+			}
+			if end == i+1 {
+				out.WriteByte('x')
+				continue
+			}
+			n, _ := strconv.ParseUint(string(field[i+1:end]), 16, 8)
+			out.WriteByte(byte(n))
+			i = end - 1
+		case '0', '1', '2', '3', '4', '5', '6', '7':
+			end := i + 1
+			for end < len(field) && end < i+3 && field[end] >= '0' && field[end] <= '7' {
+				end++
+			}
+			n, _ := strconv.ParseUint(string(field[i:end]), 8, 8)
+			out.WriteByte(byte(n))
+			i = end - 1
+		default:
+			out.WriteByte(c)
+		}
+	}
+	return out.String()
+}
+
+// encodeField applies COPY text escaping
+func encodeField(value string) []byte {
+	out := make([]byte, 0, len(value))
+	for i := 0; i < len(value); i++ {
+		switch c := value[i]; c {
+		case '\\':
+			out = append(out, '\\', '\\')
+		case '\n':
+			out = append(out, '\\', 'n')
+		case '\r':
+			out = append(out, '\\', 'r')
+		case '\t':
+			out = append(out, '\\', 't')
+		default:
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+func isHex(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
@@ -0,0 +1,244 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/crisog/postgres-migrator/internal/logging"
+)
+
+// Phases reported by the phase gauge
+const (
+	PhaseGlobals     = "globals"
+	PhaseDump        = "dump"
+	PhaseRestore     = "restore"
+	PhaseStream      = "stream"
+	PhaseCopy        = "copy"
+	PhaseSubset      = "subset"
+	PhaseReplication = "replication"
+	PhaseValidate    = "validate"
+	PhaseCutover     = "cutover"
+	PhaseCompleted   = "completed"
+	PhaseFailed      = "failed"
+)
+
+var registry = prometheus.NewRegistry()
+
+func init() {
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+}
+
+// Recorder holds the metrics of one run. Its series carry the run's run_id, and the
+// database for one database of a cluster run, so runs sharing the process, like the jobs
+// of the API server, do not overwrite each other. All methods are safe to call on a nil
+// Recorder.
+type Recorder struct {
+	runID string
+
+	mu        sync.Mutex
+	databases []*Recorder
+
+	phase            *prometheus.GaugeVec
+	dumpedBytes      prometheus.Gauge
+	dumpDuration     prometheus.Gauge
+	restoreDuration  prometheus.Gauge
+	tablesRestored   prometheus.Counter
+	validationChecks *prometheus.CounterVec
+	lastError        prometheus.Gauge
+}
+
+// New returns a recorder for a run and adds its series to the exported metrics
+func New(runID string) *Recorder {
+	return newRecorder(runID, "")
+}
+
+func newRecorder(runID, database string) *Recorder {
+	labels := prometheus.Labels{"run_id": runID, "database": database}
+	r := &Recorder{
+		runID: runID,
+		phase: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name:        "postgres_migrator_phase",
+			Help:        "Current phase of the migration, 1 for the active phase",
+			ConstLabels: labels,
+		}, []string{"phase"}),
+		dumpedBytes: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name:        "postgres_migrator_dumped_bytes",
+			Help:        "Size of the last finished dump in bytes",
+			ConstLabels: labels,
+		}),
+		dumpDuration: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name:        "postgres_migrator_dump_duration_seconds",
+			Help:        "Duration of the last finished dump",
+			ConstLabels: labels,
+		}),
+		restoreDuration: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name:        "postgres_migrator_restore_duration_seconds",
+			Help:        "Duration of the last finished restore or row copy",
+			ConstLabels: labels,
+		}),
+		tablesRestored: prometheus.NewCounter(prometheus.CounterOpts{
+			Name:        "postgres_migrator_tables_restored_total",
+			Help:        "Tables whose data was loaded into the target",
+			ConstLabels: labels,
+		}),
+		validationChecks: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name:        "postgres_migrator_validation_checks_total",
+			Help:        "Validation checks run against the target, by result",
+			ConstLabels: labels,
+		}, []string{"result"}),
+		lastError: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name:        "postgres_migrator_last_error_timestamp_seconds",
+			Help:        "Unix time of the last failed migration or validation check",
+			ConstLabels: labels,
+		}),
+	}
+	// Both results are exported from the start, so a rate over failures works before the first one
+	r.validationChecks.WithLabelValues("passed")
+	r.validationChecks.WithLabelValues("failed")
+
+	for _, collector := range r.collectors() {
+		registry.MustRegister(collector)
+	}
+	return r
+}
+
+// Database returns a recorder for one database of a cluster run, whose series are
+// removed when r is closed
+func (r *Recorder) Database(name string) *Recorder {
+	if r == nil {
+		return nil
+	}
+	database := newRecorder(r.runID, name)
+	r.mu.Lock()
+	r.databases = append(r.databases, database)
+	r.mu.Unlock()
+	return database
+}
+
+// Close removes the recorder's series from the exported metrics
+func (r *Recorder) Close() {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	for _, database := range r.databases {
+		database.Close()
+	}
+	r.databases = nil
+	r.mu.Unlock()
+	for _, collector := range r.collectors() {
+		registry.Unregister(collector)
+	}
+}
+
+func (r *Recorder) collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		r.phase, r.dumpedBytes, r.dumpDuration, r.restoreDuration, r.tablesRestored, r.validationChecks, r.lastError,
+	}
+}
+
+func (r *Recorder) SetPhase(name string) {
+	if r == nil {
+		return
+	}
+	r.phase.Reset()
+	r.phase.WithLabelValues(name).Set(1)
+}
+
+// ObserveDump records a finished dump. A negative size means it is unknown and leaves
+// the bytes gauge unchanged.
+func (r *Recorder) ObserveDump(d time.Duration, bytes int64) {
+	if r == nil {
+		return
+	}
+	r.dumpDuration.Set(d.Seconds())
+	if bytes >= 0 {
+		r.dumpedBytes.Set(float64(bytes))
+	}
+}
+
+func (r *Recorder) ObserveRestore(d time.Duration) {
+	if r == nil {
+		return
+	}
+	r.restoreDuration.Set(d.Seconds())
+}
+
+func (r *Recorder) TableRestored() {
+	if r == nil {
+		return
+	}
+	r.tablesRestored.Inc()
+}
+
+// ValidationCheck counts one check as passed, or as failed when err is not nil
+func (r *Recorder) ValidationCheck(err error) {
+	if r == nil {
+		return
+	}
+	if err != nil {
+		r.validationChecks.WithLabelValues("failed").Inc()
+		r.RecordError()
+		return
+	}
+	r.validationChecks.WithLabelValues("passed").Inc()
+}
+
+func (r *Recorder) RecordError() {
+	if r == nil {
+		return
+	}
+	r.lastError.SetToCurrentTime()
+}
+
+type contextKey struct{}
+
+// NewContext returns a context that carries the recorder to migration.Run and validation
+func NewContext(ctx context.Context, r *Recorder) context.Context {
+	return context.WithValue(ctx, contextKey{}, r)
+}
+
+// FromContext returns the recorder in ctx, or nil
+func FromContext(ctx context.Context) *Recorder {
+	r, _ := ctx.Value(contextKey{}).(*Recorder)
+	return r
+}
+
+// Handler serves the metrics in the Prometheus text format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
+
+// Serve listens on addr and serves the metrics on /metrics until the returned server is
+// closed. The listener is opened before Serve returns, so a bad address fails the run.
+func Serve(addr string, logger *slog.Logger) (*http.Server, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start metrics listener: %w", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", Handler())
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+
+	go func() {
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Error("metrics server failed", logging.Err(err))
+		}
+	}()
+
+	return server, nil
+}
@@ -4,39 +4,128 @@ import (
 	"context"
 	"fmt"
 	"io"
-	"log"
+	"io/fs"
+	"log/slog"
+	"net/url"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
 
 	"github.com/crisog/postgres-migrator/internal/config"
+	"github.com/crisog/postgres-migrator/internal/logging"
+	"github.com/crisog/postgres-migrator/internal/storage"
+	"github.com/crisog/postgres-migrator/internal/tracing"
 )
 
 type Dumper struct {
-	config *config.Config
-	logger *log.Logger
+	config     *config.Config
+	logger     *slog.Logger
+	tableSizes map[string]int64
 }
 
-func NewDumper(cfg *config.Config, logger *log.Logger) *Dumper {
+func NewDumper(cfg *config.Config, logger *slog.Logger) *Dumper {
 	return &Dumper{
 		config: cfg,
-		logger: logger,
+		logger: logger.With(logging.Phase("dump")),
 	}
 }
 
-func (d *Dumper) Dump(ctx context.Context, outputFile string) error {
-	d.logger.Println("Starting database dump...")
+// SetTableSizes lets progress events estimate the percent complete, keyed by schema.table
+func (d *Dumper) SetTableSizes(sizes map[string]int64) {
+	d.tableSizes = sizes
+}
+
+func (d *Dumper) Dump(ctx context.Context, outputFile string) (err error) {
+	ctx, span := tracing.Start(ctx, "Dumper.Dump")
+	defer func() { tracing.End(span, err) }()
+
+	d.logger.Info("database dump started")
+
+	if d.config.Encrypted() {
+		if err := d.dumpEncrypted(ctx, outputFile); err != nil {
+			return err
+		}
+	} else if err := d.run(ctx, d.buildDumpArgs(outputFile), nil); err != nil {
+		return err
+	}
+
+	d.logger.Info("database dump completed", logging.File(outputFile))
+	if size, err := ArchiveSize(outputFile); err == nil {
+		span.SetAttributes(tracing.Bytes(size))
+	}
+
+	return nil
+}
+
+// dumpEncrypted streams pg_dump output through the encryptor so the plaintext
+// archive never touches disk
+func (d *Dumper) dumpEncrypted(ctx context.Context, outputFile string) error {
+	d.logger.Info("encrypting archive while dumping")
+
+	file, err := os.OpenFile(outputFile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to create archive: %w", err)
+	}
+	defer file.Close()
+
+	encrypted, err := encryptingWriter(d.config, file)
+	if err != nil {
+		return err
+	}
+
+	if err := d.run(ctx, d.buildDumpArgs(""), encrypted); err != nil {
+		return err
+	}
+
+	if err := encrypted.Close(); err != nil {
+		return fmt.Errorf("failed to finish encryption: %w", err)
+	}
 
+	return file.Close()
+}
+
+// Upload stores the archive at ARCHIVE_URI
+func (d *Dumper) Upload(ctx context.Context, archive string) error {
+	store, err := storage.NewS3Store(d.config, d.logger)
+	if err != nil {
+		return err
+	}
+
+	return store.Upload(ctx, archive, d.config.ArchiveURI)
+}
+
+func (d *Dumper) DumpTo(ctx context.Context, w io.Writer) (err error) {
+	ctx, span := tracing.Start(ctx, "Dumper.DumpTo")
+	defer func() { tracing.End(span, err) }()
+
+	d.logger.Info("streaming database dump started")
+
+	if err := d.run(ctx, d.buildDumpArgs(""), w); err != nil {
+		return err
+	}
+
+	d.logger.Info("streaming database dump completed")
+
+	return nil
+}
+
+func (d *Dumper) run(ctx context.Context, args []string, stdout io.Writer) error {
 	if _, err := exec.LookPath("pg_dump"); err != nil {
 		return fmt.Errorf("pg_dump not found in PATH: %w", err)
 	}
 
-	args := d.buildDumpArgs(outputFile)
+	if err := d.checkCompressionSupport(ctx); err != nil {
+		return err
+	}
 
-	d.logger.Println("Executing pg_dump...")
+	d.logger.Debug("executing pg_dump")
 
 	cmd := exec.CommandContext(ctx, "pg_dump", args...)
-	cmd.Env = append(os.Environ(), fmt.Sprintf("PGPASSWORD=%s", extractPassword(d.config.SourceDatabaseURL)))
+	cmd.Env = commandEnv(extractPassword(d.config.SourceDatabaseURL))
+	cmd.Stdout = stdout
 
 	stderr, err := cmd.StderrPipe()
 	if err != nil {
@@ -47,33 +136,109 @@ func (d *Dumper) Dump(ctx context.Context, outputFile string) error {
 		return fmt.Errorf("failed to start pg_dump: %w", err)
 	}
 
+	parallel := d.config.DumpFormat == config.DumpFormatDirectory && d.config.ParallelJobs > 1
+	progress := newProgressTracker("pg_dump", parallel, d.tableSizes, nil, d.logger)
+
 	errOutput := make(chan string, 1)
 	go func() {
-		stderrBytes, _ := io.ReadAll(stderr)
-		errOutput <- string(stderrBytes)
+		errOutput <- scanVerbose(stderr, progress.handleLine)
 	}()
 
+	stderrStr := <-errOutput
 	if err := cmd.Wait(); err != nil {
-		stderrStr := <-errOutput
 		return fmt.Errorf("pg_dump failed: %w\nStderr: %s", err, stderrStr)
 	}
+	progress.finish()
+
+	return nil
+}
+
+func (d *Dumper) checkCompressionSupport(ctx context.Context) error {
+	spec := d.config.CompressionSpec()
+	minVersion := spec.MinPgDumpVersion()
+	if minVersion == 0 {
+		return nil
+	}
 
-	d.logger.Printf("Database dump completed successfully: %s\n", outputFile)
+	version, err := PgDumpMajorVersion(ctx)
+	if err != nil {
+		return err
+	}
+	if version < minVersion {
+		return fmt.Errorf("compression %s requires pg_dump %d or newer, installed pg_dump is %d", spec, minVersion, version)
+	}
 
 	return nil
 }
 
+var pgDumpVersionPattern = regexp.MustCompile(`\(PostgreSQL\) (\d+)`)
+
+func PgDumpMajorVersion(ctx context.Context) (int, error) {
+	output, err := exec.CommandContext(ctx, "pg_dump", "--version").Output()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get pg_dump version: %w", err)
+	}
+
+	match := pgDumpVersionPattern.FindStringSubmatch(string(output))
+	if match == nil {
+		return 0, fmt.Errorf("unable to parse pg_dump version from %q", strings.TrimSpace(string(output)))
+	}
+
+	return strconv.Atoi(match[1])
+}
+
+// ArchiveSize returns the size of an archive file, or of all files in a directory format archive
+func ArchiveSize(path string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(path, func(_ string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		size += info.Size()
+		return nil
+	})
+	return size, err
+}
+
+func (d *Dumper) Args(outputFile string) []string {
+	return d.buildDumpArgs(outputFile)
+}
+
 func (d *Dumper) buildDumpArgs(outputFile string) []string {
 	args := []string{}
 
 	args = append(args, d.config.SourceDatabaseURL)
 
-	args = append(args, "-Fc")
+	if d.config.DumpFormat == config.DumpFormatDirectory {
+		args = append(args, "-Fd")
+		if d.config.ParallelJobs > 1 {
+			args = append(args, "-j", fmt.Sprintf("%d", d.config.ParallelJobs))
+		}
+	} else {
+		args = append(args, "-Fc")
+	}
 
-	args = append(args, "-f", outputFile)
+	if outputFile != "" {
+		args = append(args, "-f", outputFile)
+	}
 
 	args = append(args, "-v")
 
+	if d.config.SchemaOnly {
+		args = append(args, "--schema-only")
+	}
+
+	if spec := d.config.CompressionSpec(); !spec.IsDefault() {
+		args = append(args, spec.Arg())
+	}
+
 	if d.config.NoOwner {
 		args = append(args, "--no-owner")
 	}
@@ -86,9 +251,48 @@ func (d *Dumper) buildDumpArgs(outputFile string) []string {
 		args = append(args, "--exclude-schema="+schema)
 	}
 
+	for _, pattern := range d.config.IncludeTables {
+		args = append(args, "--table="+pattern)
+	}
+
+	for _, pattern := range d.config.ExcludeTables {
+		args = append(args, "--exclude-table="+pattern)
+	}
+
+	for _, pattern := range d.config.ExcludeTableData {
+		args = append(args, "--exclude-table-data="+pattern)
+	}
+
 	return args
 }
 
+var keywordPasswordPattern = regexp.MustCompile(`password=('(?:[^'\\]|\\.)*'|\S*)`)
+
+func RedactConnectionString(connStr string) string {
+	if strings.HasPrefix(connStr, "postgres://") || strings.HasPrefix(connStr, "postgresql://") {
+		u, err := url.Parse(connStr)
+		if err != nil {
+			return "postgres://redacted"
+		}
+		query := u.Query()
+		if query.Has("password") {
+			query.Set("password", "xxxxx")
+			u.RawQuery = query.Encode()
+		}
+		return u.Redacted()
+	}
+
+	return keywordPasswordPattern.ReplaceAllString(connStr, "password=xxxxx")
+}
+
+func RedactArgs(args []string) []string {
+	redacted := make([]string, len(args))
+	for i, arg := range args {
+		redacted[i] = RedactConnectionString(arg)
+	}
+	return redacted
+}
+
 func extractPassword(connStr string) string {
 	if strings.Contains(connStr, "password=") {
 		parts := strings.Split(connStr, "password=")
@@ -0,0 +1,112 @@
+package migrator
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"filippo.io/age"
+	"github.com/crisog/postgres-migrator/internal/config"
+)
+
+const ageHeader = "age-encryption.org/v1\n"
+
+// Secrets are only ever read from the environment, so they are stripped from the
+// environment of pg_dump/pg_restore rather than inherited by them
+var sensitiveEnvVars = []string{
+	"ENCRYPTION_PASSPHRASE",
+	"ENCRYPTION_IDENTITY",
+	"S3_SECRET_ACCESS_KEY",
+}
+
+func commandEnv(password string) []string {
+	env := make([]string, 0, len(os.Environ())+1)
+	for _, kv := range os.Environ() {
+		name, _, _ := strings.Cut(kv, "=")
+		sensitive := false
+		for _, secret := range sensitiveEnvVars {
+			if name == secret {
+				sensitive = true
+				break
+			}
+		}
+		if !sensitive {
+			env = append(env, kv)
+		}
+	}
+	return append(env, fmt.Sprintf("PGPASSWORD=%s", password))
+}
+
+func encryptingWriter(cfg *config.Config, dst io.Writer) (io.WriteCloser, error) {
+	var recipient age.Recipient
+	if cfg.EncryptionPassphrase != "" {
+		scrypt, err := age.NewScryptRecipient(cfg.EncryptionPassphrase)
+		if err != nil {
+			return nil, fmt.Errorf("invalid encryption passphrase: %w", err)
+		}
+		recipient = scrypt
+	} else {
+		x25519, err := age.ParseX25519Recipient(cfg.EncryptionRecipient)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ENCRYPTION_RECIPIENT: %w", err)
+		}
+		recipient = x25519
+	}
+
+	w, err := age.Encrypt(dst, recipient)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start encryption: %w", err)
+	}
+	return w, nil
+}
+
+func decryptingReader(cfg *config.Config, src io.Reader) (io.Reader, error) {
+	var identities []age.Identity
+	if cfg.EncryptionPassphrase != "" {
+		scrypt, err := age.NewScryptIdentity(cfg.EncryptionPassphrase)
+		if err != nil {
+			return nil, fmt.Errorf("invalid encryption passphrase: %w", err)
+		}
+		identities = append(identities, scrypt)
+	}
+	if cfg.EncryptionIdentity != "" {
+		x25519, err := age.ParseX25519Identity(cfg.EncryptionIdentity)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ENCRYPTION_IDENTITY: %w", err)
+		}
+		identities = append(identities, x25519)
+	}
+	if len(identities) == 0 {
+		return nil, fmt.Errorf("archive is encrypted, set ENCRYPTION_PASSPHRASE or ENCRYPTION_IDENTITY to restore it")
+	}
+
+	r, err := age.Decrypt(src, identities...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt archive: %w", err)
+	}
+	return r, nil
+}
+
+func IsEncrypted(path string) (bool, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return false, err
+	}
+	if info.IsDir() {
+		return false, nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	header := make([]byte, len(ageHeader))
+	n, err := io.ReadFull(file, header)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return false, err
+	}
+	return string(header[:n]) == ageHeader, nil
+}
@@ -0,0 +1,177 @@
+package migrator
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strings"
+
+	"github.com/crisog/postgres-migrator/internal/config"
+	"github.com/jackc/pgx/v5"
+)
+
+// Kinds of statements in a pg_dumpall --globals-only script
+const (
+	GlobalSession        = "session"
+	GlobalCreateRole     = "create_role"
+	GlobalRoleAttributes = "role_attributes"
+	GlobalRoleSetting    = "role_setting"
+	GlobalRoleMembership = "role_membership"
+	GlobalParameterGrant = "parameter_grant"
+	GlobalTablespace     = "tablespace"
+	GlobalOther          = "other"
+)
+
+// GlobalStatement is one statement of a globals script. Role is set for statements
+// that create or alter a role, Database for role settings that only apply in one database.
+type GlobalStatement struct {
+	SQL      string
+	Kind     string
+	Role     string
+	Database string
+
+	databaseStart, databaseEnd int
+}
+
+// InDatabase returns the SQL of a per-database role setting applied to another database
+func (s GlobalStatement) InDatabase(name string) string {
+	if s.Database == "" {
+		return s.SQL
+	}
+	return s.SQL[:s.databaseStart] + pgx.Identifier{name}.Sanitize() + s.SQL[s.databaseEnd:]
+}
+
+// DumpGlobals returns the roles, role memberships, role settings and, unless
+// GLOBALS_SKIP_TABLESPACES is set, tablespaces of the source server as a SQL script.
+// With GLOBALS_PASSWORDS=placeholder the password hashes are left out, which also lets
+// the dump run without read access to pg_authid.
+func DumpGlobals(ctx context.Context, cfg *config.Config) (string, error) {
+	if _, err := exec.LookPath("pg_dumpall"); err != nil {
+		return "", fmt.Errorf("pg_dumpall not found in PATH: %w", err)
+	}
+
+	args := []string{"--dbname=" + cfg.SourceDatabaseURL, "--globals-only"}
+	if cfg.GlobalsSkipTablespaces {
+		args = append(args, "--no-tablespaces")
+	}
+	if cfg.GlobalsPasswords == config.GlobalsPasswordsPlaceholder {
+		args = append(args, "--no-role-passwords")
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "pg_dumpall", args...)
+	cmd.Env = commandEnv(extractPassword(cfg.SourceDatabaseURL))
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("pg_dumpall failed: %w\nStderr: %s", err, stderr.String())
+	}
+
+	return stdout.String(), nil
+}
+
+var (
+	roleStatementPattern  = regexp.MustCompile(`^(?:CREATE|ALTER) ROLE ("(?:[^"]|"")*"|[^\s;]+)\s*(WITH\b)?`)
+	inDatabasePattern     = regexp.MustCompile(`^ALTER ROLE (?:"(?:[^"]|"")*"|[^\s;]+) IN DATABASE ("(?:[^"]|"")*"|[^\s;]+) `)
+	parameterGrantPattern = regexp.MustCompile(`^(?:GRANT|REVOKE) .* ON PARAMETER `)
+	tablespacePattern     = regexp.MustCompile(`^(?:(?:CREATE|ALTER) TABLESPACE |(?:COMMENT|SECURITY LABEL(?: FOR \S+)?) ON TABLESPACE |(?:GRANT|REVOKE) .* ON TABLESPACE )`)
+)
+
+// SplitGlobals splits a globals script into statements, dropping comments and psql
+// meta-commands
+func SplitGlobals(script string) []GlobalStatement {
+	var statements []GlobalStatement
+	for _, sql := range splitStatements(script) {
+		statements = append(statements, classifyGlobal(sql))
+	}
+	return statements
+}
+
+func classifyGlobal(sql string) GlobalStatement {
+	statement := GlobalStatement{SQL: sql, Kind: GlobalOther}
+
+	switch {
+	case strings.HasPrefix(sql, "SET "):
+		statement.Kind = GlobalSession
+	case strings.HasPrefix(sql, "CREATE ROLE "), strings.HasPrefix(sql, "ALTER ROLE "):
+		match := roleStatementPattern.FindStringSubmatch(sql)
+		if match == nil {
+			break
+		}
+		statement.Role = unquoteIdentifier(match[1])
+		switch {
+		case strings.HasPrefix(sql, "CREATE ROLE "):
+			statement.Kind = GlobalCreateRole
+		case match[2] != "":
+			statement.Kind = GlobalRoleAttributes
+		default:
+			statement.Kind = GlobalRoleSetting
+			if match := inDatabasePattern.FindStringSubmatchIndex(sql); match != nil {
+				statement.databaseStart, statement.databaseEnd = match[2], match[3]
+				statement.Database = unquoteIdentifier(sql[match[2]:match[3]])
+			}
+		}
+	case tablespacePattern.MatchString(sql):
+		statement.Kind = GlobalTablespace
+	case parameterGrantPattern.MatchString(sql):
+		statement.Kind = GlobalParameterGrant
+	case strings.HasPrefix(sql, "GRANT ") && !strings.Contains(sql, " ON "):
+		statement.Kind = GlobalRoleMembership
+	}
+
+	return statement
+}
+
+// splitStatements splits on semicolons outside of quotes. pg_dumpall only writes single
+// quoted literals and double quoted identifiers in a globals script.
+func splitStatements(script string) []string {
+	var (
+		statements []string
+		current    strings.Builder
+		quote      byte
+	)
+
+	for _, line := range strings.SplitAfter(script, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if quote == 0 && current.Len() == 0 && (trimmed == "" || strings.HasPrefix(trimmed, "--") || strings.HasPrefix(trimmed, `\`)) {
+			continue
+		}
+
+		for i := 0; i < len(line); i++ {
+			c := line[i]
+			switch {
+			case quote != 0:
+				if c == quote {
+					quote = 0
+				}
+			case c == '\'' || c == '"':
+				quote = c
+			case c == '-' && i+1 < len(line) && line[i+1] == '-':
+				current.WriteByte('\n')
+				i = len(line)
+				continue
+			case c == ';':
+				if sql := strings.TrimSpace(current.String()); sql != "" {
+					statements = append(statements, sql+";")
+				}
+				current.Reset()
+				continue
+			}
+			current.WriteByte(c)
+		}
+	}
+
+	if sql := strings.TrimSpace(current.String()); sql != "" {
+		statements = append(statements, sql)
+	}
+	return statements
+}
+
+func unquoteIdentifier(name string) string {
+	if len(name) >= 2 && name[0] == '"' && name[len(name)-1] == '"' {
+		return strings.ReplaceAll(name[1:len(name)-1], `""`, `"`)
+	}
+	return name
+}
@@ -0,0 +1,222 @@
+package migrator
+
+import (
+	"log/slog"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/crisog/postgres-migrator/internal/logging"
+	"github.com/crisog/postgres-migrator/internal/metrics"
+)
+
+type EventKind string
+
+const (
+	EventTableDataStarted  EventKind = "table data started"
+	EventTableDataFinished EventKind = "table data finished"
+	EventIndexCreated      EventKind = "index created"
+	EventConstraintAdded   EventKind = "constraint added"
+)
+
+// Event is one step parsed from pg_dump or pg_restore verbose output
+type Event struct {
+	// Tool is pg_dump or pg_restore
+	Tool   string
+	Kind   EventKind
+	Object string
+	// Elapsed is the time since the tool started
+	Elapsed time.Duration
+	// Percent is the share of table data finished, estimated from source table sizes,
+	// or -1 when sizes are unknown
+	Percent float64
+}
+
+var (
+	tableDataStartPattern = regexp.MustCompile(`^(?:processing data for table|dumping contents of table) "?(.+?)"?$`)
+	creatingPattern       = regexp.MustCompile(`^creating (INDEX|CONSTRAINT|FK CONSTRAINT) "(.+)"$`)
+	itemPattern           = regexp.MustCompile(`^(launching|finished) item (\d+) (TABLE DATA|INDEX|CONSTRAINT|FK CONSTRAINT) (.+)$`)
+)
+
+// progressTracker turns verbose output into events. Serial runs process one item at a
+// time, so an item is done once the next one starts; parallel runs report completion
+// explicitly with "finished item", which carries the item's dump ID and only names the
+// object without its schema.
+type progressTracker struct {
+	tool     string
+	parallel bool
+	recorder *metrics.Recorder
+	logger   *slog.Logger
+	start    time.Time
+
+	// objects maps dump IDs to schema-qualified objects, when the archive's TOC is known
+	objects map[int]string
+
+	sizes     map[string]int64
+	totalSize int64
+	doneSize  int64
+
+	current *trackedItem
+	running map[string]trackedItem
+}
+
+type trackedItem struct {
+	kind   EventKind
+	object string
+}
+
+// newProgressTracker creates a tracker for one tool run. sizes maps schema.table to the
+// table's size and may be empty, in which case events carry no percentage.
+func newProgressTracker(tool string, parallel bool, sizes map[string]int64, recorder *metrics.Recorder, logger *slog.Logger) *progressTracker {
+	t := &progressTracker{
+		tool:     tool,
+		parallel: parallel,
+		recorder: recorder,
+		logger:   logger,
+		start:    time.Now(),
+		sizes:    sizes,
+		running:  make(map[string]trackedItem),
+	}
+	for _, size := range sizes {
+		t.totalSize += size
+	}
+	return t
+}
+
+func (t *progressTracker) handleLine(line string) {
+	msg := strings.TrimPrefix(strings.TrimPrefix(line, t.tool+": "), "info: ")
+
+	if match := itemPattern.FindStringSubmatch(msg); match != nil {
+		t.parallel = true
+		if match[1] == "finished" {
+			id, _ := strconv.Atoi(match[2])
+			t.finishItem(id, match[3], match[4])
+		}
+		return
+	}
+
+	var next *trackedItem
+	if match := tableDataStartPattern.FindStringSubmatch(msg); match != nil {
+		next = &trackedItem{kind: EventTableDataFinished, object: match[1]}
+	} else if match := creatingPattern.FindStringSubmatch(msg); match != nil {
+		kind := EventConstraintAdded
+		if match[1] == "INDEX" {
+			kind = EventIndexCreated
+		}
+		next = &trackedItem{kind: kind, object: match[2]}
+	} else if !startsItem(msg) {
+		return
+	}
+
+	if !t.parallel {
+		t.finishCurrent()
+	}
+	if next == nil {
+		return
+	}
+
+	if next.kind == EventTableDataFinished {
+		t.emit(EventTableDataStarted, next.object)
+	}
+	if t.parallel {
+		t.running[next.object] = *next
+	} else {
+		t.current = next
+	}
+}
+
+// finish reports the item still in progress once the tool has exited successfully
+func (t *progressTracker) finish() {
+	t.finishCurrent()
+	for object, item := range t.running {
+		t.complete(item)
+		delete(t.running, object)
+	}
+}
+
+func (t *progressTracker) finishCurrent() {
+	if t.current != nil {
+		t.complete(*t.current)
+		t.current = nil
+	}
+}
+
+// finishItem resolves a "finished item" line to a running item by its dump ID. Without
+// a TOC only the tag is known, the object without its schema like "users" or "users
+// users_pkey" for a constraint, so the item is completed only when a single running item
+// matches it; ambiguous items are completed once the tool exits.
+func (t *progressTracker) finishItem(id int, desc, tag string) {
+	kind := EventConstraintAdded
+	switch desc {
+	case "TABLE DATA":
+		kind = EventTableDataFinished
+	case "INDEX":
+		kind = EventIndexCreated
+	}
+
+	if object, ok := t.objects[id]; ok {
+		delete(t.running, object)
+		t.complete(trackedItem{kind: kind, object: object})
+		return
+	}
+
+	var matched []string
+	for object, item := range t.running {
+		if item.kind == kind && (object == tag || strings.HasSuffix(object, "."+tag)) {
+			matched = append(matched, object)
+		}
+	}
+	if len(matched) == 1 {
+		t.complete(t.running[matched[0]])
+		delete(t.running, matched[0])
+	}
+}
+
+func (t *progressTracker) complete(item trackedItem) {
+	if item.kind == EventTableDataFinished {
+		t.doneSize += t.sizes[item.object]
+		if t.tool == "pg_restore" {
+			t.recorder.TableRestored()
+		}
+	}
+	t.emit(item.kind, item.object)
+}
+
+func (t *progressTracker) emit(kind EventKind, object string) {
+	event := Event{
+		Tool:    t.tool,
+		Kind:    kind,
+		Object:  object,
+		Elapsed: time.Since(t.start),
+		Percent: -1,
+	}
+	if t.totalSize > 0 {
+		event.Percent = 100 * float64(t.doneSize) / float64(t.totalSize)
+	}
+	attrs := []any{
+		slog.String("tool", event.Tool),
+		slog.String("event", string(event.Kind)),
+		slog.String("object", event.Object),
+		logging.Duration(event.Elapsed),
+	}
+	if event.Kind == EventTableDataStarted || event.Kind == EventTableDataFinished {
+		attrs = append(attrs, logging.Table(event.Object))
+	}
+	if event.Percent >= 0 {
+		attrs = append(attrs, slog.Float64("percent", math.Round(event.Percent*10)/10))
+	}
+	t.logger.Info("progress", attrs...)
+}
+
+// startsItem reports whether a verbose line marks the start of any archive item, which
+// ends the previous one in a serial run
+func startsItem(msg string) bool {
+	for _, prefix := range []string{"creating ", "processing ", "executing ", "dumping contents of ", "reading "} {
+		if strings.HasPrefix(msg, prefix) {
+			return true
+		}
+	}
+	return false
+}
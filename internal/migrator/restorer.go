@@ -1,45 +1,198 @@
 package migrator
 
 import (
+	"bufio"
 	"context"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"os"
 	"os/exec"
+	"strings"
 
 	"github.com/crisog/postgres-migrator/internal/config"
+	"github.com/crisog/postgres-migrator/internal/logging"
+	"github.com/crisog/postgres-migrator/internal/metrics"
+	"github.com/crisog/postgres-migrator/internal/storage"
+	"github.com/crisog/postgres-migrator/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 type Restorer struct {
-	config *config.Config
-	logger *log.Logger
+	config     *config.Config
+	logger     *slog.Logger
+	tableSizes map[string]int64
 }
 
-func NewRestorer(cfg *config.Config, logger *log.Logger) *Restorer {
+func NewRestorer(cfg *config.Config, logger *slog.Logger) *Restorer {
 	return &Restorer{
 		config: cfg,
-		logger: logger,
+		logger: logger.With(logging.Phase("restore")),
 	}
 }
 
-func (r *Restorer) Restore(ctx context.Context, inputFile string) error {
-	r.logger.Println("Starting database restore...")
+// SetTableSizes lets progress events estimate the percent complete, keyed by schema.table
+func (r *Restorer) SetTableSizes(sizes map[string]int64) {
+	r.tableSizes = sizes
+}
+
+func (r *Restorer) Restore(ctx context.Context, inputFile string) (err error) {
+	ctx, span := tracing.Start(ctx, "Restorer.Restore", r.spanAttributes(inputFile)...)
+	defer func() { tracing.End(span, err) }()
+
+	r.logger.Info("database restore started")
+
+	encrypted, err := IsEncrypted(inputFile)
+	if err != nil {
+		return fmt.Errorf("failed to inspect archive: %w", err)
+	}
+	if encrypted {
+		return r.restoreEncrypted(ctx, inputFile)
+	}
+
+	return r.restoreCustomFormat(ctx, inputFile, restoreOptions{})
+}
+
+// restoreEncrypted decrypts the archive on the fly into pg_restore's stdin, so the
+// plaintext never touches disk. Parallel restore needs a seekable file and is skipped.
+func (r *Restorer) restoreEncrypted(ctx context.Context, inputFile string) error {
+	if r.config.ParallelJobs > 1 {
+		r.logger.Info("archive is encrypted, restoring with a single job since parallel restore needs a seekable archive")
+	} else {
+		r.logger.Info("archive is encrypted, decrypting while restoring")
+	}
+
+	file, err := os.Open(inputFile)
+	if err != nil {
+		return fmt.Errorf("failed to open archive: %w", err)
+	}
+	defer file.Close()
+
+	decrypted, err := decryptingReader(r.config, file)
+	if err != nil {
+		return err
+	}
+
+	return r.restoreCustomFormat(ctx, "", restoreOptions{stdin: decrypted})
+}
+
+// Fetch downloads the archive at ARCHIVE_URI to localPath, verifying its checksum
+func (r *Restorer) Fetch(ctx context.Context, localPath string) error {
+	store, err := storage.NewS3Store(r.config, r.logger)
+	if err != nil {
+		return err
+	}
+
+	return store.Download(ctx, r.config.ArchiveURI, localPath)
+}
+
+func (r *Restorer) RestoreFrom(ctx context.Context, input io.Reader) (err error) {
+	ctx, span := tracing.Start(ctx, "Restorer.RestoreFrom", r.spanAttributes("")...)
+	defer func() { tracing.End(span, err) }()
+
+	r.logger.Info("streaming database restore started")
+
+	return r.restoreCustomFormat(ctx, "", restoreOptions{stdin: input})
+}
+
+// RestoreWithState restores only the TOC entries not yet recorded in state, checkpointing
+// the entries pg_restore reports as done so an interrupted restore can be resumed
+func (r *Restorer) RestoreWithState(ctx context.Context, inputFile string, state *State) (err error) {
+	ctx, span := tracing.Start(ctx, "Restorer.RestoreWithState", r.spanAttributes(inputFile)...)
+	defer func() { tracing.End(span, err) }()
+
+	if encrypted, err := IsEncrypted(inputFile); err != nil {
+		return fmt.Errorf("failed to inspect archive: %w", err)
+	} else if encrypted {
+		return fmt.Errorf("encrypted archives cannot be restored with STATE_FILE, they can only be restored in one pass")
+	}
+
+	entries, err := ListTOC(ctx, inputFile)
+	if err != nil {
+		return err
+	}
+
+	listFile, err := os.CreateTemp("", "postgres-migrator-*.list")
+	if err != nil {
+		return fmt.Errorf("failed to create restore list file: %w", err)
+	}
+	defer os.Remove(listFile.Name())
 
-	return r.restoreCustomFormat(ctx, inputFile)
+	var remaining []TOCEntry
+	for _, entry := range entries {
+		if state.IsRestored(entry.ID) {
+			continue
+		}
+		remaining = append(remaining, entry)
+		if _, err := fmt.Fprintln(listFile, entry.Line); err != nil {
+			listFile.Close()
+			return fmt.Errorf("failed to write restore list file: %w", err)
+		}
+	}
+	if err := listFile.Close(); err != nil {
+		return fmt.Errorf("failed to write restore list file: %w", err)
+	}
+
+	if skipped := len(entries) - len(remaining); skipped > 0 {
+		r.logger.Info("resuming restore", "restored_entries", skipped, "total_entries", len(entries), "remaining_entries", len(remaining))
+	} else {
+		r.logger.Info("database restore started")
+	}
+
+	tracker := newRestoreTracker(remaining, state.MarkRestored)
+	onLine := func(line string) {
+		tracker.handleLine(line)
+		if err := state.Checkpoint(); err != nil {
+			r.logger.Warn("failed to checkpoint restore progress", logging.Err(err))
+		}
+	}
+
+	err = r.restoreCustomFormat(ctx, inputFile, restoreOptions{entries: remaining, listFile: listFile.Name(), onLine: onLine, onSuccess: tracker.finish})
+	if saveErr := state.Save(); saveErr != nil {
+		if err != nil {
+			r.logger.Warn("failed to checkpoint restore progress", logging.Err(saveErr))
+			return err
+		}
+		return saveErr
+	}
+	return err
+}
+
+type restoreOptions struct {
+	stdin io.Reader
+	// entries is the archive's TOC, listed here for parallel restores when not set
+	entries  []TOCEntry
+	listFile string
+	onLine   func(line string)
+	// onSuccess runs only when pg_restore exits cleanly
+	onSuccess func()
 }
 
-func (r *Restorer) restoreCustomFormat(ctx context.Context, inputFile string) error {
+func (r *Restorer) restoreCustomFormat(ctx context.Context, inputFile string, opts restoreOptions) error {
 	if _, err := exec.LookPath("pg_restore"); err != nil {
 		return fmt.Errorf("pg_restore not found in PATH: %w", err)
 	}
 
+	// Parallel restores report finished items by dump ID, which the TOC resolves to the
+	// schema-qualified object
+	parallel := r.config.ParallelJobs > 1 && inputFile != ""
+	if parallel && opts.entries == nil {
+		entries, err := ListTOC(ctx, inputFile)
+		if err != nil {
+			return err
+		}
+		opts.entries = entries
+	}
+
 	args := r.buildRestoreArgs(inputFile)
+	if opts.listFile != "" {
+		args = append(args, "-L", opts.listFile)
+	}
 
-	r.logger.Println("Executing pg_restore...")
+	r.logger.Debug("executing pg_restore")
 
 	cmd := exec.CommandContext(ctx, "pg_restore", args...)
-	cmd.Env = append(os.Environ(), fmt.Sprintf("PGPASSWORD=%s", extractPassword(r.config.TargetDatabaseURL)))
+	cmd.Env = commandEnv(extractPassword(r.config.TargetDatabaseURL))
 
 	stderr, err := cmd.StderrPipe()
 	if err != nil {
@@ -47,44 +200,96 @@ func (r *Restorer) restoreCustomFormat(ctx context.Context, inputFile string) er
 	}
 
 	cmd.Stdout = os.Stdout
+	cmd.Stdin = opts.stdin
 
 	if err := cmd.Start(); err != nil {
 		return fmt.Errorf("failed to start pg_restore: %w", err)
 	}
 
+	progress := newProgressTracker("pg_restore", parallel, r.tableSizes, metrics.FromContext(ctx), r.logger)
+	progress.objects = tocObjects(opts.entries)
+
 	errOutput := make(chan string, 1)
 	go func() {
-		stderrBytes, _ := io.ReadAll(stderr)
-		errOutput <- string(stderrBytes)
+		errOutput <- scanVerbose(stderr, func(line string) {
+			progress.handleLine(line)
+			if opts.onLine != nil {
+				opts.onLine(line)
+			}
+		})
 	}()
 
-	waitErr := cmd.Wait()
 	stderrStr := <-errOutput
+	waitErr := cmd.Wait()
+	if waitErr == nil {
+		progress.finish()
+		if opts.onSuccess != nil {
+			opts.onSuccess()
+		}
+	}
 
 	if waitErr != nil {
 		// exit-on-error flag causes pg_restore to exit immediately on error
 		// Without it, exit code 1 just means there were warnings
 		if r.config.NoOwner {
-			// When using --no-owner, we tolerate exit code 1 (warnings)
+			// When using --no-owner, we tolerate exit code 1 (warnings). Entries that
+			// failed are not reported as such, so onSuccess is skipped and only those
+			// seen finishing stay checkpointed
 			if exitErr, ok := waitErr.(*exec.ExitError); ok && exitErr.ExitCode() == 1 {
-				r.logger.Println("Database restore completed with warnings (some non-fatal errors were ignored)")
+				progress.finish()
+				r.logger.Warn("database restore completed with warnings, some non-fatal errors were ignored")
 				return nil
 			}
 		}
 		return fmt.Errorf("pg_restore failed: %w\nStderr: %s", waitErr, stderrStr)
 	}
 
-	r.logger.Println("Database restore completed successfully")
+	r.logger.Info("database restore completed")
 
 	return nil
 }
 
+// scanVerbose hands every stderr line to onLine and returns the whole output for errors
+func scanVerbose(stderr io.Reader, onLine func(line string)) string {
+	var output strings.Builder
+	scanner := bufio.NewScanner(stderr)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		output.WriteString(line)
+		output.WriteString("\n")
+		onLine(line)
+	}
+	// Keep draining so the tool never blocks on a full pipe
+	_, _ = io.Copy(io.Discard, stderr)
+	return output.String()
+}
+
+func (r *Restorer) spanAttributes(inputFile string) []attribute.KeyValue {
+	attrs := []attribute.KeyValue{attribute.Int("parallel_jobs", r.config.ParallelJobs)}
+	if r.config.Section != "" {
+		attrs = append(attrs, attribute.String("section", r.config.Section))
+	}
+	if inputFile != "" {
+		if size, err := ArchiveSize(inputFile); err == nil {
+			attrs = append(attrs, tracing.Bytes(size))
+		}
+	}
+	return attrs
+}
+
+func (r *Restorer) Args(inputFile string) []string {
+	return r.buildRestoreArgs(inputFile)
+}
+
 func (r *Restorer) buildRestoreArgs(inputFile string) []string {
 	args := []string{}
 
 	args = append(args, "-d", r.config.TargetDatabaseURL)
 
-	args = append(args, inputFile)
+	if inputFile != "" {
+		args = append(args, inputFile)
+	}
 
 	args = append(args, "-v")
 
@@ -103,7 +308,12 @@ func (r *Restorer) buildRestoreArgs(inputFile string) []string {
 		args = append(args, "--data-only", "--disable-triggers")
 	}
 
-	if r.config.ParallelJobs > 1 {
+	if r.config.Section != "" {
+		args = append(args, "--section="+r.config.Section)
+	}
+
+	// Parallel restore needs a seekable archive, so it is not possible when reading from stdin
+	if r.config.ParallelJobs > 1 && inputFile != "" {
 		args = append(args, "-j", fmt.Sprintf("%d", r.config.ParallelJobs))
 	}
 
@@ -0,0 +1,127 @@
+package migrator
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+const (
+	PhaseDumping   = "dumping"
+	PhaseDumped    = "dumped"
+	PhaseRestoring = "restoring"
+	PhaseCompleted = "completed"
+)
+
+// Restored entries are written out in batches, since rewriting the whole file for every
+// entry gets slow on archives with many thousands of them
+const (
+	checkpointEntries  = 100
+	checkpointInterval = 5 * time.Second
+)
+
+type State struct {
+	Phase           string    `json:"phase"`
+	DumpPath        string    `json:"dump_path"`
+	RestoredEntries []int     `json:"restored_entries,omitempty"`
+	UpdatedAt       time.Time `json:"updated_at"`
+
+	path     string
+	restored map[int]bool
+	unsaved  int
+	savedAt  time.Time
+}
+
+func NewState(path string) *State {
+	return &State{path: path, restored: make(map[int]bool)}
+}
+
+// LoadState reads the state file at path, returning a fresh state when none exists yet
+func LoadState(path string) (*State, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return NewState(path), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state file: %w", err)
+	}
+
+	state := NewState(path)
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("failed to parse state file %s: %w", path, err)
+	}
+	for _, id := range state.RestoredEntries {
+		state.restored[id] = true
+	}
+
+	return state, nil
+}
+
+func (s *State) Unfinished() bool {
+	return s.Phase != "" && s.Phase != PhaseCompleted
+}
+
+func (s *State) IsRestored(id int) bool {
+	return s.restored[id]
+}
+
+// MarkRestored records id in memory; Checkpoint or Save writes it out
+func (s *State) MarkRestored(id int) {
+	if s.restored[id] {
+		return
+	}
+	s.restored[id] = true
+	s.RestoredEntries = append(s.RestoredEntries, id)
+	s.unsaved++
+}
+
+// Checkpoint saves the state once enough entries were marked or enough time has passed
+// since the last save
+func (s *State) Checkpoint() error {
+	if s.unsaved == 0 || (s.unsaved < checkpointEntries && time.Since(s.savedAt) < checkpointInterval) {
+		return nil
+	}
+	return s.Save()
+}
+
+func (s *State) SetPhase(phase string) error {
+	s.Phase = phase
+	return s.Save()
+}
+
+// Save writes the state atomically so a crash never leaves a truncated file behind
+func (s *State) Save() error {
+	sort.Ints(s.RestoredEntries)
+	s.UpdatedAt = time.Now().UTC()
+
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode state: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+
+	s.unsaved = 0
+	s.savedAt = time.Now()
+	return nil
+}
@@ -0,0 +1,36 @@
+package migrator
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStateCheckpoint(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	state := NewState(path)
+	require.NoError(t, state.SetPhase(PhaseRestoring))
+
+	for id := 1; id < checkpointEntries; id++ {
+		state.MarkRestored(id)
+		require.NoError(t, state.Checkpoint())
+	}
+	loaded, err := LoadState(path)
+	require.NoError(t, err)
+	require.Empty(t, loaded.RestoredEntries, "marked entries should not be written one by one")
+
+	state.MarkRestored(checkpointEntries)
+	require.NoError(t, state.Checkpoint())
+	loaded, err = LoadState(path)
+	require.NoError(t, err)
+	require.Len(t, loaded.RestoredEntries, checkpointEntries)
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	require.NoError(t, state.Checkpoint())
+	after, err := os.Stat(path)
+	require.NoError(t, err)
+	require.Equal(t, info.ModTime(), after.ModTime(), "a checkpoint without new entries should not write")
+}
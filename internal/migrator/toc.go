@@ -0,0 +1,271 @@
+package migrator
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+type TOCEntry struct {
+	ID     int
+	Desc   string
+	Schema string
+	Tag    string
+	Line   string
+}
+
+// Object types whose description spans several words in pg_restore -l output
+var multiWordDescs = []string{
+	"SEQUENCE OWNED BY",
+	"PUBLICATION TABLES IN SCHEMA",
+	"TEXT SEARCH CONFIGURATION",
+	"TEXT SEARCH DICTIONARY",
+	"TEXT SEARCH PARSER",
+	"TEXT SEARCH TEMPLATE",
+	"MATERIALIZED VIEW DATA",
+	"MATERIALIZED VIEW",
+	"FOREIGN DATA WRAPPER",
+	"FOREIGN TABLE",
+	"DATABASE PROPERTIES",
+	"PROCEDURAL LANGUAGE",
+	"PUBLICATION TABLE",
+	"CHECK CONSTRAINT",
+	"DEFAULT ACL",
+	"EVENT TRIGGER",
+	"FK CONSTRAINT",
+	"INDEX ATTACH",
+	"LARGE OBJECTS",
+	"LARGE OBJECT",
+	"OPERATOR CLASS",
+	"OPERATOR FAMILY",
+	"ACCESS METHOD",
+	"ROW SECURITY",
+	"SEQUENCE SET",
+	"SHELL TYPE",
+	"STATISTICS DATA",
+	"TABLE ATTACH",
+	"TABLE DATA",
+	"USER MAPPING",
+}
+
+func ListTOC(ctx context.Context, archive string) ([]TOCEntry, error) {
+	output, err := exec.CommandContext(ctx, "pg_restore", "-l", archive).Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list archive contents: %w", err)
+	}
+
+	return parseTOC(string(output)), nil
+}
+
+// tocObjects maps the dump ID of each entry to its object as pg_restore names it in
+// verbose output, like "public.users" or "public.users users_pkey"
+func tocObjects(entries []TOCEntry) map[int]string {
+	objects := make(map[int]string, len(entries))
+	for _, entry := range entries {
+		objects[entry.ID] = entry.Schema + "." + entry.Tag
+	}
+	return objects
+}
+
+func parseTOC(list string) []TOCEntry {
+	var entries []TOCEntry
+	for _, line := range strings.Split(list, "\n") {
+		if entry, ok := parseTOCLine(line); ok {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// parseTOCLine parses a line such as "218; 1259 16386 TABLE public users postgres"
+func parseTOCLine(line string) (TOCEntry, bool) {
+	idPart, rest, found := strings.Cut(line, "; ")
+	if !found || strings.HasPrefix(line, ";") {
+		return TOCEntry{}, false
+	}
+
+	id, err := strconv.Atoi(idPart)
+	if err != nil {
+		return TOCEntry{}, false
+	}
+
+	fields := strings.Split(rest, " ")
+	if len(fields) < 5 {
+		return TOCEntry{}, false
+	}
+	fields = fields[2:]
+
+	desc := fields[0]
+	remaining := strings.Join(fields, " ")
+	for _, candidate := range multiWordDescs {
+		if strings.HasPrefix(remaining, candidate+" ") {
+			desc = candidate
+			break
+		}
+	}
+	fields = strings.Split(strings.TrimPrefix(remaining, desc+" "), " ")
+	if len(fields) < 3 {
+		return TOCEntry{}, false
+	}
+
+	return TOCEntry{
+		ID:     id,
+		Desc:   desc,
+		Schema: fields[0],
+		Tag:    strings.Join(fields[1:len(fields)-1], " "),
+		Line:   line,
+	}, true
+}
+
+// verboseMessages returns the messages pg_restore -v may log when it starts processing
+// the entry: "creating" for its definition, "processing" or "executing" for its data
+func (e TOCEntry) verboseMessages() []string {
+	qualified := e.Tag
+	if e.Schema != "-" {
+		qualified = e.Schema + "." + e.Tag
+	}
+
+	messages := []string{
+		"creating " + e.Desc + ` "` + qualified + `"`,
+		"executing " + e.Desc + " " + e.Tag,
+		"processing " + e.Desc,
+	}
+	if e.Desc == "TABLE DATA" {
+		messages = append(messages, `processing data for table "`+qualified+`"`)
+	}
+	return messages
+}
+
+// Restore passes of pg_restore, which restores ACLs after everything else, and event
+// triggers and materialized view data after the ACLs
+const (
+	passMain = iota
+	passACL
+	passPostACL
+)
+
+func (e TOCEntry) restorePass() int {
+	switch e.Desc {
+	case "ACL", "ACL LANGUAGE", "DEFAULT ACL":
+		return passACL
+	case "EVENT TRIGGER", "MATERIALIZED VIEW DATA":
+		return passPostACL
+	case "COMMENT":
+		if strings.HasPrefix(e.Tag, "EVENT TRIGGER ") {
+			return passPostACL
+		}
+	}
+	return passMain
+}
+
+// restoreTracker maps pg_restore verbose output back to TOC entries. Serial restores
+// process the entries of a pass one at a time in list order, so when an entry starts,
+// the one before it is done and so is every earlier entry of the same pass, including
+// those pg_restore processed without logging. Parallel workers report completion
+// explicitly with "finished item". finish marks the rest done once pg_restore exited
+// cleanly; it is not called after tolerated errors, which leave no trace per entry.
+type restoreTracker struct {
+	entries   []TOCEntry
+	byMessage map[string][]int
+	byID      map[int]int
+	done      []bool
+	pending   int
+	parallel  bool
+	onDone    func(id int)
+}
+
+func newRestoreTracker(entries []TOCEntry, onDone func(id int)) *restoreTracker {
+	t := &restoreTracker{
+		entries:   entries,
+		byMessage: make(map[string][]int, len(entries)),
+		byID:      make(map[int]int, len(entries)),
+		done:      make([]bool, len(entries)),
+		pending:   -1,
+		onDone:    onDone,
+	}
+	for i, entry := range entries {
+		t.byID[entry.ID] = i
+		for _, msg := range entry.verboseMessages() {
+			t.byMessage[msg] = append(t.byMessage[msg], i)
+		}
+	}
+	return t
+}
+
+func (t *restoreTracker) handleLine(line string) {
+	msg := strings.TrimPrefix(line, "pg_restore: ")
+
+	if strings.HasPrefix(msg, "launching item ") {
+		t.finishPending()
+		t.parallel = true
+		return
+	}
+
+	// The remaining passes run serially again after the parallel loop
+	if msg == "finished main parallel loop" {
+		t.parallel = false
+		return
+	}
+
+	if rest, ok := strings.CutPrefix(msg, "finished item "); ok {
+		idStr, _, _ := strings.Cut(rest, " ")
+		if id, err := strconv.Atoi(idStr); err == nil {
+			if i, ok := t.byID[id]; ok {
+				t.markDone(i)
+			}
+		}
+		return
+	}
+
+	if t.parallel {
+		return
+	}
+
+	i, ok := t.next(msg)
+	if !ok {
+		return
+	}
+
+	t.finishPending()
+	pass := t.entries[i].restorePass()
+	for j := 0; j < i; j++ {
+		if t.entries[j].restorePass() == pass {
+			t.markDone(j)
+		}
+	}
+	t.pending = i
+}
+
+// finish marks every entry done after pg_restore exited successfully
+func (t *restoreTracker) finish() {
+	t.pending = -1
+	for i := range t.entries {
+		t.markDone(i)
+	}
+}
+
+// next returns the first entry logging msg that is not done yet
+func (t *restoreTracker) next(msg string) (int, bool) {
+	for _, i := range t.byMessage[msg] {
+		if !t.done[i] && i != t.pending {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+func (t *restoreTracker) finishPending() {
+	if t.pending >= 0 {
+		t.markDone(t.pending)
+		t.pending = -1
+	}
+}
+
+func (t *restoreTracker) markDone(i int) {
+	if !t.done[i] {
+		t.done[i] = true
+		t.onDone(t.entries[i].ID)
+	}
+}
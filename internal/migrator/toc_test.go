@@ -0,0 +1,196 @@
+package migrator
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTOCLine(t *testing.T) {
+	tests := []struct {
+		line  string
+		entry TOCEntry
+		ok    bool
+	}{
+		{
+			line:  "218; 1259 16386 TABLE public users postgres",
+			entry: TOCEntry{ID: 218, Desc: "TABLE", Schema: "public", Tag: "users"},
+			ok:    true,
+		},
+		{
+			line:  "3380; 0 16386 TABLE DATA public users postgres",
+			entry: TOCEntry{ID: 3380, Desc: "TABLE DATA", Schema: "public", Tag: "users"},
+			ok:    true,
+		},
+		{
+			line:  "3391; 0 0 SEQUENCE SET public users_id_seq postgres",
+			entry: TOCEntry{ID: 3391, Desc: "SEQUENCE SET", Schema: "public", Tag: "users_id_seq"},
+			ok:    true,
+		},
+		{
+			line:  "3390; 0 0 COMMENT - EXTENSION plpgsql ",
+			entry: TOCEntry{ID: 3390, Desc: "COMMENT", Schema: "-", Tag: "EXTENSION plpgsql"},
+			ok:    true,
+		},
+		{
+			line:  "3231; 2606 16401 CONSTRAINT public users users_pkey postgres",
+			entry: TOCEntry{ID: 3231, Desc: "CONSTRAINT", Schema: "public", Tag: "users users_pkey"},
+			ok:    true,
+		},
+		{
+			line:  "3240; 2606 16420 FK CONSTRAINT public posts posts_user_id_fkey postgres",
+			entry: TOCEntry{ID: 3240, Desc: "FK CONSTRAINT", Schema: "public", Tag: "posts posts_user_id_fkey"},
+			ok:    true,
+		},
+		{line: ";", ok: false},
+		{line: "; Archive created at 2025-01-01 12:00:00 UTC", ok: false},
+		{line: "", ok: false},
+		{line: "abc; 1259 16386 TABLE public users postgres", ok: false},
+		{line: "218; 1259 16386 TABLE", ok: false},
+	}
+
+	for _, tt := range tests {
+		entry, ok := parseTOCLine(tt.line)
+		require.Equal(t, tt.ok, ok, tt.line)
+		if tt.ok {
+			tt.entry.Line = tt.line
+			require.Equal(t, tt.entry, entry)
+		}
+	}
+}
+
+func trackerEntries(t *testing.T, lines ...string) []TOCEntry {
+	t.Helper()
+	entries := parseTOC(strings.Join(lines, "\n"))
+	require.Len(t, entries, len(lines))
+	return entries
+}
+
+func TestRestoreTrackerSerial(t *testing.T) {
+	entries := trackerEntries(t,
+		"1; 0 0 ENCODING - ENCODING ",
+		"2; 2615 2200 SCHEMA - app postgres",
+		"3; 1259 16386 TABLE app users postgres",
+		"4; 1259 16390 TABLE app posts postgres",
+		"5; 0 16386 TABLE DATA app users postgres",
+		"6; 0 0 SEQUENCE SET app users_id_seq postgres",
+		"7; 0 0 ACL - SCHEMA app postgres",
+		"8; 1259 16400 INDEX app posts_idx postgres",
+	)
+
+	var done []int
+	tracker := newRestoreTracker(entries, func(id int) { done = append(done, id) })
+
+	for _, line := range []string{
+		"pg_restore: connecting to database for restore",
+		`pg_restore: creating SCHEMA "app"`,
+		`pg_restore: creating TABLE "app.users"`,
+	} {
+		tracker.handleLine(line)
+	}
+	require.Equal(t, []int{1, 2}, done, "The entry before the one that started and silent earlier entries should be done")
+
+	// posts logs an unexpected message and is never matched, users data starts next
+	tracker.handleLine(`pg_restore: creating TABLE "app.posts" (unexpected format)`)
+	tracker.handleLine(`pg_restore: processing data for table "app.users"`)
+	require.Equal(t, []int{1, 2, 3, 4}, done, "Unmatched entries before a started one should be checkpointed")
+
+	tracker.handleLine("pg_restore: executing SEQUENCE SET users_id_seq")
+	tracker.handleLine(`pg_restore: creating INDEX "app.posts_idx"`)
+	require.Equal(t, []int{1, 2, 3, 4, 5, 6}, done, "The ACL pass comes later, so its entry should stay pending")
+
+	tracker.handleLine(`pg_restore: creating ACL "SCHEMA app"`)
+	require.Equal(t, []int{1, 2, 3, 4, 5, 6, 8}, done)
+
+	tracker.finish()
+	require.Equal(t, []int{1, 2, 3, 4, 5, 6, 8, 7}, done, "The last entry should be done once pg_restore succeeded")
+}
+
+func TestRestoreTrackerInterrupted(t *testing.T) {
+	entries := trackerEntries(t,
+		"1; 1259 16386 TABLE public users postgres",
+		"2; 0 16386 TABLE DATA public users postgres",
+		"3; 1259 16400 INDEX public users_idx postgres",
+	)
+
+	var done []int
+	tracker := newRestoreTracker(entries, func(id int) { done = append(done, id) })
+	tracker.handleLine(`pg_restore: creating TABLE "public.users"`)
+	tracker.handleLine(`pg_restore: processing data for table "public.users"`)
+
+	require.Equal(t, []int{1}, done, "The running entry must not be checkpointed before it finishes")
+}
+
+func TestRestoreTrackerParallel(t *testing.T) {
+	entries := trackerEntries(t,
+		"1; 1259 16386 TABLE public users postgres",
+		"2; 1259 16390 TABLE public posts postgres",
+		"3; 0 16386 TABLE DATA public users postgres",
+		"4; 0 16390 TABLE DATA public posts postgres",
+		"5; 0 0 ACL - SCHEMA public postgres",
+		"6; 0 16420 MATERIALIZED VIEW DATA public post_counts postgres",
+	)
+
+	var done []int
+	tracker := newRestoreTracker(entries, func(id int) { done = append(done, id) })
+	for _, line := range []string{
+		`pg_restore: creating TABLE "public.users"`,
+		`pg_restore: creating TABLE "public.posts"`,
+		"pg_restore: entering main parallel loop",
+		"pg_restore: launching item 4 TABLE DATA public posts",
+		"pg_restore: launching item 3 TABLE DATA public users",
+		`pg_restore: processing data for table "public.posts"`,
+		`pg_restore: processing data for table "public.users"`,
+		"pg_restore: finished item 3 TABLE DATA public users",
+	} {
+		tracker.handleLine(line)
+	}
+	require.Equal(t, []int{1, 2, 3}, done, "Workers' messages should not complete items, only finished item should")
+
+	tracker.handleLine("pg_restore: finished item 4 TABLE DATA public posts")
+	tracker.handleLine("pg_restore: finished main parallel loop")
+	tracker.handleLine(`pg_restore: creating ACL "SCHEMA public"`)
+	require.Equal(t, []int{1, 2, 3, 4}, done)
+
+	tracker.handleLine(`pg_restore: creating MATERIALIZED VIEW DATA "public.post_counts"`)
+	require.Equal(t, []int{1, 2, 3, 4, 5}, done, "Serial passes after the parallel loop should be tracked again")
+}
+
+func TestProgressTrackerSameTagInTwoSchemas(t *testing.T) {
+	entries := trackerEntries(t,
+		"3; 0 16386 TABLE DATA public users postgres",
+		"4; 0 16390 TABLE DATA archive users postgres",
+	)
+
+	var output bytes.Buffer
+	sizes := map[string]int64{"public.users": 100, "archive.users": 300}
+	tracker := newProgressTracker("pg_restore", true, sizes, nil, slog.New(slog.NewJSONHandler(&output, nil)))
+	tracker.objects = tocObjects(entries)
+
+	for _, line := range []string{
+		"pg_restore: launching item 3 TABLE DATA users",
+		"pg_restore: launching item 4 TABLE DATA users",
+		`pg_restore: processing data for table "public.users"`,
+		`pg_restore: processing data for table "archive.users"`,
+		"pg_restore: finished item 4 TABLE DATA users",
+	} {
+		tracker.handleLine(line)
+	}
+
+	var finished []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(output.String()), "\n") {
+		var record map[string]any
+		require.NoError(t, json.Unmarshal([]byte(line), &record))
+		if record["event"] == string(EventTableDataFinished) {
+			finished = append(finished, record)
+		}
+	}
+	require.Len(t, finished, 1)
+	require.Equal(t, "archive.users", finished[0]["object"], "The item should be resolved by its dump ID, not its tag")
+	require.Equal(t, 75.0, finished[0]["percent"])
+	require.Contains(t, tracker.running, "public.users")
+}
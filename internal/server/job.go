@@ -0,0 +1,288 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/crisog/postgres-migrator/internal/config"
+	"github.com/crisog/postgres-migrator/internal/logging"
+	"github.com/crisog/postgres-migrator/internal/metrics"
+)
+
+// Job types accepted by POST /jobs
+const (
+	JobMigrate  = "migrate"
+	JobValidate = "validate"
+)
+
+// Job states, a job ends in succeeded, failed or cancelled
+const (
+	StatusQueued    = "queued"
+	StatusRunning   = "running"
+	StatusSucceeded = "succeeded"
+	StatusFailed    = "failed"
+	StatusCancelled = "cancelled"
+)
+
+// JobStatus is what the API reports about a job
+type JobStatus struct {
+	ID         string     `json:"id"`
+	Type       string     `json:"type"`
+	Status     string     `json:"status"`
+	Phase      string     `json:"phase,omitempty"`
+	Percent    *float64   `json:"percent,omitempty"`
+	Message    string     `json:"message,omitempty"`
+	Error      string     `json:"error,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+type job struct {
+	id     string
+	kind   string
+	cfg    *config.Config
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu       sync.Mutex
+	status   string
+	phase    string
+	percent  *float64
+	message  string
+	err      string
+	created  time.Time
+	started  time.Time
+	finished time.Time
+	recorder *metrics.Recorder
+
+	logs      [][]byte
+	logLimit  int
+	truncated bool
+	// changed is closed and replaced whenever a log line is added or the job ends
+	changed chan struct{}
+}
+
+func newJob(kind string, cfg *config.Config, logLimit int) *job {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &job{
+		id:       logging.NewRunID(),
+		kind:     kind,
+		cfg:      cfg,
+		ctx:      ctx,
+		cancel:   cancel,
+		status:   StatusQueued,
+		created:  time.Now().UTC(),
+		logLimit: logLimit,
+		changed:  make(chan struct{}),
+	}
+}
+
+func (j *job) Status() JobStatus {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	status := JobStatus{
+		ID:        j.id,
+		Type:      j.kind,
+		Status:    j.status,
+		Phase:     j.phase,
+		Percent:   j.percent,
+		Message:   j.message,
+		Error:     j.err,
+		CreatedAt: j.created,
+	}
+	if !j.started.IsZero() {
+		status.StartedAt = &j.started
+	}
+	if !j.finished.IsZero() {
+		status.FinishedAt = &j.finished
+	}
+	return status
+}
+
+// start moves a queued job to running, or reports false if it was cancelled meanwhile
+func (j *job) start() bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.status != StatusQueued {
+		return false
+	}
+	j.status = StatusRunning
+	j.started = time.Now().UTC()
+	return true
+}
+
+func (j *job) finish(err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	switch {
+	case err == nil:
+		j.status = StatusSucceeded
+	case errors.Is(j.ctx.Err(), context.Canceled):
+		j.status = StatusCancelled
+		j.err = err.Error()
+	default:
+		j.status = StatusFailed
+		j.err = err.Error()
+	}
+	j.finished = time.Now().UTC()
+	j.notify()
+	j.cancel()
+}
+
+// requestCancel cancels the job's context; a queued job ends right away, a running one
+// once the migration has stopped. It reports false when the job had already ended.
+func (j *job) requestCancel() bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	switch j.status {
+	case StatusQueued:
+		j.status = StatusCancelled
+		j.finished = time.Now().UTC()
+		j.notify()
+	case StatusRunning:
+	default:
+		return false
+	}
+	j.cancel()
+	return true
+}
+
+func (j *job) done() bool {
+	return j.status != StatusQueued && j.status != StatusRunning
+}
+
+// expired reports whether the job ended before cutoff
+func (j *job) expired(cutoff time.Time) bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.done() && j.finished.Before(cutoff)
+}
+
+// logsSince returns the log lines after the first offset ones, a channel that is closed
+// on the next change, and whether the job has ended so no more lines will follow
+func (j *job) logsSince(offset int) (lines [][]byte, changed <-chan struct{}, done bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if offset < len(j.logs) {
+		lines = j.logs[offset:len(j.logs):len(j.logs)]
+	}
+	return lines, j.changed, j.done()
+}
+
+// Write stores one JSON log record, the handler writes each record in a single call.
+// Past the limit records are dropped after a single job log truncated record.
+func (j *job) Write(p []byte) (int, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if len(j.logs) < j.logLimit {
+		j.logs = append(j.logs, slices.Clone(p))
+		j.notify()
+	} else if !j.truncated {
+		j.truncated = true
+		marker, err := json.Marshal(map[string]any{
+			"time":           time.Now().UTC(),
+			"level":          slog.LevelWarn.String(),
+			"msg":            "job log truncated",
+			logging.KeyRunID: j.id,
+			"lines":          j.logLimit,
+		})
+		if err != nil {
+			return 0, err
+		}
+		j.logs = append(j.logs, append(marker, '\n'))
+		j.notify()
+	}
+	return len(p), nil
+}
+
+func (j *job) observe(r slog.Record, bound []slog.Attr) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.message = r.Message
+	visit := func(attr slog.Attr) bool {
+		switch attr.Key {
+		case logging.KeyPhase:
+			j.phase = attr.Value.String()
+		case "percent":
+			if attr.Value.Kind() == slog.KindFloat64 {
+				percent := attr.Value.Float64()
+				j.percent = &percent
+			}
+		}
+		return true
+	}
+	for _, attr := range bound {
+		visit(attr)
+	}
+	r.Attrs(visit)
+}
+
+func (j *job) notify() {
+	close(j.changed)
+	j.changed = make(chan struct{})
+}
+
+// jobHandler keeps a JSON copy of every record in the job for GET /jobs/{id}/logs, takes
+// the job's phase and progress from the records and passes them on to the server log
+type jobHandler struct {
+	job    *job
+	local  slog.Handler
+	server slog.Handler
+	attrs  []slog.Attr
+}
+
+func newJobHandler(j *job, server slog.Handler) *jobHandler {
+	return &jobHandler{
+		job:    j,
+		local:  slog.NewJSONHandler(j, &slog.HandlerOptions{Level: j.cfg.SlogLevel()}),
+		server: server,
+	}
+}
+
+func (h *jobHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.local.Enabled(ctx, level) || h.server.Enabled(ctx, level)
+}
+
+func (h *jobHandler) Handle(ctx context.Context, r slog.Record) error {
+	h.job.observe(r, h.attrs)
+
+	var err error
+	if h.local.Enabled(ctx, r.Level) {
+		err = h.local.Handle(ctx, r.Clone())
+	}
+	if h.server.Enabled(ctx, r.Level) {
+		err = errors.Join(err, h.server.Handle(ctx, r))
+	}
+	return err
+}
+
+func (h *jobHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &jobHandler{
+		job:    h.job,
+		local:  h.local.WithAttrs(attrs),
+		server: h.server.WithAttrs(attrs),
+		attrs:  append(slices.Clip(h.attrs), attrs...),
+	}
+}
+
+func (h *jobHandler) WithGroup(name string) slog.Handler {
+	return &jobHandler{
+		job:    h.job,
+		local:  h.local.WithGroup(name),
+		server: h.server.WithGroup(name),
+		attrs:  h.attrs,
+	}
+}
@@ -0,0 +1,363 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/crisog/postgres-migrator/internal/config"
+	"github.com/crisog/postgres-migrator/internal/logging"
+	"github.com/crisog/postgres-migrator/internal/metrics"
+	"github.com/crisog/postgres-migrator/internal/tracing"
+	"github.com/crisog/postgres-migrator/internal/webhook"
+	"github.com/crisog/postgres-migrator/pkg/migration"
+)
+
+const (
+	maxQueuedJobs       = 100
+	maxRequestBytes     = 1 << 20
+	webhookFlushTimeout = 30 * time.Second
+	pruneInterval       = time.Minute
+)
+
+// Server runs migration and validation jobs submitted over its HTTP API, at most
+// SERVE_CONCURRENCY at a time and the rest in submission order
+type Server struct {
+	cfg    *config.Config
+	logger *slog.Logger
+	queue  chan *job
+
+	mu   sync.Mutex
+	jobs map[string]*job
+	list []*job
+
+	// background tracks webhook deliveries that outlive their job
+	background sync.WaitGroup
+}
+
+// JobRequest is the body of POST /jobs. Settings are keyed by the environment variable
+// names of a regular run, values not given are taken from the server's environment.
+type JobRequest struct {
+	Type     string         `json:"type"`
+	Settings map[string]any `json:"settings"`
+}
+
+func New(cfg *config.Config, logger *slog.Logger) *Server {
+	return &Server{
+		cfg:    cfg,
+		logger: logger,
+		queue:  make(chan *job, maxQueuedJobs),
+		jobs:   make(map[string]*job),
+	}
+}
+
+// Run works through the queue and drops jobs that ended SERVE_JOB_RETENTION ago until ctx
+// is done, then cancels the running jobs and returns once they have stopped
+func (s *Server) Run(ctx context.Context) {
+	var workers sync.WaitGroup
+	for i := 0; i < s.cfg.ServeConcurrency; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case j := <-s.queue:
+					s.execute(j)
+				}
+			}
+		}()
+	}
+
+	ticker := time.NewTicker(min(pruneInterval, s.cfg.ServeJobRetention))
+	defer ticker.Stop()
+prune:
+	for {
+		select {
+		case <-ctx.Done():
+			break prune
+		case now := <-ticker.C:
+			s.prune(now.Add(-s.cfg.ServeJobRetention))
+		}
+	}
+
+	s.mu.Lock()
+	for _, j := range s.list {
+		j.requestCancel()
+	}
+	s.mu.Unlock()
+
+	workers.Wait()
+	s.background.Wait()
+}
+
+// prune drops the jobs that ended before cutoff, with their logs and metrics
+func (s *Server) prune(cutoff time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	kept := s.list[:0]
+	for _, j := range s.list {
+		if !j.expired(cutoff) {
+			kept = append(kept, j)
+			continue
+		}
+		delete(s.jobs, j.id)
+		j.mu.Lock()
+		j.recorder.Close()
+		j.mu.Unlock()
+	}
+	clear(s.list[len(kept):])
+	s.list = kept
+}
+
+func (s *Server) execute(j *job) {
+	if !j.start() {
+		return
+	}
+
+	logger := slog.New(newJobHandler(j, s.logger.Handler())).With(logging.KeyRunID, j.id, "command", j.kind)
+	notifier := webhook.New(j.cfg, j.id, logger)
+	recorder := metrics.New(j.id)
+	j.mu.Lock()
+	j.recorder = recorder
+	j.mu.Unlock()
+
+	ctx := webhook.NewContext(j.ctx, notifier)
+	ctx = metrics.NewContext(ctx, recorder)
+	ctx = tracing.WithRunID(ctx, j.id)
+
+	logger.Info("command started")
+	start := time.Now()
+
+	var err error
+	switch j.kind {
+	case JobValidate:
+		err = migration.Validate(ctx, j.cfg, logger)
+	default:
+		err = migration.Migrate(ctx, j.cfg, logger)
+	}
+	if err != nil {
+		logger.Error("command failed", logging.Duration(time.Since(start)), logging.Err(err))
+	} else {
+		logger.Info("command completed", logging.Duration(time.Since(start)))
+	}
+	j.finish(err)
+
+	// Slow webhook endpoints must not hold up the next job in the queue
+	s.background.Add(1)
+	go func() {
+		defer s.background.Done()
+		flushCtx, cancel := context.WithTimeout(context.Background(), webhookFlushTimeout)
+		defer cancel()
+		notifier.Close(flushCtx)
+	}()
+}
+
+// Handler serves the API:
+//
+//	POST /jobs                 submit a job, answered with 202 and its status
+//	GET  /jobs                 status of every job, oldest first
+//	GET  /jobs/{id}            status of one job
+//	POST /jobs/{id}/cancel     cancel a queued or running job
+//	GET  /jobs/{id}/logs       the job's log records as JSON lines, ?follow=true streams
+//	                           them until the job ends
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /jobs", s.submit)
+	mux.HandleFunc("GET /jobs", s.listJobs)
+	mux.HandleFunc("GET /jobs/{id}", s.getJob)
+	mux.HandleFunc("POST /jobs/{id}/cancel", s.cancelJob)
+	mux.HandleFunc("GET /jobs/{id}/logs", s.jobLogs)
+
+	if s.cfg.ServeToken == "" {
+		return mux
+	}
+	return s.authenticate(mux)
+}
+
+// authenticate requires SERVE_TOKEN as a bearer token on every request
+func (s *Server) authenticate(next http.Handler) http.Handler {
+	expected := []byte("Bearer " + s.cfg.ServeToken)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), expected) != 1 {
+			writeError(w, http.StatusUnauthorized, errors.New("missing or invalid bearer token"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) submit(w http.ResponseWriter, r *http.Request) {
+	var request JobRequest
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBytes))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&request); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid job request: %w", err))
+		return
+	}
+
+	switch request.Type {
+	case "":
+		request.Type = JobMigrate
+	case JobMigrate, JobValidate:
+	default:
+		writeError(w, http.StatusBadRequest, fmt.Errorf("type must be %q or %q, got: %s", JobMigrate, JobValidate, request.Type))
+		return
+	}
+
+	settings, err := settingValues(request.Settings)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	cfg, err := config.LoadJobConfig(settings)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("configuration error: %w", err))
+		return
+	}
+
+	j := newJob(request.Type, cfg, s.cfg.ServeJobLogLines)
+	s.mu.Lock()
+	select {
+	case s.queue <- j:
+		s.jobs[j.id] = j
+		s.list = append(s.list, j)
+		s.mu.Unlock()
+	default:
+		s.mu.Unlock()
+		writeError(w, http.StatusServiceUnavailable, fmt.Errorf("job queue is full, %d jobs are waiting", maxQueuedJobs))
+		return
+	}
+
+	s.logger.Info("job queued", logging.KeyRunID, j.id, "type", j.kind)
+	writeJSON(w, http.StatusAccepted, j.Status())
+}
+
+func (s *Server) listJobs(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	statuses := make([]JobStatus, 0, len(s.list))
+	for _, j := range s.list {
+		statuses = append(statuses, j.Status())
+	}
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, statuses)
+}
+
+func (s *Server) getJob(w http.ResponseWriter, r *http.Request) {
+	j, ok := s.lookup(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, j.Status())
+}
+
+func (s *Server) cancelJob(w http.ResponseWriter, r *http.Request) {
+	j, ok := s.lookup(w, r)
+	if !ok {
+		return
+	}
+	if !j.requestCancel() {
+		writeError(w, http.StatusConflict, fmt.Errorf("job %s has already ended", j.id))
+		return
+	}
+
+	s.logger.Info("job cancellation requested", logging.KeyRunID, j.id)
+	writeJSON(w, http.StatusAccepted, j.Status())
+}
+
+func (s *Server) jobLogs(w http.ResponseWriter, r *http.Request) {
+	j, ok := s.lookup(w, r)
+	if !ok {
+		return
+	}
+	follow, _ := strconv.ParseBool(r.URL.Query().Get("follow"))
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	flusher, _ := w.(http.Flusher)
+
+	offset := 0
+	for {
+		lines, changed, done := j.logsSince(offset)
+		for _, line := range lines {
+			if _, err := w.Write(line); err != nil {
+				return
+			}
+		}
+		offset += len(lines)
+		if flusher != nil {
+			flusher.Flush()
+		}
+
+		if !follow || done {
+			return
+		}
+		select {
+		case <-changed:
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func (s *Server) lookup(w http.ResponseWriter, r *http.Request) (*job, bool) {
+	id := r.PathValue("id")
+
+	s.mu.Lock()
+	j, ok := s.jobs[id]
+	s.mu.Unlock()
+
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("job %s not found", id))
+	}
+	return j, ok
+}
+
+// settingValues turns JSON settings into environment values, so numbers and booleans
+// need not be quoted and lists may be given as arrays
+func settingValues(settings map[string]any) (map[string]string, error) {
+	values := make(map[string]string, len(settings))
+	for key, value := range settings {
+		switch v := value.(type) {
+		case string:
+			values[key] = v
+		case bool:
+			values[key] = strconv.FormatBool(v)
+		case float64:
+			values[key] = strconv.FormatFloat(v, 'f', -1, 64)
+		case []any:
+			items := make([]string, 0, len(v))
+			for _, item := range v {
+				s, ok := item.(string)
+				if !ok {
+					return nil, fmt.Errorf("setting %s must be a list of strings", key)
+				}
+				items = append(items, s)
+			}
+			values[key] = strings.Join(items, ",")
+		default:
+			return nil, fmt.Errorf("setting %s must be a string, number, boolean or list of strings", key)
+		}
+	}
+	return values, nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,192 @@
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/crisog/postgres-migrator/internal/config"
+	"github.com/crisog/postgres-migrator/internal/logging"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+const (
+	checksumMetadataKey = "Sha256"
+	uploadPartSize      = 64 * 1024 * 1024
+)
+
+type S3Store struct {
+	client *minio.Client
+	logger *slog.Logger
+}
+
+func NewS3Store(cfg *config.Config, logger *slog.Logger) (*S3Store, error) {
+	creds := credentials.NewStaticV4(cfg.S3AccessKeyID, cfg.S3SecretAccessKey, "")
+	if cfg.S3AccessKeyID == "" {
+		creds = credentials.NewChainCredentials([]credentials.Provider{
+			&credentials.EnvAWS{},
+			&credentials.EnvMinio{},
+			&credentials.IAM{},
+		})
+	}
+
+	client, err := minio.New(cfg.S3Endpoint, &minio.Options{
+		Creds:  creds,
+		Secure: !cfg.S3Insecure,
+		Region: cfg.S3Region,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create S3 client: %w", err)
+	}
+
+	return &S3Store{client: client, logger: logger}, nil
+}
+
+func ParseURI(uri string) (bucket, key string, err error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid archive URI %s: %w", uri, err)
+	}
+	if u.Scheme != "s3" {
+		return "", "", fmt.Errorf("invalid archive URI %s: scheme must be s3://", uri)
+	}
+
+	key = strings.TrimPrefix(u.Path, "/")
+	if u.Host == "" || key == "" {
+		return "", "", fmt.Errorf("invalid archive URI %s: expected s3://bucket/key", uri)
+	}
+
+	return u.Host, key, nil
+}
+
+// Upload stores the archive with a multipart upload and records its SHA-256 checksum
+// as object metadata so Download can verify it
+func (s *S3Store) Upload(ctx context.Context, localPath, uri string) error {
+	bucket, key, err := ParseURI(uri)
+	if err != nil {
+		return err
+	}
+
+	checksum, err := fileChecksum(localPath)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Open(localPath)
+	if err != nil {
+		return fmt.Errorf("failed to open archive: %w", err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat archive: %w", err)
+	}
+
+	s.logger.Info("uploading archive", "uri", uri, logging.Bytes(info.Size()))
+
+	_, err = s.client.PutObject(ctx, bucket, key, file, info.Size(), minio.PutObjectOptions{
+		ContentType:  "application/octet-stream",
+		PartSize:     uploadPartSize,
+		UserMetadata: map[string]string{checksumMetadataKey: checksum},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to upload archive: %w", err)
+	}
+
+	s.logger.Info("archive uploaded", "uri", uri, "sha256", checksum)
+
+	return nil
+}
+
+// Download fetches the archive into localPath and verifies it against the checksum
+// recorded at upload time. The file only appears at localPath once it is verified, and a
+// file already there is kept only when it matches the checksum.
+func (s *S3Store) Download(ctx context.Context, uri, localPath string) error {
+	bucket, key, err := ParseURI(uri)
+	if err != nil {
+		return err
+	}
+
+	info, err := s.client.StatObject(ctx, bucket, key, minio.StatObjectOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to stat archive %s: %w", uri, err)
+	}
+
+	var expected string
+	for k, v := range info.UserMetadata {
+		if strings.EqualFold(k, checksumMetadataKey) {
+			expected = v
+		}
+	}
+	if expected == "" {
+		return fmt.Errorf("archive %s has no sha256 checksum metadata", uri)
+	}
+
+	if actual, err := fileChecksum(localPath); err == nil {
+		if actual == expected {
+			s.logger.Info("using previously fetched archive", logging.File(localPath), "sha256", actual)
+			return nil
+		}
+		s.logger.Warn("previously fetched archive does not match its checksum, downloading it again", logging.File(localPath))
+	}
+
+	s.logger.Info("downloading archive", "uri", uri, logging.Bytes(info.Size))
+
+	object, err := s.client.GetObject(ctx, bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to download archive: %w", err)
+	}
+	defer object.Close()
+
+	partialPath := localPath + ".partial"
+	file, err := os.Create(partialPath)
+	if err != nil {
+		return fmt.Errorf("failed to create local archive: %w", err)
+	}
+	defer os.Remove(partialPath)
+
+	hash := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(file, hash), object); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to download archive: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("failed to write local archive: %w", err)
+	}
+
+	actual := hex.EncodeToString(hash.Sum(nil))
+	if actual != expected {
+		return fmt.Errorf("archive checksum mismatch: expected sha256 %s, got %s", expected, actual)
+	}
+
+	if err := os.Rename(partialPath, localPath); err != nil {
+		return fmt.Errorf("failed to finalize local archive: %w", err)
+	}
+
+	s.logger.Info("archive downloaded and verified", "uri", uri, "sha256", actual)
+
+	return nil
+}
+
+func fileChecksum(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open archive: %w", err)
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", fmt.Errorf("failed to checksum archive: %w", err)
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -0,0 +1,295 @@
+package subset
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/crisog/postgres-migrator/internal/copier"
+	"github.com/crisog/postgres-migrator/internal/database"
+	"github.com/crisog/postgres-migrator/internal/logging"
+	"github.com/crisog/postgres-migrator/internal/masking"
+	"github.com/jackc/pgx/v5"
+)
+
+// Root selects the rows of one table that the subset starts from
+type Root struct {
+	Table string `json:"table"`
+	Where string `json:"where"`
+}
+
+type Plan struct {
+	Roots []Root `json:"roots"`
+}
+
+func LoadPlan(path string) (*Plan, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read subset file: %w", err)
+	}
+
+	var plan Plan
+	if err := json.Unmarshal(data, &plan); err != nil {
+		return nil, fmt.Errorf("invalid subset file %s: %w", path, err)
+	}
+	if len(plan.Roots) == 0 {
+		return nil, fmt.Errorf("subset file %s has no roots", path)
+	}
+	for i, root := range plan.Roots {
+		if root.Table == "" || root.Where == "" {
+			return nil, fmt.Errorf("subset file %s: root %d needs both table and where", path, i+1)
+		}
+	}
+
+	return &plan, nil
+}
+
+type foreignKey struct {
+	child, parent            int
+	childColumns, parentCols []string
+}
+
+// Copier computes a referentially complete subset on the source and copies it into a
+// target that already has the schema. Rows are tracked by ctid in temporary tables, all
+// within one repeatable read transaction so the selection and the copy see the same data.
+type Copier struct {
+	sourceURL string
+	targetURL string
+	masking   *masking.Rules
+	logger    *slog.Logger
+}
+
+// NewCopier creates a subset copier. rules may be nil when nothing is masked.
+func NewCopier(sourceURL, targetURL string, rules *masking.Rules, logger *slog.Logger) *Copier {
+	return &Copier{sourceURL: sourceURL, targetURL: targetURL, masking: rules, logger: logger}
+}
+
+// Copy selects the root rows, then every row that descends from them through foreign
+// keys, then every row those rows reference, and copies the result table by table.
+// It returns the number of rows copied per table.
+func (c *Copier) Copy(ctx context.Context, plan *Plan, tables []database.TableInfo) (map[string]int64, error) {
+	rowCopier, err := copier.Open(ctx, c.sourceURL, c.targetURL, copier.Options{Masking: c.masking, DisableTriggers: true})
+	if err != nil {
+		return nil, err
+	}
+	defer rowCopier.Close(ctx)
+
+	tx := rowCopier.Source()
+
+	index := make(map[string]int, len(tables))
+	for i, table := range tables {
+		index[qualifiedName(table)] = i
+		if _, err := tx.Exec(ctx, fmt.Sprintf("CREATE TEMP TABLE %s (row_id tid PRIMARY KEY) ON COMMIT DROP", selectionTable(i))); err != nil {
+			return nil, fmt.Errorf("failed to create selection table: %w", err)
+		}
+	}
+
+	for _, root := range plan.Roots {
+		i, ok := lookupTable(index, root.Table)
+		if !ok {
+			return nil, fmt.Errorf("subset root %s is not one of the migrated tables", root.Table)
+		}
+		tag, err := tx.Exec(ctx, fmt.Sprintf("INSERT INTO %s SELECT ctid FROM %s WHERE %s ON CONFLICT DO NOTHING",
+			selectionTable(i), qualifiedName(tables[i]), root.Where))
+		if err != nil {
+			return nil, fmt.Errorf("failed to select root rows of %s: %w", root.Table, err)
+		}
+		c.logger.Info("subset root selected", logging.Table(qualifiedName(tables[i])), logging.Rows(tag.RowsAffected()))
+	}
+
+	keys, err := foreignKeys(ctx, tx, index)
+	if err != nil {
+		return nil, err
+	}
+
+	// Children first, so the subset contains what hangs off the roots, then parents so
+	// every selected row's references are satisfied. Parents pulled in by the second
+	// pass do not bring their other children along.
+	if err := c.closure(ctx, tx, tables, keys, childrenOf); err != nil {
+		return nil, err
+	}
+	if err := c.closure(ctx, tx, tables, keys, parentsOf); err != nil {
+		return nil, err
+	}
+
+	copied := make(map[string]int64, len(tables))
+	for i, table := range tables {
+		n, err := rowCopier.CopyTable(ctx, table, fmt.Sprintf("ctid IN (SELECT row_id FROM %s)", selectionTable(i)))
+		if err != nil {
+			return nil, fmt.Errorf("failed to copy %s: %w", qualifiedName(table), err)
+		}
+		copied[qualifiedName(table)] = n
+		c.logger.Info("table copied", logging.Table(qualifiedName(table)), logging.Rows(n))
+	}
+
+	return copied, nil
+}
+
+type direction func(fk foreignKey) (from, to int, fromCols, toCols []string)
+
+func childrenOf(fk foreignKey) (int, int, []string, []string) {
+	return fk.parent, fk.child, fk.parentCols, fk.childColumns
+}
+
+func parentsOf(fk foreignKey) (int, int, []string, []string) {
+	return fk.child, fk.parent, fk.childColumns, fk.parentCols
+}
+
+// closure follows the foreign keys in one direction until no new rows are selected
+func (c *Copier) closure(ctx context.Context, tx pgx.Tx, tables []database.TableInfo, keys []foreignKey, follow direction) error {
+	for pass := 1; ; pass++ {
+		var added int64
+		for _, fk := range keys {
+			from, to, fromCols, toCols := follow(fk)
+
+			conditions := make([]string, len(fromCols))
+			for i := range fromCols {
+				conditions[i] = fmt.Sprintf("t.%s = f.%s", pgx.Identifier{toCols[i]}.Sanitize(), pgx.Identifier{fromCols[i]}.Sanitize())
+			}
+
+			query := fmt.Sprintf(`
+				INSERT INTO %s
+				SELECT t.ctid FROM %s t
+				JOIN %s f ON %s
+				WHERE f.ctid IN (SELECT row_id FROM %s)
+				ON CONFLICT DO NOTHING`,
+				selectionTable(to), qualifiedName(tables[to]), qualifiedName(tables[from]),
+				strings.Join(conditions, " AND "), selectionTable(from))
+
+			tag, err := tx.Exec(ctx, query)
+			if err != nil {
+				return fmt.Errorf("failed to follow foreign key from %s to %s: %w", qualifiedName(tables[from]), qualifiedName(tables[to]), err)
+			}
+			added += tag.RowsAffected()
+		}
+		if added == 0 {
+			return nil
+		}
+		c.logger.Info("related rows added", "pass", pass, logging.Rows(added))
+	}
+}
+
+// foreignKeys lists the foreign keys between copied tables. Only leaf partitions hold
+// rows, so a key on or to a partitioned table is followed between every pair of their
+// leaf partitions. A key whose table is not copied at all is skipped, one that reaches
+// only some partitions of a copied table cannot be followed and fails the subset.
+func foreignKeys(ctx context.Context, tx pgx.Tx, index map[string]int) ([]foreignKey, error) {
+	leaves, err := leafPartitions(ctx, tx)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := tx.Query(ctx, `
+		SELECT con.conname, con.conrelid, cn.nspname, c.relname, con.confrelid, pn.nspname, p.relname,
+			ARRAY(SELECT a.attname FROM unnest(con.conkey) WITH ORDINALITY k(attnum, n)
+				JOIN pg_attribute a ON a.attrelid = con.conrelid AND a.attnum = k.attnum ORDER BY k.n),
+			ARRAY(SELECT a.attname FROM unnest(con.confkey) WITH ORDINALITY k(attnum, n)
+				JOIN pg_attribute a ON a.attrelid = con.confrelid AND a.attnum = k.attnum ORDER BY k.n)
+		FROM pg_constraint con
+		JOIN pg_class c ON c.oid = con.conrelid
+		JOIN pg_namespace cn ON cn.oid = c.relnamespace
+		JOIN pg_class p ON p.oid = con.confrelid
+		JOIN pg_namespace pn ON pn.oid = p.relnamespace
+		WHERE con.contype = 'f' AND con.conparentid = 0`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list foreign keys: %w", err)
+	}
+	defer rows.Close()
+
+	var keys []foreignKey
+	for rows.Next() {
+		var name, childSchema, childTable, parentSchema, parentTable string
+		var childOID, parentOID uint32
+		var childColumns, parentCols []string
+		if err := rows.Scan(&name, &childOID, &childSchema, &childTable, &parentOID, &parentSchema, &parentTable, &childColumns, &parentCols); err != nil {
+			return nil, fmt.Errorf("failed to scan foreign key: %w", err)
+		}
+
+		childName := pgx.Identifier{childSchema, childTable}.Sanitize()
+		children, err := copiedLeaves(index, leaves, childOID, childName)
+		if err != nil {
+			return nil, fmt.Errorf("foreign key %s on %s cannot be followed: %w", name, childName, err)
+		}
+		parents, err := copiedLeaves(index, leaves, parentOID, pgx.Identifier{parentSchema, parentTable}.Sanitize())
+		if err != nil {
+			return nil, fmt.Errorf("foreign key %s on %s cannot be followed: %w", name, childName, err)
+		}
+
+		for _, child := range children {
+			for _, parent := range parents {
+				keys = append(keys, foreignKey{child: child, parent: parent, childColumns: childColumns, parentCols: parentCols})
+			}
+		}
+	}
+	return keys, rows.Err()
+}
+
+// leafPartitions maps every partitioned table to the names of its leaf partitions
+func leafPartitions(ctx context.Context, tx pgx.Tx) (map[uint32][]string, error) {
+	rows, err := tx.Query(ctx, `
+		SELECT p.oid, n.nspname, c.relname
+		FROM pg_class p
+		CROSS JOIN LATERAL pg_partition_tree(p.oid) t
+		JOIN pg_class c ON c.oid = t.relid
+		JOIN pg_namespace n ON n.oid = c.relnamespace
+		WHERE p.relkind = 'p' AND t.isleaf`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list partitions: %w", err)
+	}
+	defer rows.Close()
+
+	leaves := make(map[uint32][]string)
+	for rows.Next() {
+		var oid uint32
+		var schema, table string
+		if err := rows.Scan(&oid, &schema, &table); err != nil {
+			return nil, fmt.Errorf("failed to scan partition: %w", err)
+		}
+		leaves[oid] = append(leaves[oid], pgx.Identifier{schema, table}.Sanitize())
+	}
+	return leaves, rows.Err()
+}
+
+// copiedLeaves returns the indexes of the tables holding the rows of one end of a
+// foreign key: the table itself, or the leaf partitions of a partitioned table. It
+// returns none when the table is not copied.
+func copiedLeaves(index map[string]int, leaves map[uint32][]string, oid uint32, name string) ([]int, error) {
+	names, partitioned := leaves[oid]
+	if !partitioned {
+		names = []string{name}
+	}
+
+	var copied []int
+	var missing []string
+	for _, leaf := range names {
+		if i, ok := index[leaf]; ok {
+			copied = append(copied, i)
+		} else {
+			missing = append(missing, leaf)
+		}
+	}
+	if len(copied) > 0 && len(missing) > 0 {
+		return nil, fmt.Errorf("partitions of %s are not copied: %s", name, strings.Join(missing, ", "))
+	}
+	return copied, nil
+}
+
+func lookupTable(index map[string]int, name string) (int, bool) {
+	if !strings.Contains(name, ".") {
+		name = "public." + name
+	}
+	schema, table, _ := strings.Cut(name, ".")
+	i, ok := index[pgx.Identifier{schema, table}.Sanitize()]
+	return i, ok
+}
+
+func qualifiedName(table database.TableInfo) string {
+	return pgx.Identifier{table.Schema, table.Name}.Sanitize()
+}
+
+func selectionTable(i int) string {
+	return fmt.Sprintf("subset_rows_%d", i)
+}
@@ -0,0 +1,105 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/crisog/postgres-migrator/internal/config"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/crisog/postgres-migrator"
+
+// Attribute keys, named like the matching log fields
+const (
+	KeyRunID = "run_id"
+	KeyTable = "table"
+	KeyRows  = "rows"
+	KeyBytes = "bytes"
+)
+
+// Setup exports spans over OTLP/HTTP to OTEL_EXPORTER_OTLP_ENDPOINT. Without an endpoint
+// spans are not recorded. runID becomes a resource attribute unless it is empty. The
+// returned function flushes pending spans and must be called before the process exits.
+func Setup(ctx context.Context, cfg *config.Config, runID string) (shutdown func(context.Context) error, err error) {
+	if cfg.OTLPEndpoint == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	exporter, err := otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(strings.TrimSuffix(cfg.OTLPEndpoint, "/")+"/v1/traces"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create trace exporter: %w", err)
+	}
+
+	attrs := []attribute.KeyValue{attribute.String("service.name", "postgres-migrator")}
+	if runID != "" {
+		attrs = append(attrs, attribute.String(KeyRunID, runID))
+	}
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(attrs...))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create trace resource: %w", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSpanProcessor(runIDProcessor{}),
+	)
+	otel.SetTracerProvider(provider)
+
+	return provider.Shutdown, nil
+}
+
+type runIDKey struct{}
+
+// WithRunID returns a context whose spans carry runID as the run_id attribute, for runs
+// sharing the process's resource like the jobs of the API server
+func WithRunID(ctx context.Context, runID string) context.Context {
+	return context.WithValue(ctx, runIDKey{}, runID)
+}
+
+// runIDProcessor sets the run_id attribute of spans started under WithRunID
+type runIDProcessor struct{}
+
+func (runIDProcessor) OnStart(ctx context.Context, span sdktrace.ReadWriteSpan) {
+	if runID, ok := ctx.Value(runIDKey{}).(string); ok {
+		span.SetAttributes(attribute.String(KeyRunID, runID))
+	}
+}
+
+func (runIDProcessor) OnEnd(sdktrace.ReadOnlySpan)      {}
+func (runIDProcessor) Shutdown(context.Context) error   { return nil }
+func (runIDProcessor) ForceFlush(context.Context) error { return nil }
+
+// Start starts a span as a child of the span in ctx, if any
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(tracerName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// End marks the span as failed when err is not nil and ends it
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+func Table(name string) attribute.KeyValue {
+	return attribute.String(KeyTable, name)
+}
+
+func Rows(n int64) attribute.KeyValue {
+	return attribute.Int64(KeyRows, n)
+}
+
+func Bytes(n int64) attribute.KeyValue {
+	return attribute.Int64(KeyBytes, n)
+}
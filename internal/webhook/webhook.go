@@ -0,0 +1,334 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/crisog/postgres-migrator/internal/config"
+	"github.com/crisog/postgres-migrator/internal/logging"
+)
+
+// Lifecycle events sent to the webhooks
+const (
+	EventMigrationStarted   = "migration.started"
+	EventMigrationCompleted = "migration.completed"
+	EventMigrationFailed    = "migration.failed"
+	EventValidationFailed   = "validation.failed"
+)
+
+const (
+	// SignatureHeader carries "sha256=" and the hex HMAC-SHA256 of the body keyed with WEBHOOK_SECRET
+	SignatureHeader = "X-Postgres-Migrator-Signature"
+	EventHeader     = "X-Postgres-Migrator-Event"
+
+	queueSize      = 64
+	maxAttempts    = 5
+	initialBackoff = time.Second
+	requestTimeout = 10 * time.Second
+)
+
+// Payload is the JSON body of every webhook request
+type Payload struct {
+	Event             string             `json:"event"`
+	RunID             string             `json:"run_id"`
+	Database          string             `json:"database,omitempty"`
+	Timestamp         time.Time          `json:"timestamp"`
+	Phase             string             `json:"phase,omitempty"`
+	DurationMS        int64              `json:"duration_ms"`
+	DumpDurationMS    int64              `json:"dump_duration_ms,omitempty"`
+	RestoreDurationMS int64              `json:"restore_duration_ms,omitempty"`
+	DumpBytes         int64              `json:"dump_bytes,omitempty"`
+	Validation        *ValidationSummary `json:"validation,omitempty"`
+	Error             string             `json:"error,omitempty"`
+}
+
+// ValidationSummary describes how far validation got before it failed
+type ValidationSummary struct {
+	Tables          int    `json:"tables"`
+	TablesValidated int    `json:"tables_validated"`
+	FailedTable     string `json:"failed_table,omitempty"`
+}
+
+// Notifier posts lifecycle events of one run to every WEBHOOK_URLS entry. Events are
+// queued and delivered in order per URL in the background, so a slow or failing endpoint
+// never holds up the migration. All methods are safe to call on a nil Notifier.
+type Notifier struct {
+	*delivery
+	database string
+
+	mu              sync.Mutex
+	start           time.Time
+	phase           string
+	dumpDuration    time.Duration
+	restoreDuration time.Duration
+	dumpBytes       int64
+	validation      *ValidationSummary
+}
+
+// delivery is shared by a notifier and the notifiers of its databases
+type delivery struct {
+	runID  string
+	secret []byte
+	client *http.Client
+	logger *slog.Logger
+
+	ctx     context.Context
+	cancel  context.CancelFunc
+	queues  []chan Payload
+	workers sync.WaitGroup
+}
+
+// New starts a notifier for the run, or returns nil when no webhook URLs are configured
+func New(cfg *config.Config, runID string, logger *slog.Logger) *Notifier {
+	if len(cfg.WebhookURLs) == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	n := &Notifier{
+		delivery: &delivery{
+			runID:  runID,
+			secret: []byte(cfg.WebhookSecret),
+			client: &http.Client{Timeout: requestTimeout},
+			logger: logger,
+			ctx:    ctx,
+			cancel: cancel,
+		},
+		start: time.Now(),
+	}
+	for _, url := range cfg.WebhookURLs {
+		queue := make(chan Payload, queueSize)
+		n.queues = append(n.queues, queue)
+		n.workers.Add(1)
+		go n.deliverAll(url, queue)
+	}
+	return n
+}
+
+// Database returns a notifier for one database of a cluster run. Its events carry the
+// database name and its own statistics, and go through n's queues, so closing n is enough.
+func (n *Notifier) Database(name string) *Notifier {
+	if n == nil {
+		return nil
+	}
+	return &Notifier{delivery: n.delivery, database: name, start: time.Now()}
+}
+
+// Close stops accepting events and waits until the queued ones are delivered or ctx is
+// done, in which case pending retries are abandoned
+func (n *Notifier) Close(ctx context.Context) {
+	if n == nil {
+		return
+	}
+
+	for _, queue := range n.queues {
+		close(queue)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		n.workers.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		n.logger.Warn("gave up on pending webhook deliveries", logging.Err(ctx.Err()))
+	}
+	n.cancel()
+}
+
+func (n *Notifier) SetPhase(phase string) {
+	if n == nil {
+		return
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.phase = phase
+}
+
+func (n *Notifier) ObserveDump(d time.Duration, bytes int64) {
+	if n == nil {
+		return
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.dumpDuration = d
+	n.dumpBytes = bytes
+}
+
+func (n *Notifier) ObserveRestore(d time.Duration) {
+	if n == nil {
+		return
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.restoreDuration = d
+}
+
+// Started resets the run's statistics and sends migration.started
+func (n *Notifier) Started() {
+	if n == nil {
+		return
+	}
+	n.mu.Lock()
+	n.start = time.Now()
+	n.phase = ""
+	n.dumpDuration, n.restoreDuration, n.dumpBytes = 0, 0, 0
+	n.validation = nil
+	n.mu.Unlock()
+
+	n.send(EventMigrationStarted, nil, nil)
+}
+
+// Validated keeps the summary of a passed validation for migration.completed
+func (n *Notifier) Validated(summary ValidationSummary) {
+	if n == nil {
+		return
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.validation = &summary
+}
+
+// Finished sends migration.completed, with the validation summary when the run was
+// validated, or migration.failed when err is not nil
+func (n *Notifier) Finished(err error) {
+	if n == nil {
+		return
+	}
+	if err != nil {
+		n.send(EventMigrationFailed, nil, err)
+		return
+	}
+	n.mu.Lock()
+	summary := n.validation
+	n.mu.Unlock()
+	n.send(EventMigrationCompleted, summary, nil)
+}
+
+func (n *Notifier) ValidationFailed(summary ValidationSummary, err error) {
+	n.send(EventValidationFailed, &summary, err)
+}
+
+func (n *Notifier) send(event string, summary *ValidationSummary, err error) {
+	if n == nil {
+		return
+	}
+
+	n.mu.Lock()
+	payload := Payload{
+		Event:             event,
+		RunID:             n.runID,
+		Database:          n.database,
+		Timestamp:         time.Now().UTC(),
+		Phase:             n.phase,
+		DurationMS:        time.Since(n.start).Milliseconds(),
+		DumpDurationMS:    n.dumpDuration.Milliseconds(),
+		RestoreDurationMS: n.restoreDuration.Milliseconds(),
+		DumpBytes:         n.dumpBytes,
+		Validation:        summary,
+	}
+	n.mu.Unlock()
+	if err != nil {
+		payload.Error = err.Error()
+	}
+
+	for _, queue := range n.queues {
+		select {
+		case queue <- payload:
+		default:
+			n.logger.Warn("webhook queue is full, dropping event", "event", event)
+		}
+	}
+}
+
+func (n *Notifier) deliverAll(url string, queue <-chan Payload) {
+	defer n.workers.Done()
+
+	for payload := range queue {
+		if err := n.deliver(url, payload); err != nil {
+			n.logger.Warn("webhook delivery failed", "event", payload.Event, "url", url, logging.Err(err))
+		}
+	}
+}
+
+// deliver posts the payload, retrying with exponential backoff on network errors,
+// 429 and 5xx responses
+func (n *Notifier) deliver(url string, payload Payload) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to encode payload: %w", err)
+	}
+	signature := Sign(n.secret, body)
+
+	backoff := initialBackoff
+	for attempt := 1; ; attempt++ {
+		retry, err := n.post(url, payload.Event, body, signature)
+		if err == nil {
+			return nil
+		}
+		if !retry || attempt == maxAttempts {
+			return err
+		}
+
+		select {
+		case <-n.ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+func (n *Notifier) post(url, event string, body []byte, signature string) (retry bool, err error) {
+	req, err := http.NewRequestWithContext(n.ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, event)
+	req.Header.Set(SignatureHeader, signature)
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return n.ctx.Err() == nil, err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	retry = resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+	return retry, fmt.Errorf("webhook returned %s", resp.Status)
+}
+
+// Sign returns the signature header value for body, for receivers to compare against
+func Sign(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+type contextKey struct{}
+
+// NewContext returns a context that carries the notifier to migration.Run and validation
+func NewContext(ctx context.Context, n *Notifier) context.Context {
+	return context.WithValue(ctx, contextKey{}, n)
+}
+
+// FromContext returns the notifier in ctx, or nil
+func FromContext(ctx context.Context) *Notifier {
+	n, _ := ctx.Value(contextKey{}).(*Notifier)
+	return n
+}
@@ -0,0 +1,202 @@
+package migration
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/crisog/postgres-migrator/internal/config"
+	"github.com/crisog/postgres-migrator/internal/database"
+	"github.com/crisog/postgres-migrator/internal/logging"
+	"github.com/crisog/postgres-migrator/internal/metrics"
+	"github.com/crisog/postgres-migrator/internal/migrator"
+	"github.com/crisog/postgres-migrator/internal/tracing"
+)
+
+// Dump writes a durable archive of the source database to cfg.ArchivePath and,
+// when cfg.ArchiveURI is set, uploads it to object storage
+func Dump(ctx context.Context, cfg *config.Config, logger *slog.Logger) (err error) {
+	ctx, span := tracing.Start(ctx, "migration.Dump")
+	defer func() {
+		tracing.End(span, err)
+		finishPhase(ctx, err)
+	}()
+
+	if cfg.ArchivePath != "" {
+		if _, err := os.Stat(cfg.ArchivePath); err == nil {
+			return fmt.Errorf("archive %s already exists, refusing to overwrite it", cfg.ArchivePath)
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to check archive path: %w", err)
+		}
+	}
+
+	if err := validateConnection(ctx, logger.With(logging.Phase("setup")), "source", cfg.SourceDatabaseURL); err != nil {
+		return err
+	}
+
+	dumpLogger := logger.With(logging.Phase("dump"))
+	archiveDir := cfg.WorkDir
+	if cfg.ArchivePath != "" {
+		archiveDir = filepath.Dir(cfg.ArchivePath)
+	}
+	if err := checkDiskSpace(ctx, cfg, dumpLogger, archiveDir); err != nil {
+		return err
+	}
+
+	archivePath := cfg.ArchivePath
+	if archivePath == "" {
+		tmpDir, err := os.MkdirTemp(cfg.WorkDir, "postgres-migrator-*")
+		if err != nil {
+			return fmt.Errorf("failed to create temporary directory: %w", err)
+		}
+		archivePath = filepath.Join(tmpDir, "db.dump")
+		defer removeWorkDir(dumpLogger, archivePath)
+	}
+
+	// Dump next to the final path and rename once pg_dump succeeds, so a partial
+	// archive is never mistaken for a complete one
+	partialPath := archivePath + ".partial"
+	if err := os.RemoveAll(partialPath); err != nil {
+		return fmt.Errorf("failed to remove stale partial archive: %w", err)
+	}
+
+	if err := dump(ctx, cfg, logger, partialPath, tableSizes(ctx, cfg, dumpLogger)); err != nil {
+		os.RemoveAll(partialPath)
+		return err
+	}
+
+	if err := os.Rename(partialPath, archivePath); err != nil {
+		return fmt.Errorf("failed to finalize archive: %w", err)
+	}
+
+	if cfg.ArchivePath != "" {
+		dumpLogger.Info("archive written", logging.File(archivePath))
+	}
+
+	if cfg.ArchiveURI != "" {
+		return migrator.NewDumper(cfg, logger).Upload(ctx, archivePath)
+	}
+
+	return nil
+}
+
+// Restore loads an existing archive into the target database, fetching it from
+// cfg.ArchiveURI first when set
+func Restore(ctx context.Context, cfg *config.Config, logger *slog.Logger) (err error) {
+	ctx, span := tracing.Start(ctx, "migration.Restore")
+	defer func() {
+		tracing.End(span, err)
+		finishPhase(ctx, err)
+	}()
+
+	if err := validateConnection(ctx, logger.With(logging.Phase("setup")), "target", cfg.TargetDatabaseURL); err != nil {
+		return err
+	}
+
+	restoreLogger := logger.With(logging.Phase("restore"))
+	restorer := migrator.NewRestorer(cfg, logger)
+	restorer.SetTableSizes(tableSizes(ctx, cfg, restoreLogger))
+
+	archivePath := cfg.ArchivePath
+	if cfg.ArchiveURI != "" {
+		if archivePath == "" {
+			tmpDir, err := os.MkdirTemp(cfg.WorkDir, "postgres-migrator-*")
+			if err != nil {
+				return fmt.Errorf("failed to create temporary directory: %w", err)
+			}
+			archivePath = filepath.Join(tmpDir, "db.dump")
+			defer removeWorkDir(restoreLogger, archivePath)
+		}
+
+		// A copy fetched by an earlier attempt is reused once its checksum matches
+		if err := restorer.Fetch(ctx, archivePath); err != nil {
+			return fmt.Errorf("fetch failed: %w", err)
+		}
+	}
+
+	if _, err := os.Stat(archivePath); err != nil {
+		return fmt.Errorf("archive %s is not readable: %w", archivePath, err)
+	}
+
+	var state *migrator.State
+	if cfg.StateFile != "" {
+		state, err = migrator.LoadState(cfg.StateFile)
+		if err != nil {
+			return err
+		}
+	}
+
+	resuming := state != nil && state.Unfinished()
+	if resuming && !cfg.Resume {
+		return fmt.Errorf("a previous restore stopped during the %s phase (state file: %s), set RESUME=true to continue it", state.Phase, cfg.StateFile)
+	}
+	if resuming && state.DumpPath != archivePath {
+		return fmt.Errorf("state file %s belongs to archive %s, not %s", cfg.StateFile, state.DumpPath, archivePath)
+	}
+
+	if !resuming && !cfg.DataOnly {
+		tableCountCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		defer cancel()
+
+		targetTableCount, err := database.GetTargetTableCount(tableCountCtx, cfg.TargetDatabaseURL)
+		if err != nil {
+			return err
+		}
+		if targetTableCount > 0 {
+			return fmt.Errorf("target database already has %d tables, set DATA_ONLY=true to restore data into existing tables", targetTableCount)
+		}
+	}
+
+	enterPhase(ctx, metrics.PhaseRestore)
+	restoreStart := time.Now()
+
+	if state != nil {
+		if !resuming {
+			state.DumpPath = archivePath
+			state.RestoredEntries = nil
+		}
+		if err := state.SetPhase(migrator.PhaseRestoring); err != nil {
+			return err
+		}
+		err = restorer.RestoreWithState(ctx, archivePath, state)
+	} else {
+		err = restorer.Restore(ctx, archivePath)
+	}
+	if err != nil {
+		return fmt.Errorf("restore failed: %w", err)
+	}
+
+	if state != nil {
+		if err := state.SetPhase(migrator.PhaseCompleted); err != nil {
+			return err
+		}
+	}
+
+	observeRestore(ctx, time.Since(restoreStart))
+	restoreLogger.Info("restore completed", logging.Duration(time.Since(restoreStart)))
+
+	return nil
+}
+
+func validateConnection(ctx context.Context, logger *slog.Logger, label, databaseURL string) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	logger.Info("validating " + label + " database connection")
+
+	if err := database.ValidateConnection(ctx, databaseURL); err != nil {
+		return fmt.Errorf("%s database validation failed: %w", label, err)
+	}
+
+	version, err := database.GetVersion(ctx, databaseURL)
+	if err != nil {
+		return fmt.Errorf("unable to get %s database version: %w", label, err)
+	}
+	logger.Info(label+" database connected", "version", version)
+
+	return nil
+}
@@ -0,0 +1,209 @@
+package migration
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/crisog/postgres-migrator/internal/config"
+	"github.com/crisog/postgres-migrator/internal/database"
+	"github.com/crisog/postgres-migrator/internal/logging"
+	"github.com/crisog/postgres-migrator/internal/metrics"
+	"github.com/crisog/postgres-migrator/internal/tracing"
+	"github.com/crisog/postgres-migrator/internal/webhook"
+)
+
+// Cluster migrates every non-template database of the source server. The URLs in cfg
+// point at the servers; databases missing on the target are created first, followed by
+// the globals with MIGRATE_GLOBALS, then each one goes through Migrate, up to
+// PARALLEL_DATABASES at a time. A failed database does not stop the others, the
+// returned error names every database that failed.
+func Cluster(ctx context.Context, cfg *config.Config, logger *slog.Logger) (err error) {
+	ctx, span := tracing.Start(ctx, "migration.Cluster", attribute.Int("parallel_databases", cfg.ParallelDatabases))
+	defer func() {
+		tracing.End(span, err)
+		finishPhase(ctx, err)
+	}()
+
+	start := time.Now()
+
+	databases, err := database.ListDatabases(ctx, cfg.SourceDatabaseURL)
+	if err != nil {
+		return fmt.Errorf("failed to list source databases: %w", err)
+	}
+	databases, err = excludeDatabases(databases, cfg.ExcludeDatabases)
+	if err != nil {
+		return err
+	}
+	span.SetAttributes(attribute.Int("databases", len(databases)))
+
+	names := make([]string, 0, len(databases))
+	for _, db := range databases {
+		names = append(names, db.Name)
+	}
+	logger.Info("source databases to migrate", "databases", names)
+
+	var ownerless []database.DatabaseInfo
+	for _, db := range databases {
+		ownerSet, err := ensureDatabase(ctx, cfg.TargetDatabaseURL, db, logger)
+		if err != nil {
+			return err
+		}
+		if !ownerSet {
+			ownerless = append(ownerless, db)
+		}
+	}
+
+	if cfg.MigrateGlobals {
+		mapping := make(map[string]string, len(databases))
+		for _, db := range databases {
+			mapping[db.Name] = db.Name
+		}
+		if err := migrateGlobals(ctx, cfg, logger, mapping); err != nil {
+			return err
+		}
+	}
+
+	// Owner roles may have been created by the globals phase since
+	for _, db := range ownerless {
+		ownerSet := false
+		if cfg.MigrateGlobals {
+			if ownerSet, err = database.SetDatabaseOwner(ctx, cfg.TargetDatabaseURL, db); err != nil {
+				return err
+			}
+		}
+		if ownerSet {
+			logger.Info("database owner set", logging.Database(db.Name), "owner", db.Owner)
+		} else {
+			logger.Warn("owner role missing on target, database is owned by the connecting user", logging.Database(db.Name), "owner", db.Owner)
+		}
+	}
+
+	workers := cfg.ParallelDatabases
+	if workers > len(databases) {
+		workers = len(databases)
+	}
+
+	queue := make(chan database.DatabaseInfo)
+	var (
+		mu     sync.Mutex
+		failed []string
+		wg     sync.WaitGroup
+	)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for db := range queue {
+				if err := migrateDatabase(ctx, cfg, db.Name, logger); err != nil {
+					mu.Lock()
+					failed = append(failed, db.Name)
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+
+feed:
+	for _, db := range databases {
+		select {
+		case queue <- db:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(queue)
+	wg.Wait()
+
+	summary := []any{"databases", len(databases), "succeeded", len(databases) - len(failed), "failed", len(failed), logging.Duration(time.Since(start))}
+	if len(failed) > 0 {
+		logger.Error("cluster migration summary", append(summary, "failed_databases", failed)...)
+		return fmt.Errorf("%d of %d databases failed: %s", len(failed), len(databases), strings.Join(failed, ", "))
+	}
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("cluster migration cancelled: %w", err)
+	}
+	logger.Info("cluster migration summary", summary...)
+	return nil
+}
+
+func migrateDatabase(ctx context.Context, cfg *config.Config, name string, logger *slog.Logger) (err error) {
+	ctx, span := tracing.Start(ctx, "migration.Database", attribute.String("database", name))
+	defer func() { tracing.End(span, err) }()
+
+	// Databases migrate in parallel, so each reports its own phase and statistics
+	ctx = webhook.NewContext(ctx, webhook.FromContext(ctx).Database(name))
+	ctx = metrics.NewContext(ctx, metrics.FromContext(ctx).Database(name))
+
+	logger = logger.With(logging.Database(name))
+	start := time.Now()
+
+	dbCfg := *cfg
+	dbCfg.MigrateGlobals = false
+	if dbCfg.SourceDatabaseURL, err = database.DatabaseURL(cfg.SourceDatabaseURL, name); err != nil {
+		return err
+	}
+	if dbCfg.TargetDatabaseURL, err = database.DatabaseURL(cfg.TargetDatabaseURL, name); err != nil {
+		return err
+	}
+
+	logger.Info("database migration started")
+	if err := Migrate(ctx, &dbCfg, logger); err != nil {
+		logger.Error("database migration failed", logging.Duration(time.Since(start)), logging.Err(err))
+		return err
+	}
+	logger.Info("database migrated", logging.Duration(time.Since(start)))
+	return nil
+}
+
+// ensureDatabase creates the database on the target unless it already exists. ownerSet
+// is false when it was created without its owner role.
+func ensureDatabase(ctx context.Context, targetURL string, db database.DatabaseInfo, logger *slog.Logger) (ownerSet bool, err error) {
+	found, err := database.DatabaseExists(ctx, targetURL, db.Name)
+	if err != nil {
+		return false, fmt.Errorf("failed to check target database %s: %w", db.Name, err)
+	}
+	if found {
+		return true, nil
+	}
+
+	ownerSet, err = database.CreateDatabase(ctx, targetURL, db)
+	if err != nil {
+		return false, err
+	}
+	attrs := []any{logging.Database(db.Name), "encoding", db.Encoding, "collate", db.Collate, "ctype", db.Ctype}
+	if ownerSet {
+		attrs = append(attrs, "owner", db.Owner)
+	}
+	logger.Info("database created on target", attrs...)
+	return ownerSet, nil
+}
+
+// excludeDatabases drops the databases matching any EXCLUDE_DATABASES pattern, with *
+// and ? as wildcards
+func excludeDatabases(databases []database.DatabaseInfo, patterns []string) ([]database.DatabaseInfo, error) {
+	var kept []database.DatabaseInfo
+	for _, db := range databases {
+		excluded := false
+		for _, pattern := range patterns {
+			match, err := path.Match(pattern, db.Name)
+			if err != nil {
+				return nil, fmt.Errorf("invalid EXCLUDE_DATABASES pattern %q: %w", pattern, err)
+			}
+			if match {
+				excluded = true
+				break
+			}
+		}
+		if !excluded {
+			kept = append(kept, db)
+		}
+	}
+	return kept, nil
+}
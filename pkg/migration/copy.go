@@ -0,0 +1,131 @@
+package migration
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/crisog/postgres-migrator/internal/config"
+	"github.com/crisog/postgres-migrator/internal/copier"
+	"github.com/crisog/postgres-migrator/internal/database"
+	"github.com/crisog/postgres-migrator/internal/logging"
+	"github.com/crisog/postgres-migrator/internal/masking"
+	"github.com/crisog/postgres-migrator/internal/metrics"
+)
+
+// runCopy moves the rows with COPY over pgx instead of pg_restore. The schema still comes
+// from pg_dump: tables are created first, rows are copied on up to PARALLEL_JOBS
+// connections, then indexes, constraints and triggers are added. Masked columns are
+// rewritten before they reach the target. With DATA_ONLY the existing target schema is
+// used instead.
+func runCopy(ctx context.Context, cfg *config.Config, logger *slog.Logger, targetTableCount int) error {
+	rules, err := loadMasking(cfg)
+	if err != nil {
+		return err
+	}
+
+	if targetTableCount > 0 && !cfg.DataOnly {
+		return fmt.Errorf("target database already has %d tables, the copy engine needs an empty target or DATA_ONLY=true", targetTableCount)
+	}
+
+	if err := migrateSourceGlobals(ctx, cfg, logger); err != nil {
+		return err
+	}
+
+	tables, err := copiedTables(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	if err := copier.CheckMasking(ctx, cfg.SourceDatabaseURL, rules, tables); err != nil {
+		return err
+	}
+
+	start := time.Now()
+
+	var schemaFile string
+	if !cfg.DataOnly {
+		schemaFile, err = dumpSchema(ctx, cfg, logger)
+		if err != nil {
+			return err
+		}
+		defer removeWorkDir(logger.With(logging.Phase("copy")), schemaFile)
+
+		if err := restoreSection(ctx, cfg, logger, schemaFile, "pre-data"); err != nil {
+			return err
+		}
+	}
+
+	copyLogger := logger.With(logging.Phase("copy"))
+	attrs := []any{logging.Tables(len(tables)), "workers", cfg.ParallelJobs}
+	if rules != nil {
+		attrs = append(attrs, "masked_columns", len(rules.Columns))
+	}
+	copyLogger.Info("copy started", attrs...)
+
+	// Without DATA_ONLY the constraints only arrive with post-data, so rows load in any
+	// order without disabling triggers
+	rowCopier, err := copier.Open(ctx, cfg.SourceDatabaseURL, cfg.TargetDatabaseURL, copier.Options{
+		Masking:         rules,
+		DisableTriggers: cfg.DataOnly,
+		ChunkRows:       int64(cfg.ChunkRows),
+		Retries:         cfg.ChunkRetries,
+		Logger:          copyLogger,
+	})
+	if err != nil {
+		return err
+	}
+	defer rowCopier.Close(ctx)
+
+	enterPhase(ctx, metrics.PhaseCopy)
+	copyStart := time.Now()
+	total, err := rowCopier.CopyTables(ctx, tables, cfg.ParallelJobs, func(table database.TableInfo, rows int64) {
+		metrics.FromContext(ctx).TableRestored()
+		copyLogger.Info("table copied", logging.Table(table.Schema+"."+table.Name), logging.Rows(rows))
+	})
+	if err != nil {
+		return fmt.Errorf("copy failed: %w", err)
+	}
+	observeRestore(ctx, time.Since(copyStart))
+	copyLogger.Info("copy completed", logging.Duration(time.Since(copyStart)), logging.Rows(total), logging.Tables(len(tables)))
+
+	if schemaFile != "" {
+		copyLogger.Info("creating indexes, constraints and triggers")
+		if err := restoreSection(ctx, cfg, logger, schemaFile, "post-data"); err != nil {
+			return err
+		}
+	}
+
+	if err := syncSequences(ctx, cfg, copyLogger); err != nil {
+		return err
+	}
+
+	copyLogger.Info("migration data transfer completed", logging.Duration(time.Since(start)))
+
+	return nil
+}
+
+// loadMasking returns the masking rules, or nil when MASKING_FILE is not set
+func loadMasking(cfg *config.Config) (*masking.Rules, error) {
+	if cfg.MaskingFile == "" {
+		return nil, nil
+	}
+	return masking.Load(cfg.MaskingFile)
+}
+
+// copiedTables lists the source tables whose rows are copied. Rows live in the
+// partitions, which are listed as tables of their own, so partitioned parents are skipped.
+func copiedTables(ctx context.Context, cfg *config.Config) ([]database.TableInfo, error) {
+	inventory, err := database.GetInventory(ctx, cfg.SourceDatabaseURL, cfg.ExcludeSchemas)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list source tables: %w", err)
+	}
+
+	var tables []database.TableInfo
+	for _, table := range filterTables(cfg, inventory.Tables) {
+		if !table.Partitioned {
+			tables = append(tables, table)
+		}
+	}
+	return tables, nil
+}
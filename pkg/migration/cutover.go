@@ -0,0 +1,210 @@
+package migration
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/crisog/postgres-migrator/internal/config"
+	"github.com/crisog/postgres-migrator/internal/database"
+	"github.com/crisog/postgres-migrator/internal/logging"
+	"github.com/crisog/postgres-migrator/internal/metrics"
+	"github.com/crisog/postgres-migrator/internal/tracing"
+	"github.com/crisog/postgres-migrator/pkg/validation"
+)
+
+const rollbackTimeout = time.Minute
+
+type cutoverStep struct {
+	name string
+	undo func(ctx context.Context) error
+}
+
+// Cutover freezes writes on the source, waits for the target to catch up, copies sequence
+// values and gates on matching row counts. If any step fails, the completed steps are
+// undone in reverse order so the source takes writes again.
+func Cutover(ctx context.Context, cfg *config.Config, logger *slog.Logger) (err error) {
+	ctx, span := tracing.Start(ctx, "migration.Cutover")
+	defer func() {
+		tracing.End(span, err)
+		finishPhase(ctx, err)
+	}()
+
+	// The row count gate tags its records with the validate phase itself
+	baseLogger := logger
+	logger = logger.With(logging.Phase("cutover"))
+	enterPhase(ctx, metrics.PhaseCutover)
+	start := time.Now()
+
+	if _, err := database.ValidateBothConnections(ctx, logger, cfg.SourceDatabaseURL, cfg.TargetDatabaseURL, cfg.SkipVersionCheck, false, nil, nil); err != nil {
+		return fmt.Errorf("connection validation failed: %w", err)
+	}
+
+	subscribed, err := database.SubscriptionExists(ctx, cfg.TargetDatabaseURL, cfg.SubscriptionName)
+	if err != nil {
+		return fmt.Errorf("failed to check for subscription %s: %w", cfg.SubscriptionName, err)
+	}
+
+	var completed []cutoverStep
+	fail := func(err error) error {
+		return rollbackCutover(logger, completed, err)
+	}
+
+	logger.Info("freezing writes on the source", "step", "1/5")
+	frozenAt, err := database.FreezeWrites(ctx, cfg.SourceDatabaseURL)
+	if err != nil {
+		return fail(err)
+	}
+	completed = append(completed, cutoverStep{
+		name: "source write freeze",
+		undo: func(ctx context.Context) error {
+			return database.UnfreezeWrites(ctx, cfg.SourceDatabaseURL)
+		},
+	})
+	logger.Info("source database is read-only for new sessions")
+
+	logger.Info("waiting for in-flight transactions to finish", "step", "2/5")
+	if err := waitForTransactions(ctx, cfg, logger, frozenAt); err != nil {
+		return fail(err)
+	}
+
+	logger.Info("waiting for the target to catch up", "step", "3/5")
+	if subscribed {
+		if err := waitForCatchUp(ctx, cfg, logger); err != nil {
+			return fail(err)
+		}
+	} else {
+		logger.Info("no subscription on the target, nothing to catch up", "subscription", cfg.SubscriptionName)
+	}
+
+	logger.Info("copying sequence values to the target", "step", "4/5")
+	previous, err := database.GetSequenceStates(ctx, cfg.TargetDatabaseURL, nil)
+	if err != nil {
+		return fail(fmt.Errorf("failed to read target sequences: %w", err))
+	}
+	if err := syncSequences(ctx, cfg, logger); err != nil {
+		return fail(err)
+	}
+	completed = append(completed, cutoverStep{
+		name: "sequence values",
+		undo: func(ctx context.Context) error {
+			_, err := database.ApplySequenceStates(ctx, cfg.TargetDatabaseURL, previous)
+			return err
+		},
+	})
+
+	logger.Info("checking row counts", "step", "5/5")
+	if err := validation.ValidateRowCountsFromURLs(ctx, cfg.SourceDatabaseURL, cfg.TargetDatabaseURL, TableFilter(cfg), baseLogger); err != nil {
+		return fail(fmt.Errorf("row count gate failed: %w", err))
+	}
+
+	if subscribed {
+		logger.Info("dropping subscription and publication", "subscription", cfg.SubscriptionName, "publication", cfg.PublicationName)
+		if err := database.DropSubscription(ctx, cfg.TargetDatabaseURL, cfg.SubscriptionName); err != nil {
+			return fail(fmt.Errorf("failed to drop subscription: %w", err))
+		}
+		if err := database.DropPublication(ctx, cfg.SourceDatabaseURL, cfg.PublicationName); err != nil {
+			logger.Warn("failed to drop publication", "publication", cfg.PublicationName, logging.Err(err))
+		}
+	}
+
+	logger.Info("cutover completed, the source stays read-only, point applications at the target", logging.Duration(time.Since(start)))
+
+	return nil
+}
+
+func waitForTransactions(ctx context.Context, cfg *config.Config, logger *slog.Logger, frozenAt time.Time) error {
+	deadline := time.Now().Add(cfg.CutoverTimeout)
+	for {
+		count, err := database.InFlightTransactions(ctx, cfg.SourceDatabaseURL, frozenAt)
+		if err != nil {
+			return err
+		}
+		if count == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%d transactions still open after %v", count, cfg.CutoverTimeout)
+		}
+
+		logger.Info("waiting for in-flight transactions", "transactions", count)
+		if err := sleep(ctx, onlinePollInterval); err != nil {
+			return err
+		}
+	}
+
+	// Idle sessions from before the freeze could still write, so they are disconnected
+	// and come back read-only
+	terminated, err := database.TerminateSessions(ctx, cfg.SourceDatabaseURL, frozenAt)
+	if err != nil {
+		return err
+	}
+	logger.Info("no in-flight transactions left, disconnected sessions opened before the freeze", "sessions", terminated)
+
+	return nil
+}
+
+func waitForCatchUp(ctx context.Context, cfg *config.Config, logger *slog.Logger) error {
+	lsn, err := database.CurrentWALPosition(ctx, cfg.SourceDatabaseURL)
+	if err != nil {
+		return err
+	}
+
+	deadline := time.Now().Add(cfg.CutoverTimeout)
+	for {
+		caughtUp, err := database.ReplicationCaughtUp(ctx, cfg.SourceDatabaseURL, cfg.SubscriptionName, lsn)
+		if err != nil {
+			return err
+		}
+		if caughtUp {
+			logger.Info("target has replicated everything up to the freeze", "lsn", lsn)
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("target did not reach WAL position %s within %v", lsn, cfg.CutoverTimeout)
+		}
+
+		if lag, err := database.ReplicationLag(ctx, cfg.SourceDatabaseURL, cfg.SubscriptionName); err == nil {
+			logger.Info("replication lag", "lag_bytes", lag)
+		}
+		if err := sleep(ctx, onlinePollInterval); err != nil {
+			return err
+		}
+	}
+}
+
+func rollbackCutover(logger *slog.Logger, completed []cutoverStep, cause error) error {
+	// The run context may already be cancelled, which must not stop the rollback
+	ctx, cancel := context.WithTimeout(context.Background(), rollbackTimeout)
+	defer cancel()
+
+	logger.Error("cutover failed, rolling back", logging.Err(cause))
+
+	var errs []error
+	for i := len(completed) - 1; i >= 0; i-- {
+		step := completed[i]
+		logger.Info("rolling back", "step", step.name)
+		if err := step.undo(ctx); err != nil {
+			logger.Error("rollback failed", "step", step.name, logging.Err(err))
+			errs = append(errs, fmt.Errorf("rollback of %s failed: %w", step.name, err))
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("cutover failed: %w", errors.Join(append([]error{cause}, errs...)...))
+	}
+
+	logger.Info("rollback completed, the source accepts writes again")
+	return fmt.Errorf("cutover failed and was rolled back: %w", cause)
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	select {
+	case <-ctx.Done():
+		return fmt.Errorf("operation cancelled")
+	case <-time.After(d):
+		return nil
+	}
+}
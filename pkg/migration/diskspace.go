@@ -0,0 +1,61 @@
+package migration
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/crisog/postgres-migrator/internal/config"
+	"github.com/crisog/postgres-migrator/internal/database"
+	"github.com/crisog/postgres-migrator/internal/diskspace"
+	"github.com/crisog/postgres-migrator/internal/logging"
+)
+
+// Dumps store indexes as definitions only, so a compressed dump is usually well under
+// half of pg_database_size; an uncompressed one can approach the full size
+const (
+	compressedDumpRatio   = 0.5
+	uncompressedDumpRatio = 1.0
+)
+
+func checkDiskSpace(ctx context.Context, cfg *config.Config, logger *slog.Logger, dir string) error {
+	if cfg.SkipDiskCheck {
+		logger.Info("skipping disk space check (SKIP_DISK_CHECK is enabled)")
+		return nil
+	}
+
+	if dir == "" {
+		dir = os.TempDir()
+	}
+
+	sizeCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	databaseSize, err := database.GetDatabaseSize(sizeCtx, cfg.SourceDatabaseURL)
+	if err != nil {
+		return fmt.Errorf("disk space check failed: %w", err)
+	}
+
+	ratio := compressedDumpRatio
+	if cfg.CompressionSpec().Method == config.CompressionNone {
+		ratio = uncompressedDumpRatio
+	}
+	estimate := uint64(float64(databaseSize) * ratio)
+
+	free, err := diskspace.Free(dir)
+	if err != nil {
+		logger.Warn("unable to check free disk space", "dir", dir, logging.Err(err))
+		return nil
+	}
+
+	logger.Info("disk space check", "dir", dir, "database_bytes", databaseSize, "estimated_bytes", estimate, "free_bytes", free)
+
+	if estimate > free {
+		return fmt.Errorf("not enough disk space in %s for the dump: estimated %s needed, %s free (set WORK_DIR to a larger filesystem, or SKIP_DISK_CHECK=true to proceed anyway)",
+			dir, formatBytes(int64(estimate)), formatBytes(int64(free)))
+	}
+
+	return nil
+}
@@ -0,0 +1,53 @@
+package migration
+
+import (
+	"github.com/crisog/postgres-migrator/internal/config"
+	"github.com/crisog/postgres-migrator/internal/database"
+	"github.com/crisog/postgres-migrator/pkg/validation"
+)
+
+// TableFilter returns the table filters from cfg in the form validation expects
+func TableFilter(cfg *config.Config) validation.TableFilter {
+	return validation.TableFilter{
+		Include:     cfg.IncludeTables,
+		Exclude:     cfg.ExcludeTables,
+		ExcludeData: cfg.ExcludeTableData,
+	}
+}
+
+// ValidationFilter extends TableFilter with the masked columns, whose contents cannot be
+// compared against the source
+func ValidationFilter(cfg *config.Config) (validation.TableFilter, error) {
+	filter := TableFilter(cfg)
+
+	rules, err := loadMasking(cfg)
+	if err != nil {
+		return filter, err
+	}
+	if rules != nil {
+		filter.MaskedColumns = rules.ColumnNames()
+	}
+
+	return filter, nil
+}
+
+// migratesRows reports whether the rows of a table are migrated, and so published when
+// replicating
+func migratesRows(cfg *config.Config) func(schema, table string) bool {
+	filter := TableFilter(cfg)
+	return func(schema, table string) bool {
+		return filter.Includes(schema, table) && !filter.DataExcluded(schema, table)
+	}
+}
+
+// filterTables keeps the tables whose rows are migrated
+func filterTables(cfg *config.Config, tables []database.TableInfo) []database.TableInfo {
+	migrates := migratesRows(cfg)
+	var kept []database.TableInfo
+	for _, table := range tables {
+		if migrates(table.Schema, table.Name) {
+			kept = append(kept, table)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,192 @@
+package migration
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/crisog/postgres-migrator/internal/config"
+	"github.com/crisog/postgres-migrator/internal/database"
+	"github.com/crisog/postgres-migrator/internal/logging"
+	"github.com/crisog/postgres-migrator/internal/metrics"
+	"github.com/crisog/postgres-migrator/internal/migrator"
+	"github.com/crisog/postgres-migrator/internal/tracing"
+)
+
+var (
+	superuserAttributePattern = regexp.MustCompile(`\s+(?:NO)?(?:SUPERUSER|REPLICATION|BYPASSRLS)\b`)
+	loginAttributePattern     = regexp.MustCompile(`\bLOGIN\b`)
+	grantedByPattern          = regexp.MustCompile(`\s+GRANTED BY (?:"(?:[^"]|"")*"|[^\s;]+)`)
+)
+
+// migrateGlobals creates the roles of the source server on the target, with their
+// memberships and role settings, so a restore with NO_OWNER=false finds every owner.
+// Roles that already exist on the target keep their attributes and password. databases
+// maps source database names to target ones; settings for other databases are skipped.
+func migrateGlobals(ctx context.Context, cfg *config.Config, logger *slog.Logger, databases map[string]string) (err error) {
+	ctx, span := tracing.Start(ctx, "migration.Globals",
+		attribute.Bool("skip_superuser", cfg.GlobalsSkipSuperuser),
+		attribute.Bool("skip_tablespaces", cfg.GlobalsSkipTablespaces),
+		attribute.String("passwords", cfg.GlobalsPasswords),
+	)
+	defer func() { tracing.End(span, err) }()
+
+	logger = logger.With(logging.Phase("globals"))
+	enterPhase(ctx, metrics.PhaseGlobals)
+	start := time.Now()
+
+	script, err := migrator.DumpGlobals(ctx, cfg)
+	if err != nil {
+		return fmt.Errorf("globals dump failed: %w", err)
+	}
+	existing, err := database.RoleNames(ctx, cfg.TargetDatabaseURL)
+	if err != nil {
+		return err
+	}
+
+	var (
+		statements  []migrator.GlobalStatement
+		created     []string
+		kept        []string
+		placeholder []string
+	)
+	for _, statement := range migrator.SplitGlobals(script) {
+		switch statement.Kind {
+		case migrator.GlobalCreateRole:
+			if existing[statement.Role] {
+				kept = append(kept, statement.Role)
+				continue
+			}
+			created = append(created, statement.Role)
+		case migrator.GlobalRoleAttributes:
+			if existing[statement.Role] {
+				continue
+			}
+			var replaced bool
+			if statement.SQL, replaced, err = roleAttributes(cfg, statement.SQL); err != nil {
+				return err
+			}
+			if replaced {
+				placeholder = append(placeholder, statement.Role)
+			}
+		case migrator.GlobalRoleSetting:
+			if statement.Database != "" {
+				target, ok := databases[statement.Database]
+				if !ok {
+					continue
+				}
+				statement.SQL = statement.InDatabase(target)
+			}
+		case migrator.GlobalRoleMembership:
+			statement.SQL = grantedByPattern.ReplaceAllString(statement.SQL, "")
+		case migrator.GlobalParameterGrant:
+			if cfg.GlobalsSkipSuperuser {
+				continue
+			}
+		case migrator.GlobalTablespace:
+			if cfg.GlobalsSkipTablespaces {
+				continue
+			}
+		}
+		statements = append(statements, statement)
+	}
+
+	sqls := make([]string, len(statements))
+	for i, statement := range statements {
+		sqls[i] = statement.SQL
+	}
+
+	warnings := 0
+	err = database.ExecEach(ctx, cfg.TargetDatabaseURL, sqls, func(i int, err error) error {
+		statement := statements[i]
+		switch statement.Kind {
+		case migrator.GlobalSession:
+			return fmt.Errorf("failed to prepare globals session: %w", err)
+		case migrator.GlobalCreateRole, migrator.GlobalRoleAttributes:
+			// The statement is left out of the error, it may carry a password
+			return fmt.Errorf("failed to create role %s: %w", statement.Role, err)
+		}
+		warnings++
+		logger.Warn("global object not migrated", "kind", statement.Kind, "statement", statement.SQL, logging.Err(err))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	logger.Info("globals migrated",
+		"roles_created", len(created),
+		"roles_existing", len(kept),
+		"warnings", warnings,
+		logging.Duration(time.Since(start)),
+	)
+	if len(kept) > 0 {
+		logger.Info("existing roles left unchanged", "roles", kept)
+	}
+	if len(placeholder) > 0 {
+		logger.Warn("login roles created with a random password, set their passwords before they connect", "roles", placeholder)
+	}
+	return nil
+}
+
+// migrateSourceGlobals runs the globals phase of a single database migration when
+// MIGRATE_GLOBALS is set, with the source database's role settings applied to the target
+// database. Every engine calls it only once the target passed its checks, so a run that
+// stops there or only validates an existing target leaves the roles alone.
+func migrateSourceGlobals(ctx context.Context, cfg *config.Config, logger *slog.Logger) error {
+	if !cfg.MigrateGlobals {
+		return nil
+	}
+	source, err := databaseName(cfg.SourceDatabaseURL)
+	if err != nil {
+		return err
+	}
+	target, err := databaseName(cfg.TargetDatabaseURL)
+	if err != nil {
+		return err
+	}
+	return migrateGlobals(ctx, cfg, logger, map[string]string{source: target})
+}
+
+// roleAttributes adapts the attributes of a new role to the target: superuser-only ones
+// are dropped with GLOBALS_SKIP_SUPERUSER, and login roles get a random password when
+// the hashes are not copied, reported by placeholder
+func roleAttributes(cfg *config.Config, sql string) (_ string, placeholder bool, err error) {
+	if cfg.GlobalsSkipSuperuser {
+		sql = superuserAttributePattern.ReplaceAllString(sql, "")
+	}
+	if cfg.GlobalsPasswords != config.GlobalsPasswordsPlaceholder || !loginAttributePattern.MatchString(sql) {
+		return sql, false, nil
+	}
+
+	password, err := placeholderPassword()
+	if err != nil {
+		return "", false, err
+	}
+	return strings.TrimSuffix(sql, ";") + " PASSWORD '" + password + "';", true, nil
+}
+
+func placeholderPassword() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate placeholder password: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// databaseName returns the database a connection string points at
+func databaseName(databaseURL string) (string, error) {
+	connConfig, err := pgx.ParseConfig(databaseURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid connection string: %w", err)
+	}
+	return connConfig.Database, nil
+}
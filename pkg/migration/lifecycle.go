@@ -0,0 +1,39 @@
+package migration
+
+import (
+	"context"
+	"time"
+
+	"github.com/crisog/postgres-migrator/internal/metrics"
+	"github.com/crisog/postgres-migrator/internal/webhook"
+)
+
+// The helpers below report progress both to the metrics and to the run's webhook notifier
+
+func enterPhase(ctx context.Context, phase string) {
+	metrics.FromContext(ctx).SetPhase(phase)
+	webhook.FromContext(ctx).SetPhase(phase)
+}
+
+// finishPhase records the outcome of a command in its metrics. Every command that moves
+// data calls it once when it returns; Plan writes nothing and keeps its last phase.
+func finishPhase(ctx context.Context, err error) {
+	recorder := metrics.FromContext(ctx)
+	if err != nil {
+		recorder.SetPhase(metrics.PhaseFailed)
+		recorder.RecordError()
+		return
+	}
+	recorder.SetPhase(metrics.PhaseCompleted)
+}
+
+// observeDump reports a finished dump, bytes is negative when the archive size is unknown
+func observeDump(ctx context.Context, d time.Duration, bytes int64) {
+	metrics.FromContext(ctx).ObserveDump(d, bytes)
+	webhook.FromContext(ctx).ObserveDump(d, max(bytes, 0))
+}
+
+func observeRestore(ctx context.Context, d time.Duration) {
+	metrics.FromContext(ctx).ObserveRestore(d)
+	webhook.FromContext(ctx).ObserveRestore(d)
+}
@@ -2,80 +2,372 @@ package migration
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"time"
 
+	"go.opentelemetry.io/otel/attribute"
+
 	"github.com/crisog/postgres-migrator/internal/config"
 	"github.com/crisog/postgres-migrator/internal/database"
+	"github.com/crisog/postgres-migrator/internal/logging"
+	"github.com/crisog/postgres-migrator/internal/metrics"
 	"github.com/crisog/postgres-migrator/internal/migrator"
+	"github.com/crisog/postgres-migrator/internal/tracing"
+	"github.com/crisog/postgres-migrator/internal/webhook"
+	"github.com/crisog/postgres-migrator/pkg/validation"
 )
 
-func Run(ctx context.Context, cfg *config.Config, logger *log.Logger) (skipMigration bool, err error) {
-	logger.Println("postgres-migrator starting...")
+// Run migrates the data without the post-migration validation. skipMigration is true
+// when the target already had tables and nothing was transferred.
+func Run(ctx context.Context, cfg *config.Config, logger *slog.Logger) (skipMigration bool, err error) {
+	return run(ctx, cfg, logger, nil)
+}
+
+// run wraps the transfer in the migration.Run span and the run's lifecycle events. then
+// runs inside both once the transfer succeeded, so the post-migration validation is part
+// of the run's trace and migration.completed is only sent after it.
+func run(ctx context.Context, cfg *config.Config, logger *slog.Logger, then func(ctx context.Context, skipped bool) error) (skipMigration bool, err error) {
+	ctx, span := tracing.Start(ctx, "migration.Run",
+		attribute.String("engine", engineName(cfg)),
+		attribute.Int("parallel_jobs", cfg.ParallelJobs),
+		attribute.Bool("online", cfg.Online),
+		attribute.Bool("data_only", cfg.DataOnly),
+	)
+	notifier := webhook.FromContext(ctx)
+	notifier.Started()
+	defer func() {
+		span.SetAttributes(attribute.Bool("skipped", skipMigration))
+		tracing.End(span, err)
+		finishPhase(ctx, err)
+		notifier.Finished(err)
+	}()
+
+	skipMigration, err = transfer(ctx, cfg, logger)
+	if err == nil && then != nil {
+		err = then(ctx, skipMigration)
+	}
+	return skipMigration, err
+}
+
+// transfer tags its own records with the phase they belong to. The components it calls
+// get the untagged logger, since those that log set their own phase.
+func transfer(ctx context.Context, cfg *config.Config, logger *slog.Logger) (skipMigration bool, err error) {
+	setupLogger := logger.With(logging.Phase("setup"))
 	if cfg.ParallelJobs > 1 {
-		logger.Printf("Parallel jobs: %d\n", cfg.ParallelJobs)
+		setupLogger.Info("parallel jobs enabled", logging.Jobs(cfg.ParallelJobs))
 	}
 
-	targetTableCount, err := database.ValidateBothConnections(logger, cfg.SourceDatabaseURL, cfg.TargetDatabaseURL, cfg.SkipVersionCheck)
+	targetTableCount, err := database.ValidateBothConnections(ctx, setupLogger, cfg.SourceDatabaseURL, cfg.TargetDatabaseURL, cfg.SkipVersionCheck, cfg.Online, cfg.ExcludeSchemas, migratesRows(cfg))
 	if err != nil {
 		return false, fmt.Errorf("connection validation failed: %w", err)
 	}
 
-	if targetTableCount > 0 {
+	if cfg.Online {
+		return false, runOnline(ctx, cfg, logger, targetTableCount)
+	}
+
+	if cfg.SubsetFile != "" {
+		return false, runSubset(ctx, cfg, logger, targetTableCount)
+	}
+
+	if cfg.CopyEngine() {
+		return false, runCopy(ctx, cfg, logger, targetTableCount)
+	}
+
+	var state *migrator.State
+	if cfg.StateFile != "" {
+		state, err = migrator.LoadState(cfg.StateFile)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	resuming := state != nil && state.Unfinished()
+	if resuming && !cfg.Resume {
+		return false, fmt.Errorf("a previous migration stopped during the %s phase (state file: %s), set RESUME=true to continue it", state.Phase, cfg.StateFile)
+	}
+
+	if targetTableCount > 0 && !resuming {
 		if cfg.DataOnly {
-			logger.Printf("Target database already has %d tables, proceeding with data-only restore (DATA_ONLY is enabled)...\n", targetTableCount)
+			setupLogger.Info("target database has tables, proceeding with data-only restore (DATA_ONLY is enabled)", logging.Tables(targetTableCount))
 		} else {
-			logger.Printf("Target database already has %d tables, skipping migration and running validation only...\n", targetTableCount)
+			setupLogger.Info("target database has tables, skipping migration and running validation only", logging.Tables(targetTableCount))
 			return true, nil
 		}
 	}
 
-	tmpDir, err := os.MkdirTemp("", "postgres-migrator-*")
+	if err := migrateSourceGlobals(ctx, cfg, logger); err != nil {
+		return false, err
+	}
+
+	if cfg.Stream {
+		if cfg.ParallelJobs > 1 {
+			setupLogger.Info("streaming mode requires a seekable archive for parallel restore, falling back to a temporary dump file")
+		} else {
+			return false, runStreaming(ctx, cfg, logger)
+		}
+	}
+
+	start := time.Now()
+	sizes := tableSizes(ctx, cfg, setupLogger)
+	dumpLogger := logger.With(logging.Phase("dump"))
+	restoreLogger := logger.With(logging.Phase("restore"))
+
+	var dumpFile string
+	if resuming && state.Phase != migrator.PhaseDumping {
+		dumpFile = state.DumpPath
+		dumpLogger.Info("resuming migration using kept dump", logging.StatePhase(state.Phase), logging.File(dumpFile))
+	} else {
+		if resuming {
+			dumpLogger.Info("previous dump did not complete, starting the dump over")
+			if err := os.RemoveAll(filepath.Dir(state.DumpPath)); err != nil {
+				dumpLogger.Warn("failed to remove incomplete dump", logging.Err(err))
+			}
+		}
+
+		// Without a state file the dump is scratch data; with one it is kept until the
+		// restore completes so an interrupted run can resume, in WORK_DIR or else next to
+		// the state file
+		parentDir := cfg.WorkDir
+		if state != nil && parentDir == "" {
+			parentDir = filepath.Dir(cfg.StateFile)
+		}
+
+		if err := checkDiskSpace(ctx, cfg, dumpLogger, parentDir); err != nil {
+			return false, err
+		}
+
+		workDir, err := os.MkdirTemp(parentDir, "postgres-migrator-*")
+		if err != nil {
+			return false, fmt.Errorf("failed to create temporary directory: %w", err)
+		}
+
+		// pg_dump creates the directory itself in directory format, so it must not exist yet
+		dumpFile = filepath.Join(workDir, "db.dump")
+		if cfg.DumpFormat == config.DumpFormatDirectory {
+			dumpFile = filepath.Join(workDir, "db.dir")
+			dumpLogger.Info("using directory format dump", logging.Jobs(cfg.ParallelJobs))
+		}
+
+		if state != nil {
+			state.DumpPath = dumpFile
+			state.RestoredEntries = nil
+			if err := state.SetPhase(migrator.PhaseDumping); err != nil {
+				return false, err
+			}
+		}
+
+		if err := dump(ctx, cfg, logger, dumpFile, sizes); err != nil {
+			if state == nil {
+				removeWorkDir(dumpLogger, dumpFile)
+			}
+			return false, err
+		}
+
+		if state != nil {
+			if err := state.SetPhase(migrator.PhaseDumped); err != nil {
+				return false, err
+			}
+		}
+	}
+
+	if state == nil {
+		defer removeWorkDir(restoreLogger, dumpFile)
+	}
+
+	if ctx.Err() != nil {
+		return false, fmt.Errorf("operation cancelled before restore")
+	}
+
+	restorer := migrator.NewRestorer(cfg, logger)
+	restorer.SetTableSizes(sizes)
+	enterPhase(ctx, metrics.PhaseRestore)
+	restoreStart := time.Now()
+
+	if state != nil {
+		if err := state.SetPhase(migrator.PhaseRestoring); err != nil {
+			return false, err
+		}
+		err = restorer.RestoreWithState(ctx, dumpFile, state)
+	} else {
+		err = restorer.Restore(ctx, dumpFile)
+	}
 	if err != nil {
-		return false, fmt.Errorf("failed to create temporary directory: %w", err)
+		return false, fmt.Errorf("restore failed: %w", err)
 	}
-	defer func() {
-		if err := os.RemoveAll(tmpDir); err != nil {
-			logger.Printf("Warning: failed to clean up temporary directory: %v\n", err)
+
+	observeRestore(ctx, time.Since(restoreStart))
+	restoreLogger.Info("restore completed", logging.Duration(time.Since(restoreStart)))
+
+	if cfg.DataOnly {
+		if err := syncSequences(ctx, cfg, restoreLogger); err != nil {
+			return false, err
 		}
-	}()
+	}
+
+	if state != nil {
+		if err := state.SetPhase(migrator.PhaseCompleted); err != nil {
+			return false, err
+		}
+		removeWorkDir(restoreLogger, dumpFile)
+	}
+
+	restoreLogger.Info("migration data transfer completed", logging.Duration(time.Since(start)), logging.Compression(cfg.CompressionSpec().String()))
+
+	return false, nil
+}
+
+// Migrate runs the migration followed by the post-migration validation
+func Migrate(ctx context.Context, cfg *config.Config, logger *slog.Logger) error {
+	_, err := run(ctx, cfg, logger, func(ctx context.Context, skippedMigration bool) error {
+		// Row counts keep changing while the source takes writes, so an online run is
+		// validated at cutover instead. A subset deliberately differs from the source.
+		if cfg.Online || cfg.SubsetFile != "" {
+			return nil
+		}
+
+		if skippedMigration || cfg.ValidateAfter {
+			if skippedMigration {
+				logger.Info("running validation on existing target database", logging.Phase("validate"))
+			} else {
+				logger.Info("running post-migration validation", logging.Phase("validate"))
+			}
+			if err := validate(ctx, cfg, logger); err != nil {
+				return fmt.Errorf("validation failed: %w", err)
+			}
+		}
+
+		return nil
+	})
+	return err
+}
+
+// Validate compares every migrated table of the source and the target
+func Validate(ctx context.Context, cfg *config.Config, logger *slog.Logger) (err error) {
+	defer func() { finishPhase(ctx, err) }()
+	return validate(ctx, cfg, logger)
+}
+
+func validate(ctx context.Context, cfg *config.Config, logger *slog.Logger) error {
+	filter, err := ValidationFilter(cfg)
+	if err != nil {
+		return err
+	}
+	return validation.ValidateAllTablesFromURLsWithFilter(ctx, cfg.SourceDatabaseURL, cfg.TargetDatabaseURL, filter, logger)
+}
 
-	dumpFile := filepath.Join(tmpDir, "db.dump")
+func engineName(cfg *config.Config) string {
+	if cfg.CopyEngine() {
+		return config.EngineCopy
+	}
+	return config.EnginePgDump
+}
 
+func dump(ctx context.Context, cfg *config.Config, logger *slog.Logger, dumpFile string, sizes map[string]int64) error {
 	dumper := migrator.NewDumper(cfg, logger)
+	dumper.SetTableSizes(sizes)
+	enterPhase(ctx, metrics.PhaseDump)
 	dumpStart := time.Now()
 
 	if err := dumper.Dump(ctx, dumpFile); err != nil {
-		return false, fmt.Errorf("dump failed: %w", err)
+		return fmt.Errorf("dump failed: %w", err)
 	}
 
 	dumpDuration := time.Since(dumpStart)
-	fileInfo, err := os.Stat(dumpFile)
+	attrs := []any{logging.Phase("dump"), logging.Duration(dumpDuration), logging.Compression(cfg.CompressionSpec().String())}
+	dumpSize, err := migrator.ArchiveSize(dumpFile)
 	if err == nil {
-		logger.Printf("Dump completed in %v (size: %d bytes)\n", dumpDuration, fileInfo.Size())
+		attrs = append(attrs, logging.Bytes(dumpSize))
 	} else {
-		logger.Printf("Dump completed in %v\n", dumpDuration)
+		dumpSize = -1
 	}
+	observeDump(ctx, dumpDuration, dumpSize)
+	logger.Info("dump completed", attrs...)
 
-	if ctx.Err() != nil {
-		return false, fmt.Errorf("operation cancelled before restore")
+	return nil
+}
+
+func removeWorkDir(logger *slog.Logger, dumpFile string) {
+	if err := os.RemoveAll(filepath.Dir(dumpFile)); err != nil {
+		logger.Warn("failed to clean up temporary directory", logging.Err(err))
 	}
+}
 
+func runStreaming(ctx context.Context, cfg *config.Config, logger *slog.Logger) error {
+	streamLogger := logger.With(logging.Phase("stream"))
+	streamLogger.Info("streaming pg_dump output directly into pg_restore")
+
+	streamCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	pr, pw := io.Pipe()
+	counter := &countingWriter{w: pw}
+
+	dumper := migrator.NewDumper(cfg, logger)
 	restorer := migrator.NewRestorer(cfg, logger)
-	restoreStart := time.Now()
+	start := time.Now()
 
-	if err := restorer.Restore(ctx, dumpFile); err != nil {
-		return false, fmt.Errorf("restore failed: %w", err)
+	sizes := tableSizes(ctx, cfg, streamLogger)
+	dumper.SetTableSizes(sizes)
+	restorer.SetTableSizes(sizes)
+
+	enterPhase(ctx, metrics.PhaseStream)
+
+	dumpErr := make(chan error, 1)
+	var (
+		dumpDuration time.Duration
+		dumpStopped  bool
+	)
+	go func() {
+		err := dumper.DumpTo(streamCtx, counter)
+		dumpDuration = time.Since(start)
+		// Checked before the pipe is closed, so a restore that failed because of this dump
+		// cannot have cancelled it yet
+		dumpStopped = streamCtx.Err() != nil || errors.Is(err, io.ErrClosedPipe)
+		pw.CloseWithError(err)
+		dumpErr <- err
+	}()
+
+	restoreErr := restorer.RestoreFrom(streamCtx, pr)
+	if restoreErr != nil {
+		cancel()
 	}
+	pr.Close()
 
-	restoreDuration := time.Since(restoreStart)
-	logger.Printf("Restore completed in %v\n", restoreDuration)
+	// A failed dump also breaks the restore, so the dump error is the root cause, unless
+	// the dump was only killed or cut off because the restore failed first
+	if err := <-dumpErr; err != nil && (restoreErr == nil || !dumpStopped) {
+		return fmt.Errorf("dump failed: %w", err)
+	}
+	if restoreErr != nil {
+		return fmt.Errorf("restore failed: %w", restoreErr)
+	}
+	observeDump(ctx, dumpDuration, counter.n)
+	observeRestore(ctx, time.Since(start))
 
-	totalDuration := time.Since(dumpStart)
-	logger.Printf("\nMigration completed successfully in %v\n", totalDuration)
+	if cfg.DataOnly {
+		if err := syncSequences(ctx, cfg, streamLogger); err != nil {
+			return err
+		}
+	}
 
-	return false, nil
+	streamLogger.Info("migration data transfer completed", logging.Duration(time.Since(start)), logging.Bytes(counter.n), logging.Compression(cfg.CompressionSpec().String()))
+
+	return nil
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
 }
@@ -0,0 +1,224 @@
+package migration
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/crisog/postgres-migrator/internal/config"
+	"github.com/crisog/postgres-migrator/internal/database"
+	"github.com/crisog/postgres-migrator/internal/logging"
+	"github.com/crisog/postgres-migrator/internal/metrics"
+	"github.com/crisog/postgres-migrator/internal/migrator"
+)
+
+const (
+	onlinePollInterval = 2 * time.Second
+	lagReportInterval  = 10 * time.Second
+)
+
+// runOnline copies the schema, then lets a logical replication subscription on the target
+// copy the data and stream changes until cutover is triggered. The subscription is left
+// running so the source can keep taking writes until the application is switched over.
+func runOnline(ctx context.Context, cfg *config.Config, logger *slog.Logger, targetTableCount int) error {
+	onlineLogger := logger.With(logging.Phase("online"))
+	start := time.Now()
+
+	subscribed, err := database.SubscriptionExists(ctx, cfg.TargetDatabaseURL, cfg.SubscriptionName)
+	if err != nil {
+		return fmt.Errorf("failed to check for subscription %s: %w", cfg.SubscriptionName, err)
+	}
+
+	if subscribed {
+		onlineLogger.Info("subscription already exists on the target, resuming online migration", "subscription", cfg.SubscriptionName)
+	} else {
+		if targetTableCount > 0 {
+			return fmt.Errorf("target database already has %d tables, online migration needs an empty target", targetTableCount)
+		}
+
+		if err := migrateSourceGlobals(ctx, cfg, logger); err != nil {
+			return err
+		}
+
+		if err := restoreSchema(ctx, cfg, logger); err != nil {
+			return err
+		}
+
+		if err := subscribe(ctx, cfg, onlineLogger); err != nil {
+			return err
+		}
+	}
+
+	enterPhase(ctx, metrics.PhaseReplication)
+	if err := waitForInitialSync(ctx, cfg, onlineLogger); err != nil {
+		return err
+	}
+	onlineLogger.Info("initial sync completed, target is now streaming changes from the source", logging.Duration(time.Since(start)))
+
+	return reportLagUntilCutover(ctx, cfg, onlineLogger)
+}
+
+func restoreSchema(ctx context.Context, cfg *config.Config, logger *slog.Logger) error {
+	dumpFile, err := dumpSchema(ctx, cfg, logger)
+	if err != nil {
+		return err
+	}
+	defer removeWorkDir(logger.With(logging.Phase("restore")), dumpFile)
+
+	return restoreSection(ctx, cfg, logger, dumpFile, "")
+}
+
+// dumpSchema writes a schema-only archive to a temporary directory, which the caller
+// removes with removeWorkDir
+func dumpSchema(ctx context.Context, cfg *config.Config, logger *slog.Logger) (string, error) {
+	dumpLogger := logger.With(logging.Phase("dump"))
+	dumpLogger.Info("copying schema to the target")
+
+	schemaCfg := *cfg
+	schemaCfg.SchemaOnly = true
+	schemaCfg.ArchiveURI = ""
+
+	workDir, err := os.MkdirTemp(cfg.WorkDir, "postgres-migrator-*")
+	if err != nil {
+		return "", fmt.Errorf("failed to create temporary directory: %w", err)
+	}
+
+	dumpFile := filepath.Join(workDir, "schema.dump")
+	if cfg.DumpFormat == config.DumpFormatDirectory {
+		dumpFile = filepath.Join(workDir, "schema.dir")
+	}
+
+	if err := dump(ctx, &schemaCfg, logger, dumpFile, nil); err != nil {
+		removeWorkDir(dumpLogger, dumpFile)
+		return "", err
+	}
+
+	return dumpFile, nil
+}
+
+// restoreSection restores one section of a schema archive, or all of it when section is empty
+func restoreSection(ctx context.Context, cfg *config.Config, logger *slog.Logger, dumpFile, section string) error {
+	schemaCfg := *cfg
+	schemaCfg.SchemaOnly = true
+	schemaCfg.ArchiveURI = ""
+	schemaCfg.Section = section
+
+	if err := migrator.NewRestorer(&schemaCfg, logger).Restore(ctx, dumpFile); err != nil {
+		return fmt.Errorf("schema restore failed: %w", err)
+	}
+
+	return nil
+}
+
+func subscribe(ctx context.Context, cfg *config.Config, logger *slog.Logger) error {
+	published, err := database.PublicationExists(ctx, cfg.SourceDatabaseURL, cfg.PublicationName)
+	if err != nil {
+		return fmt.Errorf("failed to check for publication %s: %w", cfg.PublicationName, err)
+	}
+
+	if published {
+		logger.Info("reusing existing publication on the source", "publication", cfg.PublicationName)
+	} else {
+		inventory, err := database.GetInventory(ctx, cfg.SourceDatabaseURL, cfg.ExcludeSchemas)
+		if err != nil {
+			return fmt.Errorf("failed to list source tables: %w", err)
+		}
+
+		tables := filterTables(cfg, inventory.Tables)
+		logger.Info("creating publication on the source", "publication", cfg.PublicationName, logging.Tables(len(tables)))
+		if err := database.CreatePublication(ctx, cfg.SourceDatabaseURL, cfg.PublicationName, tables); err != nil {
+			return fmt.Errorf("failed to create publication: %w", err)
+		}
+	}
+
+	// The target connects to the source itself, which may need a different address
+	// than the one this process uses (for example inside a private network)
+	sourceConnInfo := cfg.SubscriptionSourceURL
+	if sourceConnInfo == "" {
+		sourceConnInfo = cfg.SourceDatabaseURL
+	}
+
+	logger.Info("creating subscription on the target", "subscription", cfg.SubscriptionName, "source", migrator.RedactConnectionString(sourceConnInfo))
+	if err := database.CreateSubscription(ctx, cfg.TargetDatabaseURL, cfg.SubscriptionName, sourceConnInfo, cfg.PublicationName); err != nil {
+		return fmt.Errorf("failed to create subscription: %w", err)
+	}
+
+	return nil
+}
+
+func waitForInitialSync(ctx context.Context, cfg *config.Config, logger *slog.Logger) error {
+	logger.Info("waiting for the initial table sync")
+
+	ticker := time.NewTicker(onlinePollInterval)
+	defer ticker.Stop()
+
+	lastReady := -1
+	for {
+		ready, total, err := database.SubscriptionSyncState(ctx, cfg.TargetDatabaseURL, cfg.SubscriptionName)
+		if err != nil {
+			return err
+		}
+
+		if ready != lastReady {
+			logger.Info("initial sync progress", "ready", ready, logging.Tables(total))
+			lastReady = ready
+		}
+		if total > 0 && ready == total {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("operation cancelled during initial sync, rerun to resume from subscription %s", cfg.SubscriptionName)
+		case <-ticker.C:
+		}
+	}
+}
+
+func reportLagUntilCutover(ctx context.Context, cfg *config.Config, logger *slog.Logger) error {
+	if cfg.CutoverTriggerFile != "" {
+		logger.Info("reporting replication lag until the trigger file exists or the process is interrupted", "trigger_file", cfg.CutoverTriggerFile)
+	} else {
+		logger.Info("reporting replication lag until the process is interrupted")
+	}
+
+	ticker := time.NewTicker(onlinePollInterval)
+	defer ticker.Stop()
+
+	var lastReport time.Time
+	for {
+		if cfg.CutoverTriggerFile != "" {
+			if _, err := os.Stat(cfg.CutoverTriggerFile); err == nil {
+				logger.Info("cutover triggered", "trigger_file", cfg.CutoverTriggerFile)
+				break
+			}
+		}
+
+		if time.Since(lastReport) >= lagReportInterval {
+			lag, err := database.ReplicationLag(ctx, cfg.SourceDatabaseURL, cfg.SubscriptionName)
+			if err != nil {
+				logger.Warn("failed to read replication lag", logging.Err(err))
+			} else {
+				logger.Info("replication lag", "lag_bytes", lag)
+			}
+			lastReport = time.Now()
+		}
+
+		select {
+		case <-ctx.Done():
+			logger.Info("cutover triggered by interrupt")
+			return logCutoverReady(cfg, logger)
+		case <-ticker.C:
+		}
+	}
+
+	return logCutoverReady(cfg, logger)
+}
+
+func logCutoverReady(cfg *config.Config, logger *slog.Logger) error {
+	logger.Info("online migration is ready for cutover, run `postgres-migrator cutover` to freeze the source, sync sequences and verify the target before switching applications", "subscription", cfg.SubscriptionName)
+	return nil
+}
@@ -0,0 +1,152 @@
+package migration
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/crisog/postgres-migrator/internal/config"
+	"github.com/crisog/postgres-migrator/internal/database"
+	"github.com/crisog/postgres-migrator/internal/logging"
+	"github.com/crisog/postgres-migrator/internal/migrator"
+)
+
+// Plan reports what Run would migrate without dumping or writing anything
+func Plan(ctx context.Context, cfg *config.Config, logger *slog.Logger) error {
+	logger = logger.With(logging.Phase("plan"))
+	logger.Info("dry run, nothing will be written")
+
+	targetTableCount, err := database.ValidateBothConnections(ctx, logger, cfg.SourceDatabaseURL, cfg.TargetDatabaseURL, cfg.SkipVersionCheck, cfg.Online, cfg.ExcludeSchemas, migratesRows(cfg))
+	if err != nil {
+		return fmt.Errorf("connection validation failed: %w", err)
+	}
+
+	inventoryCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	source, err := database.GetInventory(inventoryCtx, cfg.SourceDatabaseURL, cfg.ExcludeSchemas)
+	if err != nil {
+		return fmt.Errorf("failed to inspect source database: %w", err)
+	}
+
+	target, err := database.GetInventory(inventoryCtx, cfg.TargetDatabaseURL, nil)
+	if err != nil {
+		return fmt.Errorf("failed to inspect target database: %w", err)
+	}
+
+	sourceAttrs := []any{
+		"schemas", joinOrNone(source.Schemas),
+		"extensions", joinOrNone(source.Extensions),
+		"sequences", joinOrNone(source.Sequences),
+		"large_objects", source.LargeObjects,
+		logging.Tables(len(source.Tables)),
+		logging.Bytes(source.TotalBytes()),
+	}
+	if len(cfg.ExcludeSchemas) > 0 {
+		sourceAttrs = append(sourceAttrs, "excluded_schemas", strings.Join(cfg.ExcludeSchemas, ", "))
+	}
+	logger.Info("source objects to migrate", sourceAttrs...)
+
+	filter := TableFilter(cfg)
+	tablesWithACL := 0
+	for _, table := range source.Tables {
+		attrs := []any{logging.Table(table.Schema + "." + table.Name), "estimated_rows", table.EstimatedRows, logging.Bytes(table.TotalBytes)}
+		if !filter.Includes(table.Schema, table.Name) {
+			logger.Info("source table excluded", attrs...)
+			continue
+		}
+		if filter.DataExcluded(table.Schema, table.Name) {
+			attrs = append(attrs, "data_excluded", true)
+		}
+		if !cfg.NoOwner {
+			attrs = append(attrs, "owner", table.Owner)
+		}
+		logger.Info("source table", attrs...)
+		if table.HasACL {
+			tablesWithACL++
+		}
+	}
+
+	if cfg.NoOwner {
+		logger.Info("ownership skipped (NO_OWNER is enabled), objects will be owned by the target user")
+	} else {
+		logger.Info("ownership preserved, the owner roles of the tables must exist on the target")
+	}
+	if cfg.NoACL {
+		logger.Info("privileges skipped (NO_ACL is enabled)")
+	} else {
+		logger.Info("privileges preserved", "tables_with_grants", tablesWithACL)
+	}
+
+	logger.Info("target database", "schemas", joinOrNone(target.Schemas), logging.Tables(len(target.Tables)), logging.Bytes(target.TotalBytes()))
+	for _, table := range target.Tables {
+		logger.Info("target table", logging.Table(table.Schema+"."+table.Name), "estimated_rows", table.EstimatedRows, logging.Bytes(table.TotalBytes))
+	}
+
+	if targetTableCount > 0 && !cfg.DataOnly {
+		logger.Info("target already has tables in public, a migration run would skip the dump and only validate")
+		return nil
+	}
+
+	dumpFile := filepath.Join("<work-dir>", "db.dump")
+	if cfg.DumpFormat == config.DumpFormatDirectory {
+		dumpFile = filepath.Join("<work-dir>", "db.dir")
+	}
+	restoreInput := dumpFile
+	if cfg.Stream && cfg.ParallelJobs <= 1 {
+		dumpFile = ""
+		restoreInput = ""
+	}
+
+	commandCfg := *cfg
+	commandCfg.SchemaOnly = cfg.Online || cfg.CopyEngine()
+
+	dumper := migrator.NewDumper(&commandCfg, logger)
+	restorer := migrator.NewRestorer(&commandCfg, logger)
+
+	if cfg.MaskingFile != "" {
+		rules, err := loadMasking(cfg)
+		if err != nil {
+			return err
+		}
+		logger.Info("masking", "masked_columns", strings.Join(rules.ColumnNames(), ", "))
+	}
+	if cfg.CopyEngine() {
+		logger.Info("copy engine: schema only, restored as pre-data, rows copied with COPY, then post-data", "connections", cfg.ParallelJobs)
+	}
+	if cfg.Online {
+		logger.Info("online mode: schema only, then a publication on the source and a subscription on the target copy the data", "publication", cfg.PublicationName, "subscription", cfg.SubscriptionName)
+	}
+	logger.Info("compression", logging.Compression(cfg.CompressionSpec().String()))
+	logger.Info("command", "tool", "pg_dump", "args", strings.Join(migrator.RedactArgs(dumper.Args(dumpFile)), " "))
+	restoreAttrs := []any{"tool", "pg_restore", "args", strings.Join(migrator.RedactArgs(restorer.Args(restoreInput)), " ")}
+	if restoreInput == "" {
+		restoreAttrs = append(restoreAttrs, "stdin", "streamed from pg_dump")
+	}
+	logger.Info("command", restoreAttrs...)
+
+	return nil
+}
+
+func joinOrNone(values []string) string {
+	if len(values) == 0 {
+		return "none"
+	}
+	return strings.Join(values, ", ")
+}
+
+func formatBytes(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
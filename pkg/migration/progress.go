@@ -0,0 +1,31 @@
+package migration
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/crisog/postgres-migrator/internal/config"
+	"github.com/crisog/postgres-migrator/internal/database"
+	"github.com/crisog/postgres-migrator/internal/logging"
+)
+
+// tableSizes returns the size of every migrated source table, keyed by schema.table, for
+// the percent complete of progress events. Progress is still reported without sizes,
+// so a failure only logs a warning.
+func tableSizes(ctx context.Context, cfg *config.Config, logger *slog.Logger) map[string]int64 {
+	if cfg.SourceDatabaseURL == "" {
+		return nil
+	}
+
+	inventory, err := database.GetInventory(ctx, cfg.SourceDatabaseURL, cfg.ExcludeSchemas)
+	if err != nil {
+		logger.Warn("failed to read source table sizes, progress will not show a percentage", logging.Err(err))
+		return nil
+	}
+
+	sizes := make(map[string]int64)
+	for _, table := range filterTables(cfg, inventory.Tables) {
+		sizes[table.Schema+"."+table.Name] = table.TotalBytes
+	}
+	return sizes
+}
@@ -0,0 +1,31 @@
+package migration
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/crisog/postgres-migrator/internal/config"
+	"github.com/crisog/postgres-migrator/internal/database"
+)
+
+// syncSequences copies every source sequence's state to the target. A data-only restore
+// and logical replication both copy rows without advancing the target's sequences.
+func syncSequences(ctx context.Context, cfg *config.Config, logger *slog.Logger) error {
+	states, err := database.GetSequenceStates(ctx, cfg.SourceDatabaseURL, cfg.ExcludeSchemas)
+	if err != nil {
+		return fmt.Errorf("failed to read source sequences: %w", err)
+	}
+
+	unmapped, err := database.ApplySequenceStates(ctx, cfg.TargetDatabaseURL, states)
+	if err != nil {
+		return fmt.Errorf("sequence sync failed: %w", err)
+	}
+
+	for _, name := range unmapped {
+		logger.Warn("sequence has no counterpart on the target, its value was not copied", "sequence", name)
+	}
+	logger.Info("sequences synchronized", "synchronized", len(states)-len(unmapped), "sequences", len(states))
+
+	return nil
+}
@@ -0,0 +1,72 @@
+package migration
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/crisog/postgres-migrator/internal/config"
+	"github.com/crisog/postgres-migrator/internal/copier"
+	"github.com/crisog/postgres-migrator/internal/logging"
+	"github.com/crisog/postgres-migrator/internal/metrics"
+	"github.com/crisog/postgres-migrator/internal/subset"
+)
+
+// runSubset restores the full schema, then copies only the rows selected by the subset
+// file plus the rows they are related to through foreign keys. Masking rules apply to
+// the copied rows as well.
+func runSubset(ctx context.Context, cfg *config.Config, logger *slog.Logger, targetTableCount int) error {
+	plan, err := subset.LoadPlan(cfg.SubsetFile)
+	if err != nil {
+		return err
+	}
+
+	rules, err := loadMasking(cfg)
+	if err != nil {
+		return err
+	}
+
+	if targetTableCount > 0 {
+		return fmt.Errorf("target database already has %d tables, subsetting needs an empty target", targetTableCount)
+	}
+
+	if err := migrateSourceGlobals(ctx, cfg, logger); err != nil {
+		return err
+	}
+
+	tables, err := copiedTables(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	if err := copier.CheckMasking(ctx, cfg.SourceDatabaseURL, rules, tables); err != nil {
+		return err
+	}
+
+	start := time.Now()
+
+	if err := restoreSchema(ctx, cfg, logger); err != nil {
+		return err
+	}
+
+	enterPhase(ctx, metrics.PhaseSubset)
+	subsetLogger := logger.With(logging.Phase("subset"))
+	subsetLogger.Info("selecting subset", "roots", len(plan.Roots), logging.Tables(len(tables)))
+	copied, err := subset.NewCopier(cfg.SourceDatabaseURL, cfg.TargetDatabaseURL, rules, subsetLogger).Copy(ctx, plan, tables)
+	if err != nil {
+		return fmt.Errorf("subset copy failed: %w", err)
+	}
+
+	if err := syncSequences(ctx, cfg, subsetLogger); err != nil {
+		return err
+	}
+
+	var total int64
+	for _, n := range copied {
+		total += n
+		metrics.FromContext(ctx).TableRestored()
+	}
+	subsetLogger.Info("migration data transfer completed", logging.Duration(time.Since(start)), logging.Rows(total), logging.Tables(len(copied)))
+
+	return nil
+}
@@ -0,0 +1,105 @@
+package validation
+
+import (
+	"regexp"
+	"strings"
+)
+
+// TableFilter mirrors pg_dump's --table, --exclude-table and --exclude-table-data
+// options so validation only checks what was actually migrated. Patterns use pg_dump
+// syntax: an optional schema part, * and ? wildcards, and double quotes to match
+// case-sensitively. MaskedColumns lists schema.table.column names whose values were
+// rewritten during the copy, so their contents are not compared.
+type TableFilter struct {
+	Include       []string
+	Exclude       []string
+	ExcludeData   []string
+	MaskedColumns []string
+}
+
+// Includes reports whether the table is migrated at all
+func (f TableFilter) Includes(schema, table string) bool {
+	if len(f.Include) > 0 && !matchesAny(f.Include, schema, table) {
+		return false
+	}
+	return !matchesAny(f.Exclude, schema, table)
+}
+
+// DataExcluded reports whether only the table's definition is migrated
+func (f TableFilter) DataExcluded(schema, table string) bool {
+	return matchesAny(f.ExcludeData, schema, table)
+}
+
+// ColumnMasked reports whether the column's values were masked on the target
+func (f TableFilter) ColumnMasked(schema, table, column string) bool {
+	name := schema + "." + table + "." + column
+	for _, masked := range f.MaskedColumns {
+		if masked == name {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesAny(patterns []string, schema, table string) bool {
+	for _, pattern := range patterns {
+		if MatchTablePattern(pattern, schema, table) {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchTablePattern reports whether schema.table matches a pg_dump table pattern.
+// A pattern without a schema part matches the table in any schema.
+func MatchTablePattern(pattern, schema, table string) bool {
+	parts := splitPattern(pattern)
+	switch len(parts) {
+	case 1:
+		return parts[0].MatchString(table)
+	case 2:
+		return parts[0].MatchString(schema) && parts[1].MatchString(table)
+	default:
+		return false
+	}
+}
+
+// splitPattern turns a pattern into one regular expression per dot-separated part,
+// folding unquoted text to lower case like PostgreSQL does for identifiers
+func splitPattern(pattern string) []*regexp.Regexp {
+	var parts []*regexp.Regexp
+	var current strings.Builder
+	inQuotes := false
+
+	flush := func() {
+		parts = append(parts, regexp.MustCompile("^(?:"+current.String()+")$"))
+		current.Reset()
+	}
+
+	runes := []rune(pattern)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case r == '"':
+			if inQuotes && i+1 < len(runes) && runes[i+1] == '"' {
+				current.WriteString(regexp.QuoteMeta(`"`))
+				i++
+			} else {
+				inQuotes = !inQuotes
+			}
+		case inQuotes:
+			current.WriteString(regexp.QuoteMeta(string(r)))
+		case r == '.':
+			flush()
+		case r == '*':
+			current.WriteString(".*")
+		case r == '?':
+			current.WriteString(".")
+		default:
+			current.WriteString(regexp.QuoteMeta(strings.ToLower(string(r))))
+		}
+	}
+	flush()
+
+	return parts
+}
@@ -3,10 +3,15 @@ package validation
 import (
 	"context"
 	"fmt"
-	"log"
+	"log/slog"
 	"reflect"
 
+	"github.com/crisog/postgres-migrator/internal/logging"
+	"github.com/crisog/postgres-migrator/internal/metrics"
+	"github.com/crisog/postgres-migrator/internal/tracing"
+	"github.com/crisog/postgres-migrator/internal/webhook"
 	"github.com/jackc/pgx/v5"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 func quoteIdentifier(name string) string {
@@ -72,10 +77,10 @@ func ValidateRowCount(ctx context.Context, sourceConn, targetConn *pgx.Conn, tab
 }
 
 func ValidatePrimaryKey(ctx context.Context, sourceConn, targetConn *pgx.Conn, tableName string) error {
-	return validatePrimaryKey(ctx, sourceConn, targetConn, tableName)
+	return validatePrimaryKey(ctx, sourceConn, targetConn, tableName, nil)
 }
 
-func ValidateTableMigrationFromURLs(ctx context.Context, sourceURL, targetURL, tableName string, validateChecksum bool, logger *log.Logger) error {
+func ValidateTableMigrationFromURLs(ctx context.Context, sourceURL, targetURL, tableName string, validateChecksum bool, logger *slog.Logger) error {
 	sourceConn, err := pgx.Connect(ctx, sourceURL)
 	if err != nil {
 		return fmt.Errorf("failed to connect to source database: %w", err)
@@ -91,7 +96,20 @@ func ValidateTableMigrationFromURLs(ctx context.Context, sourceURL, targetURL, t
 	return ValidateTableMigration(ctx, sourceConn, targetConn, tableName, validateChecksum, logger)
 }
 
-func ValidateAllTablesFromURLs(ctx context.Context, sourceURL, targetURL string, logger *log.Logger) error {
+// ValidateAllTablesFromURLs validates every public table
+func ValidateAllTablesFromURLs(ctx context.Context, sourceURL, targetURL string, logger *slog.Logger) error {
+	return ValidateAllTablesFromURLsWithFilter(ctx, sourceURL, targetURL, TableFilter{}, logger)
+}
+
+// ValidateAllTablesFromURLsWithFilter validates every public table the filter includes.
+// Tables whose data was excluded only have their schema checked.
+func ValidateAllTablesFromURLsWithFilter(ctx context.Context, sourceURL, targetURL string, filter TableFilter, logger *slog.Logger) (err error) {
+	ctx, span := tracing.Start(ctx, "validation.ValidateAllTablesFromURLs")
+	defer func() { tracing.End(span, err) }()
+
+	var summary webhook.ValidationSummary
+	defer notifyResult(ctx, &summary, &err)
+
 	sourceConn, err := pgx.Connect(ctx, sourceURL)
 	if err != nil {
 		return fmt.Errorf("failed to connect to source database: %w", err)
@@ -104,96 +122,201 @@ func ValidateAllTablesFromURLs(ctx context.Context, sourceURL, targetURL string,
 	}
 	defer targetConn.Close(ctx)
 
-	query := `
-		SELECT tablename
-		FROM pg_tables
-		WHERE schemaname = 'public'
-		ORDER BY tablename`
-
-	sourceRows, err := sourceConn.Query(ctx, query)
+	sourceTables, err := publicTables(ctx, sourceConn, filter)
 	if err != nil {
 		return fmt.Errorf("failed to query source tables: %w", err)
 	}
-	defer sourceRows.Close()
 
-	var sourceTables []string
-	for sourceRows.Next() {
-		var tableName string
-		if err := sourceRows.Scan(&tableName); err != nil {
-			return fmt.Errorf("failed to scan table name: %w", err)
-		}
-		sourceTables = append(sourceTables, tableName)
-	}
-	if err := sourceRows.Err(); err != nil {
-		return fmt.Errorf("error iterating source tables: %w", err)
-	}
-
-	targetRows, err := targetConn.Query(ctx, query)
+	targetTableList, err := publicTables(ctx, targetConn, TableFilter{})
 	if err != nil {
 		return fmt.Errorf("failed to query target tables: %w", err)
 	}
-	defer targetRows.Close()
 
 	targetTables := make(map[string]bool)
-	for targetRows.Next() {
-		var tableName string
-		if err := targetRows.Scan(&tableName); err != nil {
-			return fmt.Errorf("failed to scan table name: %w", err)
-		}
+	for _, tableName := range targetTableList {
 		targetTables[tableName] = true
 	}
-	if err := targetRows.Err(); err != nil {
-		return fmt.Errorf("error iterating target tables: %w", err)
-	}
+
+	logger = logger.With(logging.Phase("validate"))
+	metrics.FromContext(ctx).SetPhase(metrics.PhaseValidate)
+	webhook.FromContext(ctx).SetPhase(metrics.PhaseValidate)
+	span.SetAttributes(attribute.Int("tables", len(sourceTables)))
+	summary.Tables = len(sourceTables)
 
 	if len(sourceTables) == 0 {
-		logger.Println("No tables found in source database")
+		logger.Info("no tables found in source database")
 		return nil
 	}
 
-	logger.Printf("Found %d tables in source database to validate\n", len(sourceTables))
+	logger.Info("validating tables", logging.Tables(len(sourceTables)))
 
 	for _, tableName := range sourceTables {
-		logger.Printf("\n=== Validating table: %s ===", tableName)
+		tableLogger := logger.With(logging.Table(tableName))
+		summary.FailedTable = tableName
 
 		if !targetTables[tableName] {
-			return fmt.Errorf("validation failed for table %s: table missing from target database", tableName)
+			err := fmt.Errorf("validation failed for table %s: table missing from target database", tableName)
+			metrics.FromContext(ctx).ValidationCheck(err)
+			return err
+		}
+
+		if filter.DataExcluded("public", tableName) {
+			if err := validateSchemaOnly(ctx, sourceConn, targetConn, tableName, tableLogger); err != nil {
+				return fmt.Errorf("validation failed for table %s: %w", tableName, err)
+			}
+			summary.TablesValidated++
+			continue
 		}
 
-		if err := ValidateTableMigration(ctx, sourceConn, targetConn, tableName, false, logger); err != nil {
+		masked := func(column string) bool {
+			return filter.ColumnMasked("public", tableName, column)
+		}
+		if err := validateTableMigration(ctx, sourceConn, targetConn, tableName, masked, tableLogger); err != nil {
 			return fmt.Errorf("validation failed for table %s: %w", tableName, err)
 		}
+		summary.TablesValidated++
 	}
+	summary.FailedTable = ""
 
-	logger.Printf("\n✓ All %d tables validated successfully", len(sourceTables))
+	logger.Info("all tables validated", logging.Tables(len(sourceTables)))
 	return nil
 }
 
-func ValidateTableMigration(ctx context.Context, sourceConn, targetConn *pgx.Conn, tableName string, validateChecksum bool, logger *log.Logger) error {
-	logger.Println("Validating schema columns...")
-	if err := validateSchemaColumns(ctx, sourceConn, targetConn, tableName); err != nil {
+// ValidateRowCountsFromURLs only compares row counts, which is cheap enough to gate a cutover
+func ValidateRowCountsFromURLs(ctx context.Context, sourceURL, targetURL string, filter TableFilter, logger *slog.Logger) (err error) {
+	ctx, span := tracing.Start(ctx, "validation.ValidateRowCountsFromURLs")
+	defer func() { tracing.End(span, err) }()
+
+	var summary webhook.ValidationSummary
+	defer notifyResult(ctx, &summary, &err)
+
+	sourceConn, err := pgx.Connect(ctx, sourceURL)
+	if err != nil {
+		return fmt.Errorf("failed to connect to source database: %w", err)
+	}
+	defer sourceConn.Close(ctx)
+
+	targetConn, err := pgx.Connect(ctx, targetURL)
+	if err != nil {
+		return fmt.Errorf("failed to connect to target database: %w", err)
+	}
+	defer targetConn.Close(ctx)
+
+	sourceTables, err := publicTables(ctx, sourceConn, filter)
+	if err != nil {
+		return fmt.Errorf("failed to query source tables: %w", err)
+	}
+
+	logger = logger.With(logging.Phase("validate"))
+	summary.Tables = len(sourceTables)
+
+	for _, tableName := range sourceTables {
+		if filter.DataExcluded("public", tableName) {
+			logger.Info("table data excluded, row count skipped", logging.Table(tableName))
+			summary.TablesValidated++
+			continue
+		}
+
+		count, err := validateRowCount(ctx, sourceConn, targetConn, tableName)
+		if err := check(ctx, err); err != nil {
+			summary.FailedTable = tableName
+			return fmt.Errorf("validation failed for table %s: %w", tableName, err)
+		}
+		logger.Info("row count matches", logging.Table(tableName), logging.Rows(int64(count)))
+		summary.TablesValidated++
+	}
+
+	logger.Info("row counts match", logging.Tables(len(sourceTables)))
+	return nil
+}
+
+func publicTables(ctx context.Context, conn *pgx.Conn, filter TableFilter) ([]string, error) {
+	rows, err := conn.Query(ctx, `
+		SELECT tablename
+		FROM pg_tables
+		WHERE schemaname = 'public'
+		ORDER BY tablename`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tables []string
+	for rows.Next() {
+		var tableName string
+		if err := rows.Scan(&tableName); err != nil {
+			return nil, fmt.Errorf("failed to scan table name: %w", err)
+		}
+		if filter.Includes("public", tableName) {
+			tables = append(tables, tableName)
+		}
+	}
+	return tables, rows.Err()
+}
+
+func validateSchemaOnly(ctx context.Context, sourceConn, targetConn *pgx.Conn, tableName string, logger *slog.Logger) (err error) {
+	ctx, span := tracing.Start(ctx, "validation.ValidateTableMigration", tracing.Table(tableName), attribute.Bool("data_excluded", true))
+	defer func() { tracing.End(span, err) }()
+
+	logger.Debug("table data was excluded, validating schema only")
+	if err := check(ctx, validateSchemaColumns(ctx, sourceConn, targetConn, tableName)); err != nil {
+		return fmt.Errorf("schema columns validation failed: %w", err)
+	}
+	if err := check(ctx, validateSchemaConstraints(ctx, sourceConn, targetConn, tableName)); err != nil {
+		return fmt.Errorf("schema constraints validation failed: %w", err)
+	}
+	logger.Info("table schema validated")
+	return nil
+}
+
+// notifyResult sends validation.failed to the run's webhooks when *err is set, and
+// otherwise keeps the summary for the run's migration.completed
+func notifyResult(ctx context.Context, summary *webhook.ValidationSummary, err *error) {
+	if *err != nil {
+		webhook.FromContext(ctx).ValidationFailed(*summary, *err)
+		return
+	}
+	webhook.FromContext(ctx).Validated(*summary)
+}
+
+// check counts the outcome of one validation check in the metrics and returns err
+func check(ctx context.Context, err error) error {
+	metrics.FromContext(ctx).ValidationCheck(err)
+	return err
+}
+
+func ValidateTableMigration(ctx context.Context, sourceConn, targetConn *pgx.Conn, tableName string, validateChecksum bool, logger *slog.Logger) error {
+	return validateTableMigration(ctx, sourceConn, targetConn, tableName, nil, logger)
+}
+
+// validateTableMigration skips content comparisons on columns for which masked returns
+// true, since their values were rewritten on the way to the target
+func validateTableMigration(ctx context.Context, sourceConn, targetConn *pgx.Conn, tableName string, masked func(column string) bool, logger *slog.Logger) (err error) {
+	ctx, span := tracing.Start(ctx, "validation.ValidateTableMigration", tracing.Table(tableName))
+	defer func() { tracing.End(span, err) }()
+
+	logger.Debug("validating schema columns")
+	if err := check(ctx, validateSchemaColumns(ctx, sourceConn, targetConn, tableName)); err != nil {
 		return fmt.Errorf("schema columns validation failed: %w", err)
 	}
-	logger.Println("✓ Schema columns match")
 
-	logger.Println("Validating schema constraints...")
-	if err := validateSchemaConstraints(ctx, sourceConn, targetConn, tableName); err != nil {
+	logger.Debug("validating schema constraints")
+	if err := check(ctx, validateSchemaConstraints(ctx, sourceConn, targetConn, tableName)); err != nil {
 		return fmt.Errorf("schema constraints validation failed: %w", err)
 	}
-	logger.Println("✓ Schema constraints match")
 
-	logger.Println("Validating row count...")
+	logger.Debug("validating row count")
 	sourceCount, err := validateRowCount(ctx, sourceConn, targetConn, tableName)
-	if err != nil {
+	if err := check(ctx, err); err != nil {
 		return fmt.Errorf("row count validation failed: %w", err)
 	}
-	logger.Printf("✓ Row count matches: %d records", sourceCount)
 
-	logger.Println("Validating primary key...")
-	if err := validatePrimaryKey(ctx, sourceConn, targetConn, tableName); err != nil {
+	logger.Debug("validating primary key")
+	if err := check(ctx, validatePrimaryKey(ctx, sourceConn, targetConn, tableName, masked)); err != nil {
 		return fmt.Errorf("primary key validation failed: %w", err)
 	}
-	logger.Println("✓ Primary key matches")
+	span.SetAttributes(tracing.Rows(int64(sourceCount)))
+	logger.Info("table validated", logging.Rows(int64(sourceCount)))
 
 	return nil
 }
@@ -336,7 +459,7 @@ func validateRowCount(ctx context.Context, sourceConn, targetConn *pgx.Conn, tab
 	return sourceCount, nil
 }
 
-func validatePrimaryKey(ctx context.Context, sourceConn, targetConn *pgx.Conn, tableName string) error {
+func validatePrimaryKey(ctx context.Context, sourceConn, targetConn *pgx.Conn, tableName string, masked func(column string) bool) error {
 	pkCols, err := getPrimaryKeyColumns(ctx, sourceConn, tableName)
 	if err != nil || len(pkCols) == 0 {
 		return nil
@@ -346,6 +469,9 @@ func validatePrimaryKey(ctx context.Context, sourceConn, targetConn *pgx.Conn, t
 	var sourceCount, targetCount int
 
 	for _, pkCol := range pkCols {
+		if masked != nil && masked(pkCol) {
+			continue
+		}
 		quotedCol := quoteIdentifier(pkCol)
 		query := fmt.Sprintf("SELECT COUNT(DISTINCT %s) FROM %s", quotedCol, quoted)
 
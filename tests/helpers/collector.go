@@ -0,0 +1,93 @@
+package helpers
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	collectortrace "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+// TraceCollector stands in for an OTLP/HTTP collector and keeps every span it receives
+type TraceCollector struct {
+	URL string
+
+	mu    sync.Mutex
+	spans []*tracepb.Span
+}
+
+func NewTraceCollector(t *testing.T) *TraceCollector {
+	collector := &TraceCollector{}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/traces" {
+			http.NotFound(w, r)
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var request collectortrace.ExportTraceServiceRequest
+		if err := proto.Unmarshal(body, &request); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		collector.mu.Lock()
+		for _, resourceSpans := range request.ResourceSpans {
+			for _, scopeSpans := range resourceSpans.ScopeSpans {
+				collector.spans = append(collector.spans, scopeSpans.Spans...)
+			}
+		}
+		collector.mu.Unlock()
+
+		response, _ := proto.Marshal(&collectortrace.ExportTraceServiceResponse{})
+		w.Header().Set("Content-Type", "application/x-protobuf")
+		w.Write(response)
+	}))
+	t.Cleanup(server.Close)
+
+	collector.URL = server.URL
+	return collector
+}
+
+// Spans returns the received spans with the given name
+func (c *TraceCollector) Spans(name string) []*tracepb.Span {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var spans []*tracepb.Span
+	for _, span := range c.spans {
+		if span.Name == name {
+			spans = append(spans, span)
+		}
+	}
+	return spans
+}
+
+// SpanAttribute returns the value of a string, int or bool span attribute, or nil when
+// the span does not have it
+func SpanAttribute(span *tracepb.Span, key string) any {
+	for _, attr := range span.Attributes {
+		if attr.Key != key {
+			continue
+		}
+		switch value := attr.Value.Value.(type) {
+		case *commonpb.AnyValue_StringValue:
+			return value.StringValue
+		case *commonpb.AnyValue_IntValue:
+			return value.IntValue
+		case *commonpb.AnyValue_BoolValue:
+			return value.BoolValue
+		}
+	}
+	return nil
+}
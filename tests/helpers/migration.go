@@ -2,11 +2,10 @@ package helpers
 
 import (
 	"context"
-	"io"
-	"log"
 	"testing"
 
 	"github.com/crisog/postgres-migrator/internal/config"
+	"github.com/crisog/postgres-migrator/internal/logging"
 	"github.com/crisog/postgres-migrator/pkg/migration"
 	"github.com/stretchr/testify/require"
 )
@@ -22,7 +21,7 @@ func RunMigration(t *testing.T, ctx context.Context, sourceURL, targetURL string
 		NoACL:             noACL,
 	}
 
-	logger := log.New(io.Discard, "", 0)
+	logger := logging.Discard()
 	_, err := migration.Run(ctx, cfg, logger)
 	require.NoError(t, err)
 }
@@ -38,7 +37,7 @@ func RunMigrationExpectError(t *testing.T, ctx context.Context, sourceURL, targe
 		NoACL:             noACL,
 	}
 
-	logger := log.New(io.Discard, "", 0)
+	logger := logging.Discard()
 	_, err := migration.Run(ctx, cfg, logger)
 	require.Error(t, err)
 	require.Contains(t, err.Error(), expectedError)
@@ -55,7 +54,7 @@ func RunMigrationExpectSkip(t *testing.T, ctx context.Context, sourceURL, target
 		NoACL:             noACL,
 	}
 
-	logger := log.New(io.Discard, "", 0)
+	logger := logging.Discard()
 	skipped, err := migration.Run(ctx, cfg, logger)
 	require.NoError(t, err)
 	require.True(t, skipped, "Migration should have been skipped due to existing tables in target")
@@ -68,6 +67,25 @@ type MigrationOptions struct {
 	SkipVersionCheck bool
 	DataOnly         bool
 	ExcludeSchemas   []string
+	IncludeTables    []string
+	ExcludeTables    []string
+	ExcludeTableData []string
+	Stream           bool
+	DumpFormat       string
+	Engine           string
+	ChunkRows        int
+	ChunkRetries     int
+	StateFile        string
+	Resume           bool
+	Compression      string
+	WorkDir          string
+
+	Online                bool
+	SubscriptionSourceURL string
+	CutoverTriggerFile    string
+
+	SubsetFile  string
+	MaskingFile string
 }
 
 func RunMigrationWithOptions(t *testing.T, ctx context.Context, sourceURL, targetURL string, opts MigrationOptions) {
@@ -86,13 +104,79 @@ func RunMigrationWithOptions(t *testing.T, ctx context.Context, sourceURL, targe
 		SkipVersionCheck:  opts.SkipVersionCheck,
 		DataOnly:          opts.DataOnly,
 		ExcludeSchemas:    opts.ExcludeSchemas,
+		IncludeTables:     opts.IncludeTables,
+		ExcludeTables:     opts.ExcludeTables,
+		ExcludeTableData:  opts.ExcludeTableData,
+		Stream:            opts.Stream,
+		DumpFormat:        opts.DumpFormat,
+		Engine:            opts.Engine,
+		ChunkRows:         opts.ChunkRows,
+		ChunkRetries:      opts.ChunkRetries,
+		StateFile:         opts.StateFile,
+		Resume:            opts.Resume,
+		Compression:       opts.Compression,
+		WorkDir:           opts.WorkDir,
+
+		Online:                opts.Online,
+		PublicationName:       "postgres_migrator",
+		SubscriptionName:      "postgres_migrator",
+		SubscriptionSourceURL: opts.SubscriptionSourceURL,
+		CutoverTriggerFile:    opts.CutoverTriggerFile,
+
+		SubsetFile:  opts.SubsetFile,
+		MaskingFile: opts.MaskingFile,
 	}
 
-	logger := log.New(io.Discard, "", 0)
+	logger := logging.Discard()
 	_, err := migration.Run(ctx, cfg, logger)
 	require.NoError(t, err)
 }
 
+func RunMigrationWithOptionsExpectError(t *testing.T, ctx context.Context, sourceURL, targetURL string, opts MigrationOptions, expectedError string) {
+	t.Helper()
+
+	if opts.ParallelJobs == 0 {
+		opts.ParallelJobs = 1
+	}
+
+	cfg := &config.Config{
+		SourceDatabaseURL: sourceURL,
+		TargetDatabaseURL: targetURL,
+		ParallelJobs:      opts.ParallelJobs,
+		NoOwner:           opts.NoOwner,
+		NoACL:             opts.NoACL,
+		SkipVersionCheck:  opts.SkipVersionCheck,
+		DataOnly:          opts.DataOnly,
+		ExcludeSchemas:    opts.ExcludeSchemas,
+		IncludeTables:     opts.IncludeTables,
+		ExcludeTables:     opts.ExcludeTables,
+		ExcludeTableData:  opts.ExcludeTableData,
+		Stream:            opts.Stream,
+		DumpFormat:        opts.DumpFormat,
+		Engine:            opts.Engine,
+		ChunkRows:         opts.ChunkRows,
+		ChunkRetries:      opts.ChunkRetries,
+		StateFile:         opts.StateFile,
+		Resume:            opts.Resume,
+		Compression:       opts.Compression,
+		WorkDir:           opts.WorkDir,
+
+		Online:                opts.Online,
+		PublicationName:       "postgres_migrator",
+		SubscriptionName:      "postgres_migrator",
+		SubscriptionSourceURL: opts.SubscriptionSourceURL,
+		CutoverTriggerFile:    opts.CutoverTriggerFile,
+
+		SubsetFile:  opts.SubsetFile,
+		MaskingFile: opts.MaskingFile,
+	}
+
+	logger := logging.Discard()
+	_, err := migration.Run(ctx, cfg, logger)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), expectedError)
+}
+
 func RunMigrationWithExcludeSchemas(t *testing.T, ctx context.Context, sourceURL, targetURL string, excludeSchemas []string) {
 	t.Helper()
 
@@ -105,7 +189,7 @@ func RunMigrationWithExcludeSchemas(t *testing.T, ctx context.Context, sourceURL
 		ExcludeSchemas:    excludeSchemas,
 	}
 
-	logger := log.New(io.Discard, "", 0)
+	logger := logging.Discard()
 	_, err := migration.Run(ctx, cfg, logger)
 	require.NoError(t, err)
 }
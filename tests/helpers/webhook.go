@@ -0,0 +1,79 @@
+package helpers
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/crisog/postgres-migrator/internal/webhook"
+)
+
+// WebhookReceiver records the webhook payloads whose signature matches the secret. The
+// first request is answered with 503 so that every test run goes through a retry.
+type WebhookReceiver struct {
+	URL string
+
+	secret   []byte
+	mu       sync.Mutex
+	requests int
+	payloads []webhook.Payload
+	invalid  int
+}
+
+func NewWebhookReceiver(t *testing.T, secret string) *WebhookReceiver {
+	receiver := &WebhookReceiver{secret: []byte(secret)}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		receiver.mu.Lock()
+		defer receiver.mu.Unlock()
+
+		receiver.requests++
+		if receiver.requests == 1 {
+			http.Error(w, "try again", http.StatusServiceUnavailable)
+			return
+		}
+		if r.Header.Get(webhook.SignatureHeader) != webhook.Sign(receiver.secret, body) {
+			receiver.invalid++
+			http.Error(w, "bad signature", http.StatusUnauthorized)
+			return
+		}
+
+		var payload webhook.Payload
+		if err := json.Unmarshal(body, &payload); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if r.Header.Get(webhook.EventHeader) != payload.Event {
+			http.Error(w, "event header does not match payload", http.StatusBadRequest)
+			return
+		}
+		receiver.payloads = append(receiver.payloads, payload)
+	}))
+	t.Cleanup(server.Close)
+
+	receiver.URL = server.URL
+	return receiver
+}
+
+// Payloads returns the accepted payloads in the order they arrived
+func (r *WebhookReceiver) Payloads() []webhook.Payload {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]webhook.Payload(nil), r.payloads...)
+}
+
+// InvalidSignatures returns how many requests were rejected for a wrong signature
+func (r *WebhookReceiver) InvalidSignatures() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.invalid
+}
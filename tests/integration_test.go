@@ -1,15 +1,38 @@
 package tests
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/json"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
 	"testing"
-
+	"time"
+
+	"github.com/crisog/postgres-migrator/internal/config"
+	"github.com/crisog/postgres-migrator/internal/database"
+	"github.com/crisog/postgres-migrator/internal/logging"
+	"github.com/crisog/postgres-migrator/internal/metrics"
+	"github.com/crisog/postgres-migrator/internal/migrator"
+	"github.com/crisog/postgres-migrator/internal/server"
+	"github.com/crisog/postgres-migrator/internal/tracing"
+	"github.com/crisog/postgres-migrator/internal/webhook"
+	"github.com/crisog/postgres-migrator/pkg/migration"
 	"github.com/crisog/postgres-migrator/pkg/validation"
 	"github.com/crisog/postgres-migrator/tests/helpers"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
 	"github.com/stretchr/testify/require"
 	"github.com/testcontainers/testcontainers-go"
 	"github.com/testcontainers/testcontainers-go/modules/postgres"
@@ -250,6 +273,126 @@ func TestDataOnly(t *testing.T) {
 	err = targetConn.QueryRow(ctx, "SELECT name FROM users WHERE id = 1").Scan(&name)
 	require.NoError(t, err)
 	require.Equal(t, "Alice", name)
+
+	sourceConn, err := pgx.Connect(ctx, sourceConnStr)
+	require.NoError(t, err)
+	defer sourceConn.Close(ctx)
+
+	for _, sequence := range []string{"users_id_seq", "posts_id_seq"} {
+		var sourceLastValue, targetLastValue int64
+		require.NoError(t, sourceConn.QueryRow(ctx, "SELECT last_value FROM "+sequence).Scan(&sourceLastValue))
+		require.NoError(t, targetConn.QueryRow(ctx, "SELECT last_value FROM "+sequence).Scan(&targetLastValue))
+		require.Equal(t, sourceLastValue, targetLastValue, "Sequence %s should match the source after a data-only restore", sequence)
+	}
+
+	_, err = targetConn.Exec(ctx, "INSERT INTO users (name, email) VALUES ('Next User', 'next@example.com')")
+	require.NoError(t, err, "Inserts on the target should not collide with restored ids")
+}
+
+// TestSequenceSync copies rows with the copy engine, which writes no SEQUENCE SET
+// entries, so the target's sequences only move through the sequence sync
+func TestSequenceSync(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	sourceContainer, err := postgres.Run(
+		ctx,
+		getPostgresImage(getDefaultPostgresVersion()),
+		postgres.WithDatabase("sourcedb"),
+		postgres.WithUsername("user"),
+		postgres.WithPassword("password"),
+		testcontainers.WithWaitStrategy(
+			wait.ForLog("database system is ready to accept connections").WithOccurrence(2),
+			wait.ForListeningPort("5432/tcp"),
+		),
+	)
+	testcontainers.CleanupContainer(t, sourceContainer)
+	require.NoError(t, err)
+
+	targetContainer, err := postgres.Run(
+		ctx,
+		getPostgresImage(getDefaultPostgresVersion()),
+		postgres.WithDatabase("targetdb"),
+		postgres.WithUsername("user"),
+		postgres.WithPassword("password"),
+		testcontainers.WithWaitStrategy(
+			wait.ForLog("database system is ready to accept connections").WithOccurrence(2),
+			wait.ForListeningPort("5432/tcp"),
+		),
+	)
+	testcontainers.CleanupContainer(t, targetContainer)
+	require.NoError(t, err)
+
+	sourceConnStr, err := sourceContainer.ConnectionString(ctx, "sslmode=disable")
+	require.NoError(t, err)
+
+	targetConnStr, err := targetContainer.ConnectionString(ctx, "sslmode=disable")
+	require.NoError(t, err)
+
+	sourceConn, err := pgx.Connect(ctx, sourceConnStr)
+	require.NoError(t, err)
+	defer sourceConn.Close(ctx)
+	_, err = sourceConn.Exec(ctx, `
+		CREATE TABLE items (id INT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY, name TEXT NOT NULL);
+		INSERT INTO items (name) SELECT 'item ' || g FROM generate_series(1, 25) g;
+		CREATE SEQUENCE invoice_numbers START 1000;
+		SELECT nextval('invoice_numbers') FROM generate_series(1, 3);
+		CREATE SEQUENCE unused_numbers START 500;
+		CREATE SEQUENCE legacy_numbers;
+		SELECT nextval('legacy_numbers');`)
+	require.NoError(t, err)
+
+	// The target names the identity sequence differently, has advanced the unused
+	// sequence and lacks the legacy one
+	targetConn, err := pgx.Connect(ctx, targetConnStr)
+	require.NoError(t, err)
+	defer targetConn.Close(ctx)
+	_, err = targetConn.Exec(ctx, `
+		CREATE TABLE items (id INT GENERATED BY DEFAULT AS IDENTITY (SEQUENCE NAME items_identity_seq) PRIMARY KEY, name TEXT NOT NULL);
+		CREATE SEQUENCE invoice_numbers START 1000;
+		CREATE SEQUENCE unused_numbers START 500;
+		SELECT nextval('unused_numbers') FROM generate_series(1, 10);`)
+	require.NoError(t, err)
+
+	helpers.RunMigrationWithOptions(t, ctx, sourceConnStr, targetConnStr, helpers.MigrationOptions{
+		NoOwner:  true,
+		NoACL:    true,
+		DataOnly: true,
+		Engine:   config.EngineCopy,
+	})
+
+	var next int64
+	require.NoError(t, targetConn.QueryRow(ctx, "INSERT INTO items (name) VALUES ('new') RETURNING id").Scan(&next))
+	require.Equal(t, int64(26), next, "The identity sequence should be found through its column")
+	require.NoError(t, targetConn.QueryRow(ctx, "SELECT nextval('invoice_numbers')").Scan(&next))
+	require.Equal(t, int64(1003), next, "A sequence not owned by a table should be matched by name")
+	require.NoError(t, targetConn.QueryRow(ctx, "SELECT nextval('unused_numbers')").Scan(&next))
+	require.Equal(t, int64(500), next, "A sequence never used on the source should restart at its start value")
+
+	states, err := database.GetSequenceStates(ctx, sourceConnStr, nil)
+	require.NoError(t, err)
+	unmapped, err := database.ApplySequenceStates(ctx, targetConnStr, states)
+	require.NoError(t, err)
+	require.Equal(t, []string{`"public"."legacy_numbers"`}, unmapped, "A sequence missing on the target should be reported")
+
+	// pg_sequences hides the value of sequences the user cannot read, which must not be
+	// mistaken for a sequence that was never used
+	_, err = sourceConn.Exec(ctx, `
+		CREATE ROLE limited LOGIN PASSWORD 'limited';
+		GRANT SELECT ON items TO limited;`)
+	require.NoError(t, err)
+	limitedConfig, err := pgx.ParseConfig(sourceConnStr)
+	require.NoError(t, err)
+	limitedURL := fmt.Sprintf("postgres://limited:limited@%s:%d/%s?sslmode=disable", limitedConfig.Host, limitedConfig.Port, limitedConfig.Database)
+
+	states, err = database.GetSequenceStates(ctx, limitedURL, nil)
+	require.NoError(t, err)
+	_, err = database.ApplySequenceStates(ctx, targetConnStr, states)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "the source user cannot read sequences")
+	require.NoError(t, targetConn.QueryRow(ctx, "SELECT last_value FROM invoice_numbers").Scan(&next))
+	require.Equal(t, int64(1002), next, "No sequence should be rewound when some are unreadable")
 }
 
 func TestParallelJobs(t *testing.T) {
@@ -605,7 +748,7 @@ func TestLargeDataset(t *testing.T) {
 	defer targetConn.Close(ctx)
 
 	t.Log("Running comprehensive validation for large dataset migration...")
-	logger := log.New(os.Stdout, "", log.Ldate|log.Ltime)
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
 	err = validation.ValidateAllTablesFromURLs(ctx, sourceConnStr, targetConnStr, logger)
 	require.NoError(t, err)
 
@@ -668,3 +811,2857 @@ func TestExcludeSchemas(t *testing.T) {
 	require.NoError(t, err)
 	require.False(t, schemaExists, "excluded_schema should not exist in target")
 }
+
+func TestStreamingMigration(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	sourceContainer, err := postgres.Run(
+		ctx,
+		getPostgresImage(getDefaultPostgresVersion()),
+		postgres.WithDatabase("sourcedb"),
+		postgres.WithUsername("user"),
+		postgres.WithPassword("password"),
+		postgres.WithInitScripts("testdata/init-source.sql"),
+		testcontainers.WithWaitStrategy(
+			wait.ForLog("database system is ready to accept connections").WithOccurrence(2),
+			wait.ForListeningPort("5432/tcp"),
+		),
+	)
+	testcontainers.CleanupContainer(t, sourceContainer)
+	require.NoError(t, err)
+
+	targetContainer, err := postgres.Run(
+		ctx,
+		getPostgresImage(getDefaultPostgresVersion()),
+		postgres.WithDatabase("targetdb"),
+		postgres.WithUsername("user"),
+		postgres.WithPassword("password"),
+		testcontainers.WithWaitStrategy(
+			wait.ForLog("database system is ready to accept connections").WithOccurrence(2),
+			wait.ForListeningPort("5432/tcp"),
+		),
+	)
+	testcontainers.CleanupContainer(t, targetContainer)
+	require.NoError(t, err)
+
+	sourceConnStr, err := sourceContainer.ConnectionString(ctx, "sslmode=disable")
+	require.NoError(t, err)
+
+	targetConnStr, err := targetContainer.ConnectionString(ctx, "sslmode=disable")
+	require.NoError(t, err)
+
+	helpers.RunMigrationWithOptions(t, ctx, sourceConnStr, targetConnStr, helpers.MigrationOptions{
+		NoOwner: true,
+		NoACL:   true,
+		Stream:  true,
+	})
+
+	targetConn, err := pgx.Connect(ctx, targetConnStr)
+	require.NoError(t, err)
+	defer targetConn.Close(ctx)
+
+	helpers.ValidateBasicMigration(t, ctx, targetConn)
+
+	logger := logging.Discard()
+	err = validation.ValidateAllTablesFromURLs(ctx, sourceConnStr, targetConnStr, logger)
+	require.NoError(t, err)
+}
+
+// TestStreamingRestoreFailure makes pg_restore fail on a missing owner role while pg_dump
+// is still streaming a large table, so the dump is killed by the failed restore
+func TestStreamingRestoreFailure(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	sourceContainer, err := postgres.Run(
+		ctx,
+		getPostgresImage(getDefaultPostgresVersion()),
+		postgres.WithDatabase("sourcedb"),
+		postgres.WithUsername("user"),
+		postgres.WithPassword("password"),
+		postgres.WithInitScripts("testdata/init-source.sql"),
+		testcontainers.WithWaitStrategy(
+			wait.ForLog("database system is ready to accept connections").WithOccurrence(2),
+			wait.ForListeningPort("5432/tcp"),
+		),
+	)
+	testcontainers.CleanupContainer(t, sourceContainer)
+	require.NoError(t, err)
+
+	targetContainer, err := postgres.Run(
+		ctx,
+		getPostgresImage(getDefaultPostgresVersion()),
+		postgres.WithDatabase("targetdb"),
+		postgres.WithUsername("user"),
+		postgres.WithPassword("password"),
+		testcontainers.WithWaitStrategy(
+			wait.ForLog("database system is ready to accept connections").WithOccurrence(2),
+			wait.ForListeningPort("5432/tcp"),
+		),
+	)
+	testcontainers.CleanupContainer(t, targetContainer)
+	require.NoError(t, err)
+
+	sourceConnStr, err := sourceContainer.ConnectionString(ctx, "sslmode=disable")
+	require.NoError(t, err)
+
+	targetConnStr, err := targetContainer.ConnectionString(ctx, "sslmode=disable")
+	require.NoError(t, err)
+
+	sourceConn, err := pgx.Connect(ctx, sourceConnStr)
+	require.NoError(t, err)
+	defer sourceConn.Close(ctx)
+
+	for _, stmt := range []string{
+		"CREATE ROLE legacy_owner",
+		"ALTER TABLE posts OWNER TO legacy_owner",
+		"CREATE TABLE events (id BIGSERIAL PRIMARY KEY, payload TEXT NOT NULL)",
+		"INSERT INTO events (payload) SELECT repeat(md5(i::text), 8) FROM generate_series(1, 500000) i",
+	} {
+		_, err := sourceConn.Exec(ctx, stmt)
+		require.NoError(t, err)
+	}
+
+	// Without NO_OWNER, pg_restore stops at the first error
+	cfg := &config.Config{
+		SourceDatabaseURL: sourceConnStr,
+		TargetDatabaseURL: targetConnStr,
+		ParallelJobs:      1,
+		NoACL:             true,
+		Stream:            true,
+	}
+	_, err = migration.Run(ctx, cfg, logging.Discard())
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "restore failed")
+	require.Contains(t, err.Error(), `role "legacy_owner" does not exist`)
+	require.NotContains(t, err.Error(), "dump failed", "The dump was only stopped by the failed restore")
+}
+
+func TestDirectoryFormatParallelDump(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	sourceContainer, err := postgres.Run(
+		ctx,
+		getPostgresImage(getDefaultPostgresVersion()),
+		postgres.WithDatabase("sourcedb"),
+		postgres.WithUsername("user"),
+		postgres.WithPassword("password"),
+		postgres.WithInitScripts("testdata/init-source.sql"),
+		testcontainers.WithWaitStrategy(
+			wait.ForLog("database system is ready to accept connections").WithOccurrence(2),
+			wait.ForListeningPort("5432/tcp"),
+		),
+	)
+	testcontainers.CleanupContainer(t, sourceContainer)
+	require.NoError(t, err)
+
+	targetContainer, err := postgres.Run(
+		ctx,
+		getPostgresImage(getDefaultPostgresVersion()),
+		postgres.WithDatabase("targetdb"),
+		postgres.WithUsername("user"),
+		postgres.WithPassword("password"),
+		testcontainers.WithWaitStrategy(
+			wait.ForLog("database system is ready to accept connections").WithOccurrence(2),
+			wait.ForListeningPort("5432/tcp"),
+		),
+	)
+	testcontainers.CleanupContainer(t, targetContainer)
+	require.NoError(t, err)
+
+	sourceConnStr, err := sourceContainer.ConnectionString(ctx, "sslmode=disable")
+	require.NoError(t, err)
+
+	targetConnStr, err := targetContainer.ConnectionString(ctx, "sslmode=disable")
+	require.NoError(t, err)
+
+	helpers.RunMigrationWithOptions(t, ctx, sourceConnStr, targetConnStr, helpers.MigrationOptions{
+		NoOwner:      true,
+		NoACL:        true,
+		ParallelJobs: 4,
+		DumpFormat:   config.DumpFormatDirectory,
+	})
+
+	targetConn, err := pgx.Connect(ctx, targetConnStr)
+	require.NoError(t, err)
+	defer targetConn.Close(ctx)
+
+	helpers.ValidateBasicMigration(t, ctx, targetConn)
+
+	logger := logging.Discard()
+	err = validation.ValidateAllTablesFromURLs(ctx, sourceConnStr, targetConnStr, logger)
+	require.NoError(t, err)
+}
+
+func TestResumeMigration(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	sourceContainer, err := postgres.Run(
+		ctx,
+		getPostgresImage(getDefaultPostgresVersion()),
+		postgres.WithDatabase("sourcedb"),
+		postgres.WithUsername("user"),
+		postgres.WithPassword("password"),
+		postgres.WithInitScripts("testdata/init-source.sql"),
+		testcontainers.WithWaitStrategy(
+			wait.ForLog("database system is ready to accept connections").WithOccurrence(2),
+			wait.ForListeningPort("5432/tcp"),
+		),
+	)
+	testcontainers.CleanupContainer(t, sourceContainer)
+	require.NoError(t, err)
+
+	targetContainer, err := postgres.Run(
+		ctx,
+		getPostgresImage(getDefaultPostgresVersion()),
+		postgres.WithDatabase("targetdb"),
+		postgres.WithUsername("user"),
+		postgres.WithPassword("password"),
+		testcontainers.WithWaitStrategy(
+			wait.ForLog("database system is ready to accept connections").WithOccurrence(2),
+			wait.ForListeningPort("5432/tcp"),
+		),
+	)
+	testcontainers.CleanupContainer(t, targetContainer)
+	require.NoError(t, err)
+
+	sourceConnStr, err := sourceContainer.ConnectionString(ctx, "sslmode=disable")
+	require.NoError(t, err)
+
+	targetConnStr, err := targetContainer.ConnectionString(ctx, "sslmode=disable")
+	require.NoError(t, err)
+
+	stateDir := t.TempDir()
+	stateFile := filepath.Join(stateDir, "state.json")
+
+	// Simulate a run that died while pg_dump was still writing its archive
+	incompleteDir := filepath.Join(stateDir, "postgres-migrator-incomplete")
+	require.NoError(t, os.MkdirAll(incompleteDir, 0o755))
+	state := migrator.NewState(stateFile)
+	state.DumpPath = filepath.Join(incompleteDir, "db.dump")
+	require.NoError(t, state.SetPhase(migrator.PhaseDumping))
+
+	opts := helpers.MigrationOptions{
+		NoOwner:   true,
+		NoACL:     true,
+		StateFile: stateFile,
+	}
+	helpers.RunMigrationWithOptionsExpectError(t, ctx, sourceConnStr, targetConnStr, opts, "set RESUME=true")
+
+	opts.Resume = true
+	helpers.RunMigrationWithOptions(t, ctx, sourceConnStr, targetConnStr, opts)
+
+	targetConn, err := pgx.Connect(ctx, targetConnStr)
+	require.NoError(t, err)
+	defer targetConn.Close(ctx)
+
+	helpers.ValidateBasicMigration(t, ctx, targetConn)
+
+	state, err = migrator.LoadState(stateFile)
+	require.NoError(t, err)
+	require.Equal(t, migrator.PhaseCompleted, state.Phase)
+	require.NotEmpty(t, state.RestoredEntries, "Restored TOC entries should be checkpointed")
+	require.NoDirExists(t, incompleteDir, "Incomplete dump should be removed")
+	require.NoDirExists(t, filepath.Dir(state.DumpPath), "Kept dump should be removed once the restore completes")
+}
+
+func TestResumeInterruptedRestore(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	sourceContainer, err := postgres.Run(
+		ctx,
+		getPostgresImage(getDefaultPostgresVersion()),
+		postgres.WithDatabase("sourcedb"),
+		postgres.WithUsername("user"),
+		postgres.WithPassword("password"),
+		testcontainers.WithWaitStrategy(
+			wait.ForLog("database system is ready to accept connections").WithOccurrence(2),
+			wait.ForListeningPort("5432/tcp"),
+		),
+	)
+	testcontainers.CleanupContainer(t, sourceContainer)
+	require.NoError(t, err)
+
+	targetContainer, err := postgres.Run(
+		ctx,
+		getPostgresImage(getDefaultPostgresVersion()),
+		postgres.WithDatabase("targetdb"),
+		postgres.WithUsername("user"),
+		postgres.WithPassword("password"),
+		testcontainers.WithWaitStrategy(
+			wait.ForLog("database system is ready to accept connections").WithOccurrence(2),
+			wait.ForListeningPort("5432/tcp"),
+		),
+	)
+	testcontainers.CleanupContainer(t, targetContainer)
+	require.NoError(t, err)
+
+	sourceConnStr, err := sourceContainer.ConnectionString(ctx, "sslmode=disable")
+	require.NoError(t, err)
+
+	targetConnStr, err := targetContainer.ConnectionString(ctx, "sslmode=disable")
+	require.NoError(t, err)
+
+	// Enough entries that the restore is still running when it gets interrupted
+	sourceConn, err := pgx.Connect(ctx, sourceConnStr)
+	require.NoError(t, err)
+	_, err = sourceConn.Exec(ctx, `
+		DO $$
+		BEGIN
+			FOR i IN 1..300 LOOP
+				EXECUTE format('CREATE TABLE bulk_%s (id SERIAL PRIMARY KEY, payload TEXT NOT NULL)', i);
+				EXECUTE format('INSERT INTO bulk_%s (payload) SELECT md5(g::text) FROM generate_series(1, 2000) g', i);
+				EXECUTE format('CREATE INDEX ON bulk_%s (payload)', i);
+				EXECUTE format('COMMENT ON TABLE bulk_%s IS %L', i, 'bulk table');
+			END LOOP;
+		END $$`)
+	require.NoError(t, err)
+	sourceConn.Close(ctx)
+
+	stateFile := filepath.Join(t.TempDir(), "state.json")
+	workDir := t.TempDir()
+
+	// With owners kept, pg_restore runs with --exit-on-error, so replaying an entry that
+	// was already restored fails the resumed run
+	cfg := &config.Config{
+		SourceDatabaseURL: sourceConnStr,
+		TargetDatabaseURL: targetConnStr,
+		ParallelJobs:      1,
+		NoOwner:           false,
+		NoACL:             true,
+		StateFile:         stateFile,
+		WorkDir:           workDir,
+	}
+
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	result := make(chan error, 1)
+	go func() {
+		_, err := migration.Run(runCtx, cfg, logging.Discard())
+		result <- err
+	}()
+
+	require.Eventually(t, func() bool {
+		state, err := migrator.LoadState(stateFile)
+		return err == nil && state.Phase == migrator.PhaseRestoring && len(state.RestoredEntries) >= 200
+	}, 3*time.Minute, 10*time.Millisecond, "The restore should checkpoint entries while it runs")
+	cancel()
+	require.Error(t, <-result, "Killing pg_restore should fail the run")
+
+	state, err := migrator.LoadState(stateFile)
+	require.NoError(t, err)
+	require.Equal(t, migrator.PhaseRestoring, state.Phase, "The restore should have been interrupted")
+	require.Equal(t, workDir, filepath.Dir(filepath.Dir(state.DumpPath)), "The kept dump should be in WORK_DIR")
+	interrupted := len(state.RestoredEntries)
+
+	cfg.Resume = true
+	_, err = migration.Run(ctx, cfg, logging.Discard())
+	require.NoError(t, err, "Resuming should restore only the remaining entries")
+
+	state, err = migrator.LoadState(stateFile)
+	require.NoError(t, err)
+	require.Equal(t, migrator.PhaseCompleted, state.Phase)
+	require.Greater(t, len(state.RestoredEntries), interrupted)
+
+	targetConn, err := pgx.Connect(ctx, targetConnStr)
+	require.NoError(t, err)
+	defer targetConn.Close(ctx)
+
+	var tables, rows int
+	require.NoError(t, targetConn.QueryRow(ctx, "SELECT COUNT(*) FROM pg_tables WHERE tablename LIKE 'bulk\\_%'").Scan(&tables))
+	require.Equal(t, 300, tables)
+	for _, table := range []string{"bulk_1", "bulk_150", "bulk_300"} {
+		require.NoError(t, targetConn.QueryRow(ctx, "SELECT COUNT(*) FROM "+table).Scan(&rows))
+		require.Equal(t, 2000, rows, "%s should be restored exactly once", table)
+	}
+}
+
+func TestPlan(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	sourceContainer, err := postgres.Run(
+		ctx,
+		getPostgresImage(getDefaultPostgresVersion()),
+		postgres.WithDatabase("sourcedb"),
+		postgres.WithUsername("user"),
+		postgres.WithPassword("password"),
+		postgres.WithInitScripts("testdata/init-source.sql"),
+		testcontainers.WithWaitStrategy(
+			wait.ForLog("database system is ready to accept connections").WithOccurrence(2),
+			wait.ForListeningPort("5432/tcp"),
+		),
+	)
+	testcontainers.CleanupContainer(t, sourceContainer)
+	require.NoError(t, err)
+
+	targetContainer, err := postgres.Run(
+		ctx,
+		getPostgresImage(getDefaultPostgresVersion()),
+		postgres.WithDatabase("targetdb"),
+		postgres.WithUsername("user"),
+		postgres.WithPassword("password"),
+		testcontainers.WithWaitStrategy(
+			wait.ForLog("database system is ready to accept connections").WithOccurrence(2),
+			wait.ForListeningPort("5432/tcp"),
+		),
+	)
+	testcontainers.CleanupContainer(t, targetContainer)
+	require.NoError(t, err)
+
+	sourceConnStr, err := sourceContainer.ConnectionString(ctx, "sslmode=disable")
+	require.NoError(t, err)
+
+	targetConnStr, err := targetContainer.ConnectionString(ctx, "sslmode=disable")
+	require.NoError(t, err)
+
+	var output bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&output, nil))
+	cfg := &config.Config{
+		SourceDatabaseURL: sourceConnStr,
+		TargetDatabaseURL: targetConnStr,
+		ParallelJobs:      1,
+		NoOwner:           true,
+		NoACL:             true,
+	}
+	err = migration.Plan(ctx, cfg, logger)
+	require.NoError(t, err)
+
+	plan := output.String()
+	require.Contains(t, plan, "public.users")
+	require.Contains(t, plan, "public.posts")
+	require.Contains(t, plan, "public.users_id_seq")
+	require.Contains(t, plan, "--no-owner")
+	require.Contains(t, plan, "xxxxx", "Passwords should be redacted in the command lines")
+	require.NotContains(t, plan, ":password@", "Passwords must not be printed")
+
+	targetConn, err := pgx.Connect(ctx, targetConnStr)
+	require.NoError(t, err)
+	defer targetConn.Close(ctx)
+
+	var tableCount int
+	err = targetConn.QueryRow(ctx, "SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = 'public'").Scan(&tableCount)
+	require.NoError(t, err)
+	require.Equal(t, 0, tableCount, "Plan should not write anything to the target")
+}
+
+func TestDumpAndRestoreArchive(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	sourceContainer, err := postgres.Run(
+		ctx,
+		getPostgresImage(getDefaultPostgresVersion()),
+		postgres.WithDatabase("sourcedb"),
+		postgres.WithUsername("user"),
+		postgres.WithPassword("password"),
+		postgres.WithInitScripts("testdata/init-source.sql"),
+		testcontainers.WithWaitStrategy(
+			wait.ForLog("database system is ready to accept connections").WithOccurrence(2),
+			wait.ForListeningPort("5432/tcp"),
+		),
+	)
+	testcontainers.CleanupContainer(t, sourceContainer)
+	require.NoError(t, err)
+
+	targetContainer, err := postgres.Run(
+		ctx,
+		getPostgresImage(getDefaultPostgresVersion()),
+		postgres.WithDatabase("targetdb"),
+		postgres.WithUsername("user"),
+		postgres.WithPassword("password"),
+		testcontainers.WithWaitStrategy(
+			wait.ForLog("database system is ready to accept connections").WithOccurrence(2),
+			wait.ForListeningPort("5432/tcp"),
+		),
+	)
+	testcontainers.CleanupContainer(t, targetContainer)
+	require.NoError(t, err)
+
+	sourceConnStr, err := sourceContainer.ConnectionString(ctx, "sslmode=disable")
+	require.NoError(t, err)
+
+	targetConnStr, err := targetContainer.ConnectionString(ctx, "sslmode=disable")
+	require.NoError(t, err)
+
+	archivePath := filepath.Join(t.TempDir(), "sourcedb.dump")
+	logger := logging.Discard()
+
+	dumpCfg := &config.Config{
+		SourceDatabaseURL: sourceConnStr,
+		ParallelJobs:      1,
+		NoOwner:           true,
+		NoACL:             true,
+		ArchivePath:       archivePath,
+	}
+	require.NoError(t, dumpCfg.ValidateDump())
+	err = migration.Dump(ctx, dumpCfg, logger)
+	require.NoError(t, err)
+	require.FileExists(t, archivePath)
+
+	err = migration.Dump(ctx, dumpCfg, logger)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "already exists")
+
+	restoreCfg := &config.Config{
+		TargetDatabaseURL: targetConnStr,
+		ParallelJobs:      2,
+		NoOwner:           true,
+		NoACL:             true,
+		ArchivePath:       archivePath,
+	}
+	require.NoError(t, restoreCfg.ValidateRestore())
+	err = migration.Restore(ctx, restoreCfg, logger)
+	require.NoError(t, err)
+	require.FileExists(t, archivePath, "Archive should be kept after restore")
+
+	targetConn, err := pgx.Connect(ctx, targetConnStr)
+	require.NoError(t, err)
+	defer targetConn.Close(ctx)
+
+	helpers.ValidateBasicMigration(t, ctx, targetConn)
+
+	err = validation.ValidateAllTablesFromURLs(ctx, sourceConnStr, targetConnStr, logger)
+	require.NoError(t, err)
+}
+
+func TestArchiveObjectStorage(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	sourceContainer, err := postgres.Run(
+		ctx,
+		getPostgresImage(getDefaultPostgresVersion()),
+		postgres.WithDatabase("sourcedb"),
+		postgres.WithUsername("user"),
+		postgres.WithPassword("password"),
+		postgres.WithInitScripts("testdata/init-source.sql"),
+		testcontainers.WithWaitStrategy(
+			wait.ForLog("database system is ready to accept connections").WithOccurrence(2),
+			wait.ForListeningPort("5432/tcp"),
+		),
+	)
+	testcontainers.CleanupContainer(t, sourceContainer)
+	require.NoError(t, err)
+
+	targetContainer, err := postgres.Run(
+		ctx,
+		getPostgresImage(getDefaultPostgresVersion()),
+		postgres.WithDatabase("targetdb"),
+		postgres.WithUsername("user"),
+		postgres.WithPassword("password"),
+		testcontainers.WithWaitStrategy(
+			wait.ForLog("database system is ready to accept connections").WithOccurrence(2),
+			wait.ForListeningPort("5432/tcp"),
+		),
+	)
+	testcontainers.CleanupContainer(t, targetContainer)
+	require.NoError(t, err)
+
+	sourceConnStr, err := sourceContainer.ConnectionString(ctx, "sslmode=disable")
+	require.NoError(t, err)
+
+	targetConnStr, err := targetContainer.ConnectionString(ctx, "sslmode=disable")
+	require.NoError(t, err)
+
+	minioContainer, err := testcontainers.GenericContainer(ctx, testcontainers.GenericContainerRequest{
+		ContainerRequest: testcontainers.ContainerRequest{
+			Image:        "minio/minio:latest",
+			ExposedPorts: []string{"9000/tcp"},
+			Env: map[string]string{
+				"MINIO_ROOT_USER":     "minioadmin",
+				"MINIO_ROOT_PASSWORD": "minioadmin",
+			},
+			Cmd:        []string{"server", "/data"},
+			WaitingFor: wait.ForHTTP("/minio/health/live").WithPort("9000/tcp"),
+		},
+		Started: true,
+	})
+	testcontainers.CleanupContainer(t, minioContainer)
+	require.NoError(t, err)
+
+	endpoint, err := minioContainer.PortEndpoint(ctx, "9000/tcp", "")
+	require.NoError(t, err)
+
+	minioClient, err := minio.New(endpoint, &minio.Options{
+		Creds: credentials.NewStaticV4("minioadmin", "minioadmin", ""),
+	})
+	require.NoError(t, err)
+	require.NoError(t, minioClient.MakeBucket(ctx, "archives", minio.MakeBucketOptions{}))
+
+	logger := logging.Discard()
+	storageCfg := config.Config{
+		ParallelJobs:      1,
+		NoOwner:           true,
+		NoACL:             true,
+		ArchiveURI:        "s3://archives/sourcedb.dump",
+		S3Endpoint:        endpoint,
+		S3AccessKeyID:     "minioadmin",
+		S3SecretAccessKey: "minioadmin",
+		S3Insecure:        true,
+	}
+
+	dumpCfg := storageCfg
+	dumpCfg.SourceDatabaseURL = sourceConnStr
+	require.NoError(t, dumpCfg.ValidateDump())
+	err = migration.Dump(ctx, &dumpCfg, logger)
+	require.NoError(t, err)
+
+	info, err := minioClient.StatObject(ctx, "archives", "sourcedb.dump", minio.StatObjectOptions{})
+	require.NoError(t, err)
+	require.NotEmpty(t, info.UserMetadata["Sha256"], "Uploaded archive should carry its checksum")
+
+	// A truncated copy left by an earlier attempt must be downloaded again, not restored
+	stalePath := filepath.Join(t.TempDir(), "sourcedb.dump")
+	require.NoError(t, os.WriteFile(stalePath, []byte("PGDMP truncated"), 0o600))
+
+	restoreCfg := storageCfg
+	restoreCfg.TargetDatabaseURL = targetConnStr
+	restoreCfg.ArchivePath = stalePath
+	require.NoError(t, restoreCfg.ValidateRestore())
+	err = migration.Restore(ctx, &restoreCfg, logger)
+	require.NoError(t, err)
+
+	fetched, err := os.ReadFile(stalePath)
+	require.NoError(t, err)
+	require.Equal(t, info.UserMetadata["Sha256"], fmt.Sprintf("%x", sha256.Sum256(fetched)), "The stale copy should have been replaced")
+
+	targetConn, err := pgx.Connect(ctx, targetConnStr)
+	require.NoError(t, err)
+	defer targetConn.Close(ctx)
+
+	helpers.ValidateBasicMigration(t, ctx, targetConn)
+
+	corrupted := []byte("not a pg_dump archive")
+	_, err = minioClient.PutObject(ctx, "archives", "corrupted.dump", bytes.NewReader(corrupted), int64(len(corrupted)), minio.PutObjectOptions{
+		UserMetadata: map[string]string{"Sha256": info.UserMetadata["Sha256"]},
+	})
+	require.NoError(t, err)
+
+	corruptedCfg := restoreCfg
+	corruptedCfg.ArchiveURI = "s3://archives/corrupted.dump"
+	corruptedCfg.DataOnly = true
+	err = migration.Restore(ctx, &corruptedCfg, logger)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "checksum mismatch")
+}
+
+func TestEncryptedArchive(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	sourceContainer, err := postgres.Run(
+		ctx,
+		getPostgresImage(getDefaultPostgresVersion()),
+		postgres.WithDatabase("sourcedb"),
+		postgres.WithUsername("user"),
+		postgres.WithPassword("password"),
+		postgres.WithInitScripts("testdata/init-source.sql"),
+		testcontainers.WithWaitStrategy(
+			wait.ForLog("database system is ready to accept connections").WithOccurrence(2),
+			wait.ForListeningPort("5432/tcp"),
+		),
+	)
+	testcontainers.CleanupContainer(t, sourceContainer)
+	require.NoError(t, err)
+
+	targetContainer, err := postgres.Run(
+		ctx,
+		getPostgresImage(getDefaultPostgresVersion()),
+		postgres.WithDatabase("targetdb"),
+		postgres.WithUsername("user"),
+		postgres.WithPassword("password"),
+		testcontainers.WithWaitStrategy(
+			wait.ForLog("database system is ready to accept connections").WithOccurrence(2),
+			wait.ForListeningPort("5432/tcp"),
+		),
+	)
+	testcontainers.CleanupContainer(t, targetContainer)
+	require.NoError(t, err)
+
+	sourceConnStr, err := sourceContainer.ConnectionString(ctx, "sslmode=disable")
+	require.NoError(t, err)
+
+	targetConnStr, err := targetContainer.ConnectionString(ctx, "sslmode=disable")
+	require.NoError(t, err)
+
+	archivePath := filepath.Join(t.TempDir(), "sourcedb.dump.age")
+	logger := logging.Discard()
+
+	dumpCfg := &config.Config{
+		SourceDatabaseURL:    sourceConnStr,
+		ParallelJobs:         1,
+		NoOwner:              true,
+		NoACL:                true,
+		ArchivePath:          archivePath,
+		EncryptionPassphrase: "correct horse battery staple",
+	}
+	require.NoError(t, dumpCfg.ValidateDump())
+	err = migration.Dump(ctx, dumpCfg, logger)
+	require.NoError(t, err)
+
+	encrypted, err := migrator.IsEncrypted(archivePath)
+	require.NoError(t, err)
+	require.True(t, encrypted, "Archive should be encrypted at rest")
+
+	archive, err := os.ReadFile(archivePath)
+	require.NoError(t, err)
+	require.NotContains(t, string(archive), "alice@example.com", "Archive must not contain plaintext data")
+
+	restoreCfg := &config.Config{
+		TargetDatabaseURL: targetConnStr,
+		ParallelJobs:      1,
+		NoOwner:           true,
+		NoACL:             true,
+		ArchivePath:       archivePath,
+	}
+	err = migration.Restore(ctx, restoreCfg, logger)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "archive is encrypted")
+
+	restoreCfg.EncryptionPassphrase = "correct horse battery staple"
+	err = migration.Restore(ctx, restoreCfg, logger)
+	require.NoError(t, err)
+
+	targetConn, err := pgx.Connect(ctx, targetConnStr)
+	require.NoError(t, err)
+	defer targetConn.Close(ctx)
+
+	helpers.ValidateBasicMigration(t, ctx, targetConn)
+}
+
+func TestCompression(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	sourceContainer, err := postgres.Run(
+		ctx,
+		getPostgresImage(getDefaultPostgresVersion()),
+		postgres.WithDatabase("sourcedb"),
+		postgres.WithUsername("user"),
+		postgres.WithPassword("password"),
+		postgres.WithInitScripts("testdata/init-source.sql"),
+		testcontainers.WithWaitStrategy(
+			wait.ForLog("database system is ready to accept connections").WithOccurrence(2),
+			wait.ForListeningPort("5432/tcp"),
+		),
+	)
+	testcontainers.CleanupContainer(t, sourceContainer)
+	require.NoError(t, err)
+
+	targetContainer, err := postgres.Run(
+		ctx,
+		getPostgresImage(getDefaultPostgresVersion()),
+		postgres.WithDatabase("targetdb"),
+		postgres.WithUsername("user"),
+		postgres.WithPassword("password"),
+		testcontainers.WithWaitStrategy(
+			wait.ForLog("database system is ready to accept connections").WithOccurrence(2),
+			wait.ForListeningPort("5432/tcp"),
+		),
+	)
+	testcontainers.CleanupContainer(t, targetContainer)
+	require.NoError(t, err)
+
+	sourceConnStr, err := sourceContainer.ConnectionString(ctx, "sslmode=disable")
+	require.NoError(t, err)
+
+	targetConnStr, err := targetContainer.ConnectionString(ctx, "sslmode=disable")
+	require.NoError(t, err)
+
+	pgDumpVersion, err := migrator.PgDumpMajorVersion(ctx)
+	require.NoError(t, err)
+
+	opts := helpers.MigrationOptions{
+		NoOwner:     true,
+		NoACL:       true,
+		Compression: "zstd:5",
+	}
+
+	if pgDumpVersion < 16 {
+		helpers.RunMigrationWithOptionsExpectError(t, ctx, sourceConnStr, targetConnStr, opts, "requires pg_dump 16 or newer")
+		opts.Compression = "9"
+	}
+
+	helpers.RunMigrationWithOptions(t, ctx, sourceConnStr, targetConnStr, opts)
+
+	targetConn, err := pgx.Connect(ctx, targetConnStr)
+	require.NoError(t, err)
+	defer targetConn.Close(ctx)
+
+	helpers.ValidateBasicMigration(t, ctx, targetConn)
+}
+
+func TestWorkDir(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	sourceContainer, err := postgres.Run(
+		ctx,
+		getPostgresImage(getDefaultPostgresVersion()),
+		postgres.WithDatabase("sourcedb"),
+		postgres.WithUsername("user"),
+		postgres.WithPassword("password"),
+		postgres.WithInitScripts("testdata/init-source.sql"),
+		testcontainers.WithWaitStrategy(
+			wait.ForLog("database system is ready to accept connections").WithOccurrence(2),
+			wait.ForListeningPort("5432/tcp"),
+		),
+	)
+	testcontainers.CleanupContainer(t, sourceContainer)
+	require.NoError(t, err)
+
+	targetContainer, err := postgres.Run(
+		ctx,
+		getPostgresImage(getDefaultPostgresVersion()),
+		postgres.WithDatabase("targetdb"),
+		postgres.WithUsername("user"),
+		postgres.WithPassword("password"),
+		testcontainers.WithWaitStrategy(
+			wait.ForLog("database system is ready to accept connections").WithOccurrence(2),
+			wait.ForListeningPort("5432/tcp"),
+		),
+	)
+	testcontainers.CleanupContainer(t, targetContainer)
+	require.NoError(t, err)
+
+	sourceConnStr, err := sourceContainer.ConnectionString(ctx, "sslmode=disable")
+	require.NoError(t, err)
+
+	targetConnStr, err := targetContainer.ConnectionString(ctx, "sslmode=disable")
+	require.NoError(t, err)
+
+	workDir := t.TempDir()
+
+	helpers.RunMigrationWithOptions(t, ctx, sourceConnStr, targetConnStr, helpers.MigrationOptions{
+		NoOwner: true,
+		NoACL:   true,
+		WorkDir: workDir,
+	})
+
+	targetConn, err := pgx.Connect(ctx, targetConnStr)
+	require.NoError(t, err)
+	defer targetConn.Close(ctx)
+
+	helpers.ValidateBasicMigration(t, ctx, targetConn)
+
+	entries, err := os.ReadDir(workDir)
+	require.NoError(t, err)
+	require.Empty(t, entries, "Temporary dump should be removed from the work directory")
+}
+
+func TestOnlineMigration(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	sourceContainer, err := postgres.Run(
+		ctx,
+		getPostgresImage(getDefaultPostgresVersion()),
+		postgres.WithDatabase("sourcedb"),
+		postgres.WithUsername("user"),
+		postgres.WithPassword("password"),
+		postgres.WithInitScripts("testdata/init-source.sql"),
+		testcontainers.WithCmd("postgres", "-c", "fsync=off", "-c", "wal_level=logical"),
+		testcontainers.WithWaitStrategy(
+			wait.ForLog("database system is ready to accept connections").WithOccurrence(2),
+			wait.ForListeningPort("5432/tcp"),
+		),
+	)
+	testcontainers.CleanupContainer(t, sourceContainer)
+	require.NoError(t, err)
+
+	targetContainer, err := postgres.Run(
+		ctx,
+		getPostgresImage(getDefaultPostgresVersion()),
+		postgres.WithDatabase("targetdb"),
+		postgres.WithUsername("user"),
+		postgres.WithPassword("password"),
+		testcontainers.WithWaitStrategy(
+			wait.ForLog("database system is ready to accept connections").WithOccurrence(2),
+			wait.ForListeningPort("5432/tcp"),
+		),
+	)
+	testcontainers.CleanupContainer(t, targetContainer)
+	require.NoError(t, err)
+
+	sourceConnStr, err := sourceContainer.ConnectionString(ctx, "sslmode=disable")
+	require.NoError(t, err)
+
+	targetConnStr, err := targetContainer.ConnectionString(ctx, "sslmode=disable")
+	require.NoError(t, err)
+
+	// The target server connects to the source over the container network
+	sourceIP, err := sourceContainer.ContainerIP(ctx)
+	require.NoError(t, err)
+	subscriptionSourceURL := fmt.Sprintf("postgres://user:password@%s:5432/sourcedb?sslmode=disable", sourceIP)
+
+	sourceConn, err := pgx.Connect(ctx, sourceConnStr)
+	require.NoError(t, err)
+	defer sourceConn.Close(ctx)
+
+	// A table without a replica identity is fine as long as its rows are not published
+	_, err = sourceConn.Exec(ctx, "CREATE TABLE audit_log (message TEXT)")
+	require.NoError(t, err)
+
+	// With the trigger file already present the run returns as soon as the initial sync is done
+	triggerFile := filepath.Join(t.TempDir(), "cutover")
+	require.NoError(t, os.WriteFile(triggerFile, nil, 0o644))
+
+	helpers.RunMigrationWithOptions(t, ctx, sourceConnStr, targetConnStr, helpers.MigrationOptions{
+		NoOwner:               true,
+		NoACL:                 true,
+		Online:                true,
+		SubscriptionSourceURL: subscriptionSourceURL,
+		CutoverTriggerFile:    triggerFile,
+		ExcludeTableData:      []string{"audit_log"},
+	})
+
+	targetConn, err := pgx.Connect(ctx, targetConnStr)
+	require.NoError(t, err)
+	defer targetConn.Close(ctx)
+
+	helpers.ValidateBasicMigration(t, ctx, targetConn)
+
+	_, err = sourceConn.Exec(ctx, "INSERT INTO users (name, email) VALUES ('Online User', 'online@example.com')")
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		var count int
+		err := targetConn.QueryRow(ctx, "SELECT COUNT(*) FROM users WHERE email = 'online@example.com'").Scan(&count)
+		return err == nil && count == 1
+	}, 30*time.Second, 500*time.Millisecond, "Writes on the source should replicate to the target")
+
+	_, err = targetConn.Exec(ctx, "DROP SUBSCRIPTION postgres_migrator")
+	require.NoError(t, err)
+}
+
+func TestOnlineMigrationRequiresLogicalWAL(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	sourceContainer, err := postgres.Run(
+		ctx,
+		getPostgresImage(getDefaultPostgresVersion()),
+		postgres.WithDatabase("sourcedb"),
+		postgres.WithUsername("user"),
+		postgres.WithPassword("password"),
+		postgres.WithInitScripts("testdata/init-source.sql"),
+		testcontainers.WithWaitStrategy(
+			wait.ForLog("database system is ready to accept connections").WithOccurrence(2),
+			wait.ForListeningPort("5432/tcp"),
+		),
+	)
+	testcontainers.CleanupContainer(t, sourceContainer)
+	require.NoError(t, err)
+
+	targetContainer, err := postgres.Run(
+		ctx,
+		getPostgresImage(getDefaultPostgresVersion()),
+		postgres.WithDatabase("targetdb"),
+		postgres.WithUsername("user"),
+		postgres.WithPassword("password"),
+		testcontainers.WithWaitStrategy(
+			wait.ForLog("database system is ready to accept connections").WithOccurrence(2),
+			wait.ForListeningPort("5432/tcp"),
+		),
+	)
+	testcontainers.CleanupContainer(t, targetContainer)
+	require.NoError(t, err)
+
+	sourceConnStr, err := sourceContainer.ConnectionString(ctx, "sslmode=disable")
+	require.NoError(t, err)
+
+	targetConnStr, err := targetContainer.ConnectionString(ctx, "sslmode=disable")
+	require.NoError(t, err)
+
+	helpers.RunMigrationWithOptionsExpectError(t, ctx, sourceConnStr, targetConnStr, helpers.MigrationOptions{
+		NoOwner: true,
+		NoACL:   true,
+		Online:  true,
+	}, "wal_level")
+}
+
+func TestCutover(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	sourceContainer, err := postgres.Run(
+		ctx,
+		getPostgresImage(getDefaultPostgresVersion()),
+		postgres.WithDatabase("sourcedb"),
+		postgres.WithUsername("user"),
+		postgres.WithPassword("password"),
+		postgres.WithInitScripts("testdata/init-source.sql"),
+		testcontainers.WithCmd("postgres", "-c", "fsync=off", "-c", "wal_level=logical"),
+		testcontainers.WithWaitStrategy(
+			wait.ForLog("database system is ready to accept connections").WithOccurrence(2),
+			wait.ForListeningPort("5432/tcp"),
+		),
+	)
+	testcontainers.CleanupContainer(t, sourceContainer)
+	require.NoError(t, err)
+
+	targetContainer, err := postgres.Run(
+		ctx,
+		getPostgresImage(getDefaultPostgresVersion()),
+		postgres.WithDatabase("targetdb"),
+		postgres.WithUsername("user"),
+		postgres.WithPassword("password"),
+		testcontainers.WithWaitStrategy(
+			wait.ForLog("database system is ready to accept connections").WithOccurrence(2),
+			wait.ForListeningPort("5432/tcp"),
+		),
+	)
+	testcontainers.CleanupContainer(t, targetContainer)
+	require.NoError(t, err)
+
+	sourceConnStr, err := sourceContainer.ConnectionString(ctx, "sslmode=disable")
+	require.NoError(t, err)
+
+	targetConnStr, err := targetContainer.ConnectionString(ctx, "sslmode=disable")
+	require.NoError(t, err)
+
+	sourceIP, err := sourceContainer.ContainerIP(ctx)
+	require.NoError(t, err)
+
+	triggerFile := filepath.Join(t.TempDir(), "cutover")
+	require.NoError(t, os.WriteFile(triggerFile, nil, 0o644))
+
+	helpers.RunMigrationWithOptions(t, ctx, sourceConnStr, targetConnStr, helpers.MigrationOptions{
+		NoOwner:               true,
+		NoACL:                 true,
+		Online:                true,
+		SubscriptionSourceURL: fmt.Sprintf("postgres://user:password@%s:5432/sourcedb?sslmode=disable", sourceIP),
+		CutoverTriggerFile:    triggerFile,
+	})
+
+	sourceConn, err := pgx.Connect(ctx, sourceConnStr)
+	require.NoError(t, err)
+	_, err = sourceConn.Exec(ctx, "INSERT INTO users (name, email) VALUES ('Late User', 'late@example.com')")
+	require.NoError(t, err)
+	sourceConn.Close(ctx)
+
+	cfg := &config.Config{
+		SourceDatabaseURL: sourceConnStr,
+		TargetDatabaseURL: targetConnStr,
+		PublicationName:   "postgres_migrator",
+		SubscriptionName:  "postgres_migrator",
+		CutoverTimeout:    time.Minute,
+	}
+	logger := logging.Discard()
+	require.NoError(t, migration.Cutover(ctx, cfg, logger))
+
+	targetConn, err := pgx.Connect(ctx, targetConnStr)
+	require.NoError(t, err)
+	defer targetConn.Close(ctx)
+
+	var count int
+	err = targetConn.QueryRow(ctx, "SELECT COUNT(*) FROM users WHERE email = 'late@example.com'").Scan(&count)
+	require.NoError(t, err)
+	require.Equal(t, 1, count, "Writes made before cutover should reach the target")
+
+	var sourceLastValue, targetLastValue int64
+	sourceConn, err = pgx.Connect(ctx, sourceConnStr)
+	require.NoError(t, err)
+	defer sourceConn.Close(ctx)
+	require.NoError(t, sourceConn.QueryRow(ctx, "SELECT last_value FROM users_id_seq").Scan(&sourceLastValue))
+	require.NoError(t, targetConn.QueryRow(ctx, "SELECT last_value FROM users_id_seq").Scan(&targetLastValue))
+	require.Equal(t, sourceLastValue, targetLastValue, "Sequence values should be copied to the target")
+
+	_, err = sourceConn.Exec(ctx, "INSERT INTO users (name, email) VALUES ('Frozen User', 'frozen@example.com')")
+	require.Error(t, err, "Source should be read-only after cutover")
+	require.Contains(t, err.Error(), "read-only")
+
+	var subscriptions int
+	require.NoError(t, targetConn.QueryRow(ctx, "SELECT COUNT(*) FROM pg_subscription").Scan(&subscriptions))
+	require.Equal(t, 0, subscriptions, "Subscription should be dropped after cutover")
+}
+
+func TestCutoverRollback(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	sourceContainer, err := postgres.Run(
+		ctx,
+		getPostgresImage(getDefaultPostgresVersion()),
+		postgres.WithDatabase("sourcedb"),
+		postgres.WithUsername("user"),
+		postgres.WithPassword("password"),
+		postgres.WithInitScripts("testdata/init-source.sql"),
+		testcontainers.WithWaitStrategy(
+			wait.ForLog("database system is ready to accept connections").WithOccurrence(2),
+			wait.ForListeningPort("5432/tcp"),
+		),
+	)
+	testcontainers.CleanupContainer(t, sourceContainer)
+	require.NoError(t, err)
+
+	targetContainer, err := postgres.Run(
+		ctx,
+		getPostgresImage(getDefaultPostgresVersion()),
+		postgres.WithDatabase("targetdb"),
+		postgres.WithUsername("user"),
+		postgres.WithPassword("password"),
+		testcontainers.WithWaitStrategy(
+			wait.ForLog("database system is ready to accept connections").WithOccurrence(2),
+			wait.ForListeningPort("5432/tcp"),
+		),
+	)
+	testcontainers.CleanupContainer(t, targetContainer)
+	require.NoError(t, err)
+
+	sourceConnStr, err := sourceContainer.ConnectionString(ctx, "sslmode=disable")
+	require.NoError(t, err)
+
+	targetConnStr, err := targetContainer.ConnectionString(ctx, "sslmode=disable")
+	require.NoError(t, err)
+
+	helpers.RunMigration(t, ctx, sourceConnStr, targetConnStr, 1, true, true)
+
+	targetConn, err := pgx.Connect(ctx, targetConnStr)
+	require.NoError(t, err)
+	defer targetConn.Close(ctx)
+
+	// An extra row on the target makes the row count gate fail
+	_, err = targetConn.Exec(ctx, "INSERT INTO users (id, name, email) VALUES (1000, 'Stray User', 'stray@example.com')")
+	require.NoError(t, err)
+
+	var lastValueBefore int64
+	require.NoError(t, targetConn.QueryRow(ctx, "SELECT last_value FROM users_id_seq").Scan(&lastValueBefore))
+
+	cfg := &config.Config{
+		SourceDatabaseURL: sourceConnStr,
+		TargetDatabaseURL: targetConnStr,
+		PublicationName:   "postgres_migrator",
+		SubscriptionName:  "postgres_migrator",
+		CutoverTimeout:    time.Minute,
+	}
+	logger := logging.Discard()
+	err = migration.Cutover(ctx, cfg, logger)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "rolled back")
+
+	sourceConn, err := pgx.Connect(ctx, sourceConnStr)
+	require.NoError(t, err)
+	defer sourceConn.Close(ctx)
+
+	_, err = sourceConn.Exec(ctx, "INSERT INTO users (name, email) VALUES ('Writable User', 'writable@example.com')")
+	require.NoError(t, err, "Source should accept writes again after a rollback")
+
+	var lastValueAfter int64
+	require.NoError(t, targetConn.QueryRow(ctx, "SELECT last_value FROM users_id_seq").Scan(&lastValueAfter))
+	require.Equal(t, lastValueBefore, lastValueAfter, "Target sequences should be restored after a rollback")
+}
+
+func TestTableFilters(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	sourceContainer, err := postgres.Run(
+		ctx,
+		getPostgresImage(getDefaultPostgresVersion()),
+		postgres.WithDatabase("sourcedb"),
+		postgres.WithUsername("user"),
+		postgres.WithPassword("password"),
+		postgres.WithInitScripts("testdata/init-source.sql"),
+		testcontainers.WithWaitStrategy(
+			wait.ForLog("database system is ready to accept connections").WithOccurrence(2),
+			wait.ForListeningPort("5432/tcp"),
+		),
+	)
+	testcontainers.CleanupContainer(t, sourceContainer)
+	require.NoError(t, err)
+
+	targetContainer, err := postgres.Run(
+		ctx,
+		getPostgresImage(getDefaultPostgresVersion()),
+		postgres.WithDatabase("targetdb"),
+		postgres.WithUsername("user"),
+		postgres.WithPassword("password"),
+		testcontainers.WithWaitStrategy(
+			wait.ForLog("database system is ready to accept connections").WithOccurrence(2),
+			wait.ForListeningPort("5432/tcp"),
+		),
+	)
+	testcontainers.CleanupContainer(t, targetContainer)
+	require.NoError(t, err)
+
+	sourceConnStr, err := sourceContainer.ConnectionString(ctx, "sslmode=disable")
+	require.NoError(t, err)
+
+	targetConnStr, err := targetContainer.ConnectionString(ctx, "sslmode=disable")
+	require.NoError(t, err)
+
+	helpers.RunMigrationWithOptions(t, ctx, sourceConnStr, targetConnStr, helpers.MigrationOptions{
+		NoOwner:          true,
+		NoACL:            true,
+		ExcludeTableData: []string{"pos*"},
+	})
+
+	targetConn, err := pgx.Connect(ctx, targetConnStr)
+	require.NoError(t, err)
+	defer targetConn.Close(ctx)
+
+	var userCount, postCount int
+	require.NoError(t, targetConn.QueryRow(ctx, "SELECT COUNT(*) FROM users").Scan(&userCount))
+	require.NoError(t, targetConn.QueryRow(ctx, "SELECT COUNT(*) FROM posts").Scan(&postCount))
+	require.Greater(t, userCount, 0, "users data should be migrated")
+	require.Equal(t, 0, postCount, "posts should be migrated without data")
+
+	logger := logging.Discard()
+	filter := validation.TableFilter{ExcludeData: []string{"pos*"}}
+	require.NoError(t, validation.ValidateAllTablesFromURLsWithFilter(ctx, sourceConnStr, targetConnStr, filter, logger))
+	require.Error(t, validation.ValidateAllTablesFromURLs(ctx, sourceConnStr, targetConnStr, logger),
+		"Validation without the filter should report the missing posts rows")
+
+	onlyUsers := validation.TableFilter{Include: []string{"public.users"}}
+	require.NoError(t, validation.ValidateAllTablesFromURLsWithFilter(ctx, sourceConnStr, targetConnStr, onlyUsers, logger))
+}
+
+func TestSubsetMigration(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	sourceContainer, err := postgres.Run(
+		ctx,
+		getPostgresImage(getDefaultPostgresVersion()),
+		postgres.WithDatabase("sourcedb"),
+		postgres.WithUsername("user"),
+		postgres.WithPassword("password"),
+		postgres.WithInitScripts("testdata/init-source.sql"),
+		testcontainers.WithWaitStrategy(
+			wait.ForLog("database system is ready to accept connections").WithOccurrence(2),
+			wait.ForListeningPort("5432/tcp"),
+		),
+	)
+	testcontainers.CleanupContainer(t, sourceContainer)
+	require.NoError(t, err)
+
+	targetContainer, err := postgres.Run(
+		ctx,
+		getPostgresImage(getDefaultPostgresVersion()),
+		postgres.WithDatabase("targetdb"),
+		postgres.WithUsername("user"),
+		postgres.WithPassword("password"),
+		testcontainers.WithWaitStrategy(
+			wait.ForLog("database system is ready to accept connections").WithOccurrence(2),
+			wait.ForListeningPort("5432/tcp"),
+		),
+	)
+	testcontainers.CleanupContainer(t, targetContainer)
+	require.NoError(t, err)
+
+	sourceConnStr, err := sourceContainer.ConnectionString(ctx, "sslmode=disable")
+	require.NoError(t, err)
+
+	targetConnStr, err := targetContainer.ConnectionString(ctx, "sslmode=disable")
+	require.NoError(t, err)
+
+	// Comments are partitioned by date and reactions reference the partitioned table, so
+	// both directions of a foreign key through partitions are followed
+	sourceConn, err := pgx.Connect(ctx, sourceConnStr)
+	require.NoError(t, err)
+	defer sourceConn.Close(ctx)
+	_, err = sourceConn.Exec(ctx, `
+		CREATE TABLE comments (
+			id INTEGER NOT NULL,
+			post_id INTEGER NOT NULL REFERENCES posts(id),
+			created_on DATE NOT NULL,
+			PRIMARY KEY (id, created_on)
+		) PARTITION BY RANGE (created_on);
+		CREATE TABLE comments_2023 PARTITION OF comments FOR VALUES FROM ('2023-01-01') TO ('2024-01-01');
+		CREATE TABLE comments_2024 PARTITION OF comments FOR VALUES FROM ('2024-01-01') TO ('2025-01-01');
+		CREATE TABLE reactions (
+			id INTEGER PRIMARY KEY,
+			comment_id INTEGER NOT NULL,
+			comment_on DATE NOT NULL,
+			FOREIGN KEY (comment_id, comment_on) REFERENCES comments (id, created_on)
+		);
+		INSERT INTO comments VALUES (1, 1, '2023-05-01'), (2, 3, '2024-02-01'), (3, 4, '2024-03-01');
+		INSERT INTO reactions VALUES (1, 1, '2023-05-01'), (2, 2, '2024-02-01'), (3, 3, '2024-03-01');
+	`)
+	require.NoError(t, err)
+
+	// Bob's post pulls in Bob as its author; Alice pulls in her posts
+	subsetFile := filepath.Join(t.TempDir(), "subset.json")
+	require.NoError(t, os.WriteFile(subsetFile, []byte(`{
+		"roots": [
+			{"table": "public.posts", "where": "title = 'Bob Introduction'"},
+			{"table": "users", "where": "name = 'Alice'"}
+		]
+	}`), 0o644))
+
+	helpers.RunMigrationWithOptions(t, ctx, sourceConnStr, targetConnStr, helpers.MigrationOptions{
+		NoOwner:    true,
+		NoACL:      true,
+		SubsetFile: subsetFile,
+	})
+
+	targetConn, err := pgx.Connect(ctx, targetConnStr)
+	require.NoError(t, err)
+	defer targetConn.Close(ctx)
+
+	rows, err := targetConn.Query(ctx, "SELECT name FROM users ORDER BY id")
+	require.NoError(t, err)
+	names, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	require.NoError(t, err)
+	require.Equal(t, []string{"Alice", "Bob"}, names)
+
+	var postCount, orphanCount int
+	require.NoError(t, targetConn.QueryRow(ctx, "SELECT COUNT(*) FROM posts").Scan(&postCount))
+	require.Equal(t, 3, postCount, "Alice's two posts and Bob's post should be copied")
+
+	err = targetConn.QueryRow(ctx, "SELECT COUNT(*) FROM posts p LEFT JOIN users u ON u.id = p.user_id WHERE u.id IS NULL").Scan(&orphanCount)
+	require.NoError(t, err)
+	require.Equal(t, 0, orphanCount, "Every copied post should reference a copied user")
+
+	rows, err = targetConn.Query(ctx, "SELECT tableoid::regclass::text || ':' || id FROM comments ORDER BY id")
+	require.NoError(t, err)
+	comments, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	require.NoError(t, err)
+	require.Equal(t, []string{"comments_2023:1", "comments_2024:2"}, comments, "Comments on copied posts should be copied from every partition")
+
+	rows, err = targetConn.Query(ctx, "SELECT id FROM reactions ORDER BY id")
+	require.NoError(t, err)
+	reactions, err := pgx.CollectRows(rows, pgx.RowTo[int])
+	require.NoError(t, err)
+	require.Equal(t, []int{1, 2}, reactions, "Reactions should follow the key to the partitioned comments")
+
+	err = targetConn.QueryRow(ctx, `
+		SELECT COUNT(*) FROM reactions r
+		LEFT JOIN comments c ON c.id = r.comment_id AND c.created_on = r.comment_on
+		WHERE c.id IS NULL`).Scan(&orphanCount)
+	require.NoError(t, err)
+	require.Equal(t, 0, orphanCount, "Every copied reaction should reference a copied comment")
+}
+
+func TestMaskedMigration(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	sourceContainer, err := postgres.Run(
+		ctx,
+		getPostgresImage(getDefaultPostgresVersion()),
+		postgres.WithDatabase("sourcedb"),
+		postgres.WithUsername("user"),
+		postgres.WithPassword("password"),
+		postgres.WithInitScripts("testdata/init-source.sql"),
+		testcontainers.WithWaitStrategy(
+			wait.ForLog("database system is ready to accept connections").WithOccurrence(2),
+			wait.ForListeningPort("5432/tcp"),
+		),
+	)
+	testcontainers.CleanupContainer(t, sourceContainer)
+	require.NoError(t, err)
+
+	targetContainer, err := postgres.Run(
+		ctx,
+		getPostgresImage(getDefaultPostgresVersion()),
+		postgres.WithDatabase("targetdb"),
+		postgres.WithUsername("user"),
+		postgres.WithPassword("password"),
+		testcontainers.WithWaitStrategy(
+			wait.ForLog("database system is ready to accept connections").WithOccurrence(2),
+			wait.ForListeningPort("5432/tcp"),
+		),
+	)
+	testcontainers.CleanupContainer(t, targetContainer)
+	require.NoError(t, err)
+
+	sourceConnStr, err := sourceContainer.ConnectionString(ctx, "sslmode=disable")
+	require.NoError(t, err)
+
+	targetConnStr, err := targetContainer.ConnectionString(ctx, "sslmode=disable")
+	require.NoError(t, err)
+
+	maskingFile := filepath.Join(t.TempDir(), "masking.json")
+	require.NoError(t, os.WriteFile(maskingFile, []byte(`{
+		"salt": "test-salt",
+		"columns": {
+			"users.email": {"transformer": "fake_email"},
+			"public.users.name": {"transformer": "keep_format"},
+			"public.users.created_at": {"transformer": "fixed", "value": "2000-01-01 00:00:00"},
+			"public.posts.content": {"transformer": "null"},
+			"public.posts.created_at": {"transformer": "shift_dates", "days": 10}
+		}
+	}`), 0o644))
+
+	typoFile := filepath.Join(t.TempDir(), "masking-typo.json")
+	require.NoError(t, os.WriteFile(typoFile, []byte(`{
+		"columns": {
+			"users.email": {"transformer": "fake_email"},
+			"public.users.Name": {"transformer": "keep_format"}
+		}
+	}`), 0o644))
+	helpers.RunMigrationWithOptionsExpectError(t, ctx, sourceConnStr, targetConnStr, helpers.MigrationOptions{
+		NoOwner:     true,
+		NoACL:       true,
+		MaskingFile: typoFile,
+	}, "masking rules match no copied column, names are case-sensitive and unquoted: public.users.Name")
+
+	helpers.RunMigrationWithOptions(t, ctx, sourceConnStr, targetConnStr, helpers.MigrationOptions{
+		NoOwner:     true,
+		NoACL:       true,
+		MaskingFile: maskingFile,
+	})
+
+	sourceConn, err := pgx.Connect(ctx, sourceConnStr)
+	require.NoError(t, err)
+	defer sourceConn.Close(ctx)
+
+	targetConn, err := pgx.Connect(ctx, targetConnStr)
+	require.NoError(t, err)
+	defer targetConn.Close(ctx)
+
+	rows, err := targetConn.Query(ctx, "SELECT name, email, created_at FROM users ORDER BY id")
+	require.NoError(t, err)
+	defer rows.Close()
+
+	sourceNames := []string{"Alice", "Bob", "Charlie"}
+	i := 0
+	for rows.Next() {
+		var name, email string
+		var createdAt time.Time
+		require.NoError(t, rows.Scan(&name, &email, &createdAt))
+
+		require.NotEqual(t, sourceNames[i], name, "Names should be masked")
+		require.Len(t, name, len(sourceNames[i]), "keep_format should keep the length")
+		require.Regexp(t, `^[A-Z][a-z]+$`, name, "keep_format should keep the case pattern")
+		require.Regexp(t, `^user_[0-9a-f]{32}@example\.com$`, email)
+		require.Equal(t, time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC), createdAt.UTC())
+		i++
+	}
+	require.NoError(t, rows.Err())
+	require.Equal(t, 3, i)
+
+	var nonNullContent int
+	require.NoError(t, targetConn.QueryRow(ctx, "SELECT COUNT(*) FROM posts WHERE content IS NOT NULL").Scan(&nonNullContent))
+	require.Equal(t, 0, nonNullContent, "Post content should be nulled")
+
+	var sourceCreated, targetCreated time.Time
+	require.NoError(t, sourceConn.QueryRow(ctx, "SELECT created_at FROM posts ORDER BY id LIMIT 1").Scan(&sourceCreated))
+	require.NoError(t, targetConn.QueryRow(ctx, "SELECT created_at FROM posts ORDER BY id LIMIT 1").Scan(&targetCreated))
+	shift := targetCreated.Sub(sourceCreated)
+	require.LessOrEqual(t, shift.Abs(), 10*24*time.Hour, "Dates should shift by at most 10 days")
+	require.Zero(t, shift%(24*time.Hour), "Dates should shift by whole days")
+
+	var emailMatches int
+	require.NoError(t, targetConn.QueryRow(ctx, "SELECT COUNT(*) FROM users WHERE email LIKE '%@example.com' AND email NOT LIKE 'user\\_%'").Scan(&emailMatches))
+	require.Equal(t, 0, emailMatches, "No source email should reach the target")
+
+	cfg := &config.Config{MaskingFile: maskingFile}
+	filter, err := migration.ValidationFilter(cfg)
+	require.NoError(t, err)
+
+	logger := logging.Discard()
+	err = validation.ValidateAllTablesFromURLsWithFilter(ctx, sourceConnStr, targetConnStr, filter, logger)
+	require.NoError(t, err, "Validation should pass with masked columns skipped")
+}
+
+func TestMaskedMigrationDateStyle(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	sourceContainer, err := postgres.Run(
+		ctx,
+		getPostgresImage(getDefaultPostgresVersion()),
+		postgres.WithDatabase("sourcedb"),
+		postgres.WithUsername("user"),
+		postgres.WithPassword("password"),
+		testcontainers.WithWaitStrategy(
+			wait.ForLog("database system is ready to accept connections").WithOccurrence(2),
+			wait.ForListeningPort("5432/tcp"),
+		),
+	)
+	testcontainers.CleanupContainer(t, sourceContainer)
+	require.NoError(t, err)
+
+	targetContainer, err := postgres.Run(
+		ctx,
+		getPostgresImage(getDefaultPostgresVersion()),
+		postgres.WithDatabase("targetdb"),
+		postgres.WithUsername("user"),
+		postgres.WithPassword("password"),
+		testcontainers.WithWaitStrategy(
+			wait.ForLog("database system is ready to accept connections").WithOccurrence(2),
+			wait.ForListeningPort("5432/tcp"),
+		),
+	)
+	testcontainers.CleanupContainer(t, targetContainer)
+	require.NoError(t, err)
+
+	sourceConnStr, err := sourceContainer.ConnectionString(ctx, "sslmode=disable")
+	require.NoError(t, err)
+
+	targetConnStr, err := targetContainer.ConnectionString(ctx, "sslmode=disable")
+	require.NoError(t, err)
+
+	// Sessions on the source default to 15/03/2020 style dates, and on the target to
+	// 15.03.2020, neither of which starts with YYYY-MM-DD
+	sourceConn, err := pgx.Connect(ctx, sourceConnStr)
+	require.NoError(t, err)
+	defer sourceConn.Close(ctx)
+	_, err = sourceConn.Exec(ctx, `
+		ALTER DATABASE sourcedb SET DateStyle = 'SQL, DMY';
+		ALTER DATABASE sourcedb SET IntervalStyle = 'sql_standard';
+		CREATE TABLE events (
+			id SERIAL PRIMARY KEY,
+			happened_on DATE NOT NULL,
+			happened_at TIMESTAMP NOT NULL,
+			note TEXT NOT NULL
+		);
+		INSERT INTO events (happened_on, happened_at, note)
+		SELECT DATE '2020-03-15' + g, TIMESTAMP '2020-03-15 10:30:00' + g * INTERVAL '1 day', 'next week'
+		FROM generate_series(1, 50) g;
+		INSERT INTO events (happened_on, happened_at, note) VALUES ('infinity', '-infinity', 'never');
+	`)
+	require.NoError(t, err)
+
+	targetConn, err := pgx.Connect(ctx, targetConnStr)
+	require.NoError(t, err)
+	defer targetConn.Close(ctx)
+	_, err = targetConn.Exec(ctx, "ALTER DATABASE targetdb SET DateStyle = 'German'")
+	require.NoError(t, err)
+	_, err = targetConn.Exec(ctx, "CREATE DATABASE textdb")
+	require.NoError(t, err)
+
+	// A text column holding something other than an ISO date must not be copied as is
+	textFile := filepath.Join(t.TempDir(), "masking-text.json")
+	require.NoError(t, os.WriteFile(textFile, []byte(`{
+		"columns": {"events.note": {"transformer": "shift_dates"}}
+	}`), 0o644))
+	textConnStr := strings.Replace(targetConnStr, "/targetdb", "/textdb", 1)
+	helpers.RunMigrationWithOptionsExpectError(t, ctx, sourceConnStr, textConnStr, helpers.MigrationOptions{
+		NoOwner:     true,
+		NoACL:       true,
+		MaskingFile: textFile,
+	}, "failed to mask public.events.note: shift_dates needs a value starting with a YYYY-MM-DD date")
+
+	maskingFile := filepath.Join(t.TempDir(), "masking.json")
+	require.NoError(t, os.WriteFile(maskingFile, []byte(`{
+		"salt": "test-salt",
+		"columns": {
+			"events.happened_on": {"transformer": "shift_dates", "days": 10},
+			"events.happened_at": {"transformer": "shift_dates", "days": 10}
+		}
+	}`), 0o644))
+	helpers.RunMigrationWithOptions(t, ctx, sourceConnStr, targetConnStr, helpers.MigrationOptions{
+		NoOwner:     true,
+		NoACL:       true,
+		MaskingFile: maskingFile,
+	})
+
+	type event struct {
+		on pgtype.Date
+		at pgtype.Timestamp
+	}
+	read := func(conn *pgx.Conn) map[int]event {
+		rows, err := conn.Query(ctx, "SELECT id, happened_on, happened_at FROM events")
+		require.NoError(t, err)
+		defer rows.Close()
+		events := make(map[int]event)
+		for rows.Next() {
+			var id int
+			var e event
+			require.NoError(t, rows.Scan(&id, &e.on, &e.at))
+			events[id] = e
+		}
+		require.NoError(t, rows.Err())
+		return events
+	}
+	source := read(sourceConn)
+	target := read(targetConn)
+	require.Len(t, target, len(source))
+
+	shifted := 0
+	for id, want := range source {
+		got := target[id]
+		if want.on.InfinityModifier != pgtype.Finite {
+			require.Equal(t, pgtype.Infinity, got.on.InfinityModifier, "infinity should be kept")
+			require.Equal(t, pgtype.NegativeInfinity, got.at.InfinityModifier, "-infinity should be kept")
+			continue
+		}
+		for _, shift := range []time.Duration{got.on.Time.Sub(want.on.Time), got.at.Time.Sub(want.at.Time)} {
+			require.LessOrEqual(t, shift.Abs(), 10*24*time.Hour, "Dates should shift by at most 10 days")
+			require.Zero(t, shift%(24*time.Hour), "Dates should shift by whole days, keeping the time of day")
+			if shift != 0 {
+				shifted++
+			}
+		}
+	}
+	require.Greater(t, shifted, 0, "Dates in a non-ISO DateStyle should still be masked")
+}
+
+func TestCopyEngine(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	sourceContainer, err := postgres.Run(
+		ctx,
+		getPostgresImage(getDefaultPostgresVersion()),
+		postgres.WithDatabase("sourcedb"),
+		postgres.WithUsername("user"),
+		postgres.WithPassword("password"),
+		postgres.WithInitScripts("testdata/init-source.sql"),
+		testcontainers.WithWaitStrategy(
+			wait.ForLog("database system is ready to accept connections").WithOccurrence(2),
+			wait.ForListeningPort("5432/tcp"),
+		),
+	)
+	testcontainers.CleanupContainer(t, sourceContainer)
+	require.NoError(t, err)
+
+	targetContainer, err := postgres.Run(
+		ctx,
+		getPostgresImage(getDefaultPostgresVersion()),
+		postgres.WithDatabase("targetdb"),
+		postgres.WithUsername("user"),
+		postgres.WithPassword("password"),
+		testcontainers.WithWaitStrategy(
+			wait.ForLog("database system is ready to accept connections").WithOccurrence(2),
+			wait.ForListeningPort("5432/tcp"),
+		),
+	)
+	testcontainers.CleanupContainer(t, targetContainer)
+	require.NoError(t, err)
+
+	sourceConnStr, err := sourceContainer.ConnectionString(ctx, "sslmode=disable")
+	require.NoError(t, err)
+
+	targetConnStr, err := targetContainer.ConnectionString(ctx, "sslmode=disable")
+	require.NoError(t, err)
+
+	helpers.RunMigrationWithOptions(t, ctx, sourceConnStr, targetConnStr, helpers.MigrationOptions{
+		NoOwner:      true,
+		NoACL:        true,
+		ParallelJobs: 2,
+		Engine:       config.EngineCopy,
+	})
+
+	targetConn, err := pgx.Connect(ctx, targetConnStr)
+	require.NoError(t, err)
+	defer targetConn.Close(ctx)
+
+	helpers.ValidateBasicMigration(t, ctx, targetConn)
+
+	logger := logging.Discard()
+	err = validation.ValidateAllTablesFromURLs(ctx, sourceConnStr, targetConnStr, logger)
+	require.NoError(t, err)
+
+	// Post-data ran after the copy, so the unique index rejects duplicates and the
+	// sequence continues after the copied ids
+	_, err = targetConn.Exec(ctx, "INSERT INTO users (name, email) VALUES ('Dup', 'alice@example.com')")
+	require.Error(t, err, "Unique constraint should exist after the copy")
+
+	var id int
+	require.NoError(t, targetConn.QueryRow(ctx, "INSERT INTO users (name, email) VALUES ('Dave', 'dave@example.com') RETURNING id").Scan(&id))
+	require.Equal(t, 4, id, "Sequence should continue after the copied rows")
+}
+
+func TestChunkedCopy(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	sourceContainer, err := postgres.Run(
+		ctx,
+		getPostgresImage(getDefaultPostgresVersion()),
+		postgres.WithDatabase("sourcedb"),
+		postgres.WithUsername("user"),
+		postgres.WithPassword("password"),
+		postgres.WithInitScripts("testdata/init-large-dataset.sql"),
+		testcontainers.WithWaitStrategy(
+			wait.ForLog("database system is ready to accept connections").WithOccurrence(2),
+			wait.ForListeningPort("5432/tcp"),
+		),
+	)
+	testcontainers.CleanupContainer(t, sourceContainer)
+	require.NoError(t, err)
+
+	targetContainer, err := postgres.Run(
+		ctx,
+		getPostgresImage(getDefaultPostgresVersion()),
+		postgres.WithDatabase("targetdb"),
+		postgres.WithUsername("user"),
+		postgres.WithPassword("password"),
+		testcontainers.WithWaitStrategy(
+			wait.ForLog("database system is ready to accept connections").WithOccurrence(2),
+			wait.ForListeningPort("5432/tcp"),
+		),
+	)
+	testcontainers.CleanupContainer(t, targetContainer)
+	require.NoError(t, err)
+
+	sourceConnStr, err := sourceContainer.ConnectionString(ctx, "sslmode=disable")
+	require.NoError(t, err)
+
+	targetConnStr, err := targetContainer.ConnectionString(ctx, "sslmode=disable")
+	require.NoError(t, err)
+
+	sourceConn, err := pgx.Connect(ctx, sourceConnStr)
+	require.NoError(t, err)
+	defer sourceConn.Close(ctx)
+
+	// A copy without a primary key is split by ctid ranges instead of key ranges, and
+	// statistics are needed for the row estimates the chunks are sized by
+	_, err = sourceConn.Exec(ctx, "CREATE TABLE random_heap AS SELECT * FROM random_data")
+	require.NoError(t, err)
+	_, err = sourceConn.Exec(ctx, "ANALYZE")
+	require.NoError(t, err)
+
+	// Chunks are sized from the row estimates, so both tables split into the same number
+	expectedChunks := make(map[string]int)
+	rows, err := sourceConn.Query(ctx, `
+		SELECT format('"%s"."%s"', n.nspname, c.relname), ceil(GREATEST(c.reltuples, 0)::bigint / 100000.0)::int
+		FROM pg_class c
+		JOIN pg_namespace n ON n.oid = c.relnamespace
+		WHERE c.relname IN ('random_data', 'random_heap')`)
+	require.NoError(t, err)
+	for rows.Next() {
+		var name string
+		var chunks int
+		require.NoError(t, rows.Scan(&name, &chunks))
+		expectedChunks[name] = chunks
+	}
+	require.NoError(t, rows.Err())
+	require.Len(t, expectedChunks, 2)
+
+	var logs bytes.Buffer
+	cfg := &config.Config{
+		SourceDatabaseURL: sourceConnStr,
+		TargetDatabaseURL: targetConnStr,
+		ParallelJobs:      4,
+		NoOwner:           true,
+		NoACL:             true,
+		Engine:            config.EngineCopy,
+		ChunkRows:         100000,
+		ChunkRetries:      1,
+	}
+	_, err = migration.Run(ctx, cfg, logging.New(&logs, &config.Config{LogFormat: config.LogFormatJSON}))
+	require.NoError(t, err)
+
+	plannedChunks := make(map[string]int)
+	copiedChunks := make(map[string]int)
+	for _, line := range bytes.Split(bytes.TrimSpace(logs.Bytes()), []byte("\n")) {
+		var record map[string]any
+		require.NoError(t, json.Unmarshal(line, &record))
+		switch record["msg"] {
+		case "table split into chunks":
+			plannedChunks[record[logging.KeyTable].(string)] = int(record["chunks"].(float64))
+		case "chunk copied":
+			copiedChunks[record[logging.KeyTable].(string)]++
+		}
+	}
+	for name, chunks := range expectedChunks {
+		require.Greater(t, chunks, 1, "%s should be large enough to be split", name)
+	}
+	require.Equal(t, expectedChunks, plannedChunks, "Each table should be split by its row estimate")
+	require.Equal(t, expectedChunks, copiedChunks, "Every planned chunk should be copied once")
+
+	targetConn, err := pgx.Connect(ctx, targetConnStr)
+	require.NoError(t, err)
+	defer targetConn.Close(ctx)
+
+	logger := logging.Discard()
+	err = validation.ValidateAllTablesFromURLs(ctx, sourceConnStr, targetConnStr, logger)
+	require.NoError(t, err)
+
+	helpers.ValidateIDsInRange(t, ctx, sourceConn, targetConn, "random_data", 1, 1000000)
+
+	var heapRows, distinctIDs int
+	err = targetConn.QueryRow(ctx, "SELECT COUNT(*), COUNT(DISTINCT id) FROM random_heap").Scan(&heapRows, &distinctIDs)
+	require.NoError(t, err)
+	require.Equal(t, 1000000, heapRows, "Every row of the table without a key should be copied")
+	require.Equal(t, 1000000, distinctIDs, "No ctid range should be copied twice")
+}
+
+func TestChunkedCopyRetry(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	sourceContainer, err := postgres.Run(
+		ctx,
+		getPostgresImage(getDefaultPostgresVersion()),
+		postgres.WithDatabase("sourcedb"),
+		postgres.WithUsername("user"),
+		postgres.WithPassword("password"),
+		postgres.WithInitScripts("testdata/init-large-dataset.sql"),
+		testcontainers.WithWaitStrategy(
+			wait.ForLog("database system is ready to accept connections").WithOccurrence(2),
+			wait.ForListeningPort("5432/tcp"),
+		),
+	)
+	testcontainers.CleanupContainer(t, sourceContainer)
+	require.NoError(t, err)
+
+	targetContainer, err := postgres.Run(
+		ctx,
+		getPostgresImage(getDefaultPostgresVersion()),
+		postgres.WithDatabase("targetdb"),
+		postgres.WithUsername("user"),
+		postgres.WithPassword("password"),
+		testcontainers.WithWaitStrategy(
+			wait.ForLog("database system is ready to accept connections").WithOccurrence(2),
+			wait.ForListeningPort("5432/tcp"),
+		),
+	)
+	testcontainers.CleanupContainer(t, targetContainer)
+	require.NoError(t, err)
+
+	sourceConnStr, err := sourceContainer.ConnectionString(ctx, "sslmode=disable")
+	require.NoError(t, err)
+
+	targetConnStr, err := targetContainer.ConnectionString(ctx, "sslmode=disable")
+	require.NoError(t, err)
+
+	sourceConn, err := pgx.Connect(ctx, sourceConnStr)
+	require.NoError(t, err)
+	defer sourceConn.Close(ctx)
+
+	_, err = sourceConn.Exec(ctx, "ANALYZE")
+	require.NoError(t, err)
+
+	targetConn, err := pgx.Connect(ctx, targetConnStr)
+	require.NoError(t, err)
+	defer targetConn.Close(ctx)
+
+	// The chunk holding id 500000 fails on its first attempt only: the sequence is not
+	// rolled back with the failed COPY, and the trigger fires under the replica role
+	// DATA_ONLY copies run with
+	_, err = targetConn.Exec(ctx, `
+		CREATE TABLE random_data (
+			id SERIAL PRIMARY KEY,
+			name VARCHAR(100),
+			email VARCHAR(100),
+			age INT,
+			salary DECIMAL(10, 2),
+			created_at TIMESTAMP
+		);
+		CREATE SEQUENCE chunk_failures;
+		CREATE FUNCTION fail_chunk_once() RETURNS trigger AS $$
+		BEGIN
+			IF NEW.id = 500000 AND nextval('chunk_failures') = 1 THEN
+				RAISE EXCEPTION 'forced chunk failure';
+			END IF;
+			RETURN NEW;
+		END;
+		$$ LANGUAGE plpgsql;
+		CREATE TRIGGER fail_chunk_once BEFORE INSERT ON random_data
+			FOR EACH ROW EXECUTE FUNCTION fail_chunk_once();
+		ALTER TABLE random_data ENABLE ALWAYS TRIGGER fail_chunk_once;
+	`)
+	require.NoError(t, err)
+
+	var logs bytes.Buffer
+	cfg := &config.Config{
+		SourceDatabaseURL: sourceConnStr,
+		TargetDatabaseURL: targetConnStr,
+		ParallelJobs:      4,
+		DataOnly:          true,
+		Engine:            config.EngineCopy,
+		ChunkRows:         100000,
+		ChunkRetries:      1,
+	}
+	_, err = migration.Run(ctx, cfg, logging.New(&logs, &config.Config{LogFormat: config.LogFormatJSON}))
+	require.NoError(t, err, "The failed chunk should succeed on its retry")
+
+	var retries []map[string]any
+	for _, line := range bytes.Split(bytes.TrimSpace(logs.Bytes()), []byte("\n")) {
+		var record map[string]any
+		require.NoError(t, json.Unmarshal(line, &record))
+		if record["msg"] == "chunk copy failed, retrying" {
+			retries = append(retries, record)
+		}
+	}
+	require.Len(t, retries, 1, "Only the chunk holding id 500000 should be retried")
+	require.Equal(t, `"public"."random_data"`, retries[0][logging.KeyTable])
+	require.Equal(t, 1.0, retries[0]["attempt"])
+	require.Contains(t, retries[0][logging.KeyError], "forced chunk failure")
+
+	var rowCount, distinctIDs int
+	err = targetConn.QueryRow(ctx, "SELECT COUNT(*), COUNT(DISTINCT id) FROM random_data").Scan(&rowCount, &distinctIDs)
+	require.NoError(t, err)
+	require.Equal(t, 1000000, rowCount, "The retried chunk should not leave duplicate rows")
+	require.Equal(t, 1000000, distinctIDs)
+
+	helpers.ValidateIDsInRange(t, ctx, sourceConn, targetConn, "random_data", 1, 1000000)
+}
+
+func TestProgressEvents(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	sourceContainer, err := postgres.Run(
+		ctx,
+		getPostgresImage(getDefaultPostgresVersion()),
+		postgres.WithDatabase("sourcedb"),
+		postgres.WithUsername("user"),
+		postgres.WithPassword("password"),
+		postgres.WithInitScripts("testdata/init-source.sql"),
+		testcontainers.WithWaitStrategy(
+			wait.ForLog("database system is ready to accept connections").WithOccurrence(2),
+			wait.ForListeningPort("5432/tcp"),
+		),
+	)
+	testcontainers.CleanupContainer(t, sourceContainer)
+	require.NoError(t, err)
+
+	targetContainer, err := postgres.Run(
+		ctx,
+		getPostgresImage(getDefaultPostgresVersion()),
+		postgres.WithDatabase("targetdb"),
+		postgres.WithUsername("user"),
+		postgres.WithPassword("password"),
+		testcontainers.WithWaitStrategy(
+			wait.ForLog("database system is ready to accept connections").WithOccurrence(2),
+			wait.ForListeningPort("5432/tcp"),
+		),
+	)
+	testcontainers.CleanupContainer(t, targetContainer)
+	require.NoError(t, err)
+
+	sourceConnStr, err := sourceContainer.ConnectionString(ctx, "sslmode=disable")
+	require.NoError(t, err)
+
+	targetConnStr, err := targetContainer.ConnectionString(ctx, "sslmode=disable")
+	require.NoError(t, err)
+
+	var output bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&output, nil))
+	cfg := &config.Config{
+		SourceDatabaseURL: sourceConnStr,
+		TargetDatabaseURL: targetConnStr,
+		ParallelJobs:      1,
+		NoOwner:           true,
+		NoACL:             true,
+	}
+	_, err = migration.Run(ctx, cfg, logger)
+	require.NoError(t, err)
+
+	var events []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(output.String()), "\n") {
+		var record map[string]any
+		require.NoError(t, json.Unmarshal([]byte(line), &record), "Every log line should be a JSON record")
+		require.Contains(t, record, "phase", "Every record of the run should carry its phase: %s", line)
+		require.Equal(t, 1, strings.Count(line, `"phase":`), "A record should carry a single phase: %s", line)
+		if record["msg"] == "progress" {
+			events = append(events, record)
+		}
+	}
+	find := func(tool, event, objectPrefix string) map[string]any {
+		for _, record := range events {
+			object, _ := record["object"].(string)
+			if record["tool"] == tool && record["event"] == event && strings.HasPrefix(object, objectPrefix) {
+				return record
+			}
+		}
+		t.Fatalf("no %s event %q for %s", tool, event, objectPrefix)
+		return nil
+	}
+
+	started := find("pg_dump", "table data started", "public.users")
+	require.Equal(t, "dump", started["phase"])
+	require.Equal(t, "public.users", started["table"])
+	require.Contains(t, started, "duration_ms")
+	require.Contains(t, find("pg_dump", "table data finished", "public.posts"), "percent")
+	find("pg_restore", "index created", "public.")
+	constraint := find("pg_restore", "constraint added", "public.users users_pkey")
+	require.Equal(t, "restore", constraint["phase"])
+	require.Equal(t, 100.0, constraint["percent"], "Restore should reach 100% once all table data is loaded")
+}
+
+func TestProgressEventsParallel(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	sourceContainer, err := postgres.Run(
+		ctx,
+		getPostgresImage(getDefaultPostgresVersion()),
+		postgres.WithDatabase("sourcedb"),
+		postgres.WithUsername("user"),
+		postgres.WithPassword("password"),
+		postgres.WithInitScripts("testdata/init-source.sql"),
+		testcontainers.WithWaitStrategy(
+			wait.ForLog("database system is ready to accept connections").WithOccurrence(2),
+			wait.ForListeningPort("5432/tcp"),
+		),
+	)
+	testcontainers.CleanupContainer(t, sourceContainer)
+	require.NoError(t, err)
+
+	targetContainer, err := postgres.Run(
+		ctx,
+		getPostgresImage(getDefaultPostgresVersion()),
+		postgres.WithDatabase("targetdb"),
+		postgres.WithUsername("user"),
+		postgres.WithPassword("password"),
+		testcontainers.WithWaitStrategy(
+			wait.ForLog("database system is ready to accept connections").WithOccurrence(2),
+			wait.ForListeningPort("5432/tcp"),
+		),
+	)
+	testcontainers.CleanupContainer(t, targetContainer)
+	require.NoError(t, err)
+
+	sourceConnStr, err := sourceContainer.ConnectionString(ctx, "sslmode=disable")
+	require.NoError(t, err)
+
+	targetConnStr, err := targetContainer.ConnectionString(ctx, "sslmode=disable")
+	require.NoError(t, err)
+
+	// pg_restore reports finished items by table name only, so tables sharing a name
+	// across schemas must be told apart by their dump ID
+	sourceConn, err := pgx.Connect(ctx, sourceConnStr)
+	require.NoError(t, err)
+	defer sourceConn.Close(ctx)
+	_, err = sourceConn.Exec(ctx, `
+		CREATE SCHEMA archive;
+		CREATE TABLE archive.users AS SELECT g AS id, md5(g::text) AS name FROM generate_series(1, 200000) g;
+		CREATE TABLE archive.posts AS SELECT g AS id, md5(g::text) AS title FROM generate_series(1, 100000) g;
+		ANALYZE;
+	`)
+	require.NoError(t, err)
+
+	var output bytes.Buffer
+	logger := logging.New(&output, &config.Config{LogFormat: config.LogFormatJSON})
+	cfg := &config.Config{
+		SourceDatabaseURL: sourceConnStr,
+		TargetDatabaseURL: targetConnStr,
+		ParallelJobs:      4,
+		NoOwner:           true,
+		NoACL:             true,
+	}
+	_, err = migration.Run(ctx, cfg, logger)
+	require.NoError(t, err)
+
+	started := make(map[string]int)
+	finished := make(map[string]int)
+	var lastPercent float64
+	for _, line := range bytes.Split(bytes.TrimSpace(output.Bytes()), []byte("\n")) {
+		var record map[string]any
+		require.NoError(t, json.Unmarshal(line, &record))
+		if record["msg"] != "progress" || record["tool"] != "pg_restore" {
+			continue
+		}
+		switch record["event"] {
+		case "table data started":
+			started[record[logging.KeyTable].(string)]++
+		case "table data finished":
+			finished[record[logging.KeyTable].(string)]++
+			lastPercent = record["percent"].(float64)
+		}
+	}
+
+	expected := map[string]int{"public.users": 1, "public.posts": 1, "archive.users": 1, "archive.posts": 1}
+	require.Equal(t, expected, started)
+	require.Equal(t, expected, finished, "Each table should finish once, under its own schema")
+	require.Equal(t, 100.0, lastPercent, "Restore should reach 100% once all table data is loaded")
+}
+
+func TestMetrics(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	sourceContainer, err := postgres.Run(
+		ctx,
+		getPostgresImage(getDefaultPostgresVersion()),
+		postgres.WithDatabase("sourcedb"),
+		postgres.WithUsername("user"),
+		postgres.WithPassword("password"),
+		postgres.WithInitScripts("testdata/init-source.sql"),
+		testcontainers.WithWaitStrategy(
+			wait.ForLog("database system is ready to accept connections").WithOccurrence(2),
+			wait.ForListeningPort("5432/tcp"),
+		),
+	)
+	testcontainers.CleanupContainer(t, sourceContainer)
+	require.NoError(t, err)
+
+	targetContainer, err := postgres.Run(
+		ctx,
+		getPostgresImage(getDefaultPostgresVersion()),
+		postgres.WithDatabase("targetdb"),
+		postgres.WithUsername("user"),
+		postgres.WithPassword("password"),
+		testcontainers.WithWaitStrategy(
+			wait.ForLog("database system is ready to accept connections").WithOccurrence(2),
+			wait.ForListeningPort("5432/tcp"),
+		),
+	)
+	testcontainers.CleanupContainer(t, targetContainer)
+	require.NoError(t, err)
+
+	sourceConnStr, err := sourceContainer.ConnectionString(ctx, "sslmode=disable")
+	require.NoError(t, err)
+
+	targetConnStr, err := targetContainer.ConnectionString(ctx, "sslmode=disable")
+	require.NoError(t, err)
+
+	server := httptest.NewServer(metrics.Handler())
+	defer server.Close()
+
+	// A second run in the same process must not show up in this run's series
+	other := metrics.New("metrics-test-other")
+	defer other.Close()
+	other.SetPhase(metrics.PhaseDump)
+	other.TableRestored()
+
+	recorder := metrics.New("metrics-test")
+	defer recorder.Close()
+	runCtx := metrics.NewContext(ctx, recorder)
+
+	var logs bytes.Buffer
+	logger := logging.New(&logs, &config.Config{LogFormat: config.LogFormatJSON})
+	cfg := &config.Config{
+		SourceDatabaseURL: sourceConnStr,
+		TargetDatabaseURL: targetConnStr,
+		ParallelJobs:      1,
+		NoOwner:           true,
+		NoACL:             true,
+	}
+	_, err = migration.Run(runCtx, cfg, logger)
+	require.NoError(t, err)
+	require.NoError(t, validation.ValidateAllTablesFromURLs(runCtx, sourceConnStr, targetConnStr, logging.Discard()))
+
+	var dumpedBytes float64
+	for _, line := range bytes.Split(bytes.TrimSpace(logs.Bytes()), []byte("\n")) {
+		var record map[string]any
+		require.NoError(t, json.Unmarshal(line, &record))
+		if record["msg"] == "dump completed" {
+			dumpedBytes = record[logging.KeyBytes].(float64)
+		}
+	}
+	require.Greater(t, dumpedBytes, 0.0, "The dump size should be logged")
+
+	resp, err := server.Client().Get(server.URL)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	exposition := string(body)
+
+	value := func(name, runID, labels string) float64 {
+		series := name + `{database="",` + labels + `run_id="` + runID + `"}`
+		match := regexp.MustCompile(`(?m)^` + regexp.QuoteMeta(series) + ` (\S+)$`).FindStringSubmatch(exposition)
+		require.NotNil(t, match, "Missing metric %s", series)
+		v, err := strconv.ParseFloat(match[1], 64)
+		require.NoError(t, err)
+		return v
+	}
+	require.Equal(t, dumpedBytes, value("postgres_migrator_dumped_bytes", "metrics-test", ""))
+	require.Greater(t, value("postgres_migrator_dump_duration_seconds", "metrics-test", ""), 0.0)
+	require.Greater(t, value("postgres_migrator_restore_duration_seconds", "metrics-test", ""), 0.0)
+	require.Equal(t, 2.0, value("postgres_migrator_tables_restored_total", "metrics-test", ""), "users and posts should be counted as restored")
+	require.Equal(t, 8.0, value("postgres_migrator_validation_checks_total", "metrics-test", `result="passed",`), "Four checks per table should pass")
+	require.Equal(t, 0.0, value("postgres_migrator_validation_checks_total", "metrics-test", `result="failed",`))
+	require.Equal(t, 0.0, value("postgres_migrator_last_error_timestamp_seconds", "metrics-test", ""))
+	require.Equal(t, 1.0, value("postgres_migrator_phase", "metrics-test", `phase="validate",`), "Validation ran last")
+	require.Len(t, regexp.MustCompile(`(?m)^postgres_migrator_phase\{.*run_id="metrics-test"\}`).FindAllString(exposition, -1), 1, "Only the current phase should be exported")
+
+	require.Equal(t, 1.0, value("postgres_migrator_tables_restored_total", "metrics-test-other", ""))
+	require.Equal(t, 1.0, value("postgres_migrator_phase", "metrics-test-other", `phase="dump",`))
+}
+
+// TestTracing is not parallel, since the tracer provider is global and other tests would
+// export their spans to the same collector
+func TestTracing(t *testing.T) {
+	ctx := context.Background()
+
+	sourceContainer, err := postgres.Run(
+		ctx,
+		getPostgresImage(getDefaultPostgresVersion()),
+		postgres.WithDatabase("sourcedb"),
+		postgres.WithUsername("user"),
+		postgres.WithPassword("password"),
+		postgres.WithInitScripts("testdata/init-source.sql"),
+		testcontainers.WithWaitStrategy(
+			wait.ForLog("database system is ready to accept connections").WithOccurrence(2),
+			wait.ForListeningPort("5432/tcp"),
+		),
+	)
+	testcontainers.CleanupContainer(t, sourceContainer)
+	require.NoError(t, err)
+
+	targetContainer, err := postgres.Run(
+		ctx,
+		getPostgresImage(getDefaultPostgresVersion()),
+		postgres.WithDatabase("targetdb"),
+		postgres.WithUsername("user"),
+		postgres.WithPassword("password"),
+		testcontainers.WithWaitStrategy(
+			wait.ForLog("database system is ready to accept connections").WithOccurrence(2),
+			wait.ForListeningPort("5432/tcp"),
+		),
+	)
+	testcontainers.CleanupContainer(t, targetContainer)
+	require.NoError(t, err)
+
+	sourceConnStr, err := sourceContainer.ConnectionString(ctx, "sslmode=disable")
+	require.NoError(t, err)
+
+	targetConnStr, err := targetContainer.ConnectionString(ctx, "sslmode=disable")
+	require.NoError(t, err)
+
+	collector := helpers.NewTraceCollector(t)
+	shutdown, err := tracing.Setup(ctx, &config.Config{OTLPEndpoint: collector.URL}, "test-run")
+	require.NoError(t, err)
+
+	cfg := &config.Config{
+		SourceDatabaseURL: sourceConnStr,
+		TargetDatabaseURL: targetConnStr,
+		ParallelJobs:      1,
+		NoOwner:           true,
+		NoACL:             true,
+		ValidateAfter:     true,
+	}
+	require.NoError(t, migration.Migrate(ctx, cfg, logging.Discard()))
+	require.NoError(t, shutdown(ctx), "Shutdown should flush the spans to the collector")
+
+	runs := collector.Spans("migration.Run")
+	require.Len(t, runs, 1)
+	run := runs[0]
+	require.Empty(t, run.ParentSpanId, "migration.Run should be the root span")
+
+	for _, name := range []string{"database.ValidateBothConnections", "Dumper.Dump", "Restorer.Restore", "validation.ValidateAllTablesFromURLs"} {
+		spans := collector.Spans(name)
+		require.Len(t, spans, 1, name)
+		require.Equal(t, run.TraceId, spans[0].TraceId, "%s should be in the run's trace", name)
+		require.Equal(t, run.SpanId, spans[0].ParentSpanId, "%s should be a child of migration.Run", name)
+	}
+	dumped, _ := helpers.SpanAttribute(collector.Spans("Dumper.Dump")[0], "bytes").(int64)
+	require.Greater(t, dumped, int64(0), "Dump span should carry the archive size")
+
+	validateAll := collector.Spans("validation.ValidateAllTablesFromURLs")[0]
+	tables := make(map[string]int64)
+	for _, span := range collector.Spans("validation.ValidateTableMigration") {
+		require.Equal(t, run.TraceId, span.TraceId, "Table validation should be in the run's trace")
+		require.Equal(t, validateAll.SpanId, span.ParentSpanId, "Table validation should be a child of validation.ValidateAllTablesFromURLs")
+		rows, _ := helpers.SpanAttribute(span, "rows").(int64)
+		tables[helpers.SpanAttribute(span, "table").(string)] = rows
+	}
+	require.Equal(t, int64(3), tables["users"], "Each table's validation span should carry its row count")
+	require.Contains(t, tables, "posts")
+}
+
+func TestWebhooks(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	sourceContainer, err := postgres.Run(
+		ctx,
+		getPostgresImage(getDefaultPostgresVersion()),
+		postgres.WithDatabase("sourcedb"),
+		postgres.WithUsername("user"),
+		postgres.WithPassword("password"),
+		postgres.WithInitScripts("testdata/init-source.sql"),
+		testcontainers.WithWaitStrategy(
+			wait.ForLog("database system is ready to accept connections").WithOccurrence(2),
+			wait.ForListeningPort("5432/tcp"),
+		),
+	)
+	testcontainers.CleanupContainer(t, sourceContainer)
+	require.NoError(t, err)
+
+	targetContainer, err := postgres.Run(
+		ctx,
+		getPostgresImage(getDefaultPostgresVersion()),
+		postgres.WithDatabase("targetdb"),
+		postgres.WithUsername("user"),
+		postgres.WithPassword("password"),
+		testcontainers.WithWaitStrategy(
+			wait.ForLog("database system is ready to accept connections").WithOccurrence(2),
+			wait.ForListeningPort("5432/tcp"),
+		),
+	)
+	testcontainers.CleanupContainer(t, targetContainer)
+	require.NoError(t, err)
+
+	sourceConnStr, err := sourceContainer.ConnectionString(ctx, "sslmode=disable")
+	require.NoError(t, err)
+
+	targetConnStr, err := targetContainer.ConnectionString(ctx, "sslmode=disable")
+	require.NoError(t, err)
+
+	receiver := helpers.NewWebhookReceiver(t, "webhook-secret")
+	notifier := webhook.New(&config.Config{WebhookURLs: []string{receiver.URL}, WebhookSecret: "webhook-secret"}, "test-run", logging.Discard())
+	runCtx := webhook.NewContext(ctx, notifier)
+
+	cfg := &config.Config{
+		SourceDatabaseURL: sourceConnStr,
+		TargetDatabaseURL: targetConnStr,
+		ParallelJobs:      1,
+		NoOwner:           true,
+		NoACL:             true,
+		ValidateAfter:     true,
+	}
+	require.NoError(t, migration.Migrate(runCtx, cfg, logging.Discard()))
+
+	targetConn, err := pgx.Connect(ctx, targetConnStr)
+	require.NoError(t, err)
+	defer targetConn.Close(ctx)
+	_, err = targetConn.Exec(ctx, "INSERT INTO users (name, email) VALUES ('Extra User', 'extra@example.com')")
+	require.NoError(t, err)
+
+	err = validation.ValidateAllTablesFromURLs(runCtx, sourceConnStr, targetConnStr, logging.Discard())
+	require.Error(t, err)
+
+	closeCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	notifier.Close(closeCtx)
+
+	require.Zero(t, receiver.InvalidSignatures(), "Every payload should carry a valid signature")
+	payloads := receiver.Payloads()
+	require.Len(t, payloads, 3, "The delivery rejected with 503 should have been retried")
+
+	started, completed, failed := payloads[0], payloads[1], payloads[2]
+	require.Equal(t, webhook.EventMigrationStarted, started.Event)
+	require.Equal(t, "test-run", started.RunID)
+
+	require.Equal(t, webhook.EventMigrationCompleted, completed.Event)
+	require.Equal(t, "test-run", completed.RunID)
+	require.Equal(t, metrics.PhaseValidate, completed.Phase, "migration.completed should be sent after validation")
+	require.Greater(t, completed.DumpBytes, int64(0))
+	require.Empty(t, completed.Error)
+	require.Equal(t, &webhook.ValidationSummary{Tables: 2, TablesValidated: 2}, completed.Validation)
+
+	require.Equal(t, webhook.EventValidationFailed, failed.Event)
+	require.Equal(t, metrics.PhaseValidate, failed.Phase)
+	require.NotNil(t, failed.Validation)
+	require.Equal(t, "users", failed.Validation.FailedTable)
+	require.Less(t, failed.Validation.TablesValidated, failed.Validation.Tables)
+	require.Contains(t, failed.Error, "users")
+}
+
+func TestServeAPI(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	sourceContainer, err := postgres.Run(
+		ctx,
+		getPostgresImage(getDefaultPostgresVersion()),
+		postgres.WithDatabase("sourcedb"),
+		postgres.WithUsername("user"),
+		postgres.WithPassword("password"),
+		postgres.WithInitScripts("testdata/init-source.sql"),
+		testcontainers.WithWaitStrategy(
+			wait.ForLog("database system is ready to accept connections").WithOccurrence(2),
+			wait.ForListeningPort("5432/tcp"),
+		),
+	)
+	testcontainers.CleanupContainer(t, sourceContainer)
+	require.NoError(t, err)
+
+	targetContainer, err := postgres.Run(
+		ctx,
+		getPostgresImage(getDefaultPostgresVersion()),
+		postgres.WithDatabase("targetdb"),
+		postgres.WithUsername("user"),
+		postgres.WithPassword("password"),
+		testcontainers.WithWaitStrategy(
+			wait.ForLog("database system is ready to accept connections").WithOccurrence(2),
+			wait.ForListeningPort("5432/tcp"),
+		),
+	)
+	testcontainers.CleanupContainer(t, targetContainer)
+	require.NoError(t, err)
+
+	sourceConnStr, err := sourceContainer.ConnectionString(ctx, "sslmode=disable")
+	require.NoError(t, err)
+
+	targetConnStr, err := targetContainer.ConnectionString(ctx, "sslmode=disable")
+	require.NoError(t, err)
+
+	start := func(cfg *config.Config) *httptest.Server {
+		srv := server.New(cfg, logging.Discard())
+		runCtx, stop := context.WithCancel(ctx)
+		stopped := make(chan struct{})
+		go func() {
+			srv.Run(runCtx)
+			close(stopped)
+		}()
+		t.Cleanup(func() {
+			stop()
+			<-stopped
+		})
+
+		api := httptest.NewServer(srv.Handler())
+		t.Cleanup(api.Close)
+		return api
+	}
+	api := start(&config.Config{ServeConcurrency: 1, ServeToken: "api-token", ServeJobRetention: time.Hour, ServeJobLogLines: 10000})
+
+	call := func(method, path string, body any) (int, []byte) {
+		var reader io.Reader
+		if body != nil {
+			encoded, err := json.Marshal(body)
+			require.NoError(t, err)
+			reader = bytes.NewReader(encoded)
+		}
+		req, err := http.NewRequest(method, api.URL+path, reader)
+		require.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer api-token")
+		resp, err := api.Client().Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		data, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp.StatusCode, data
+	}
+	submit := func(request server.JobRequest) server.JobStatus {
+		status, body := call(http.MethodPost, "/jobs", request)
+		require.Equal(t, http.StatusAccepted, status, string(body))
+		var job server.JobStatus
+		require.NoError(t, json.Unmarshal(body, &job))
+		return job
+	}
+	waitFor := func(id string) server.JobStatus {
+		var job server.JobStatus
+		require.Eventually(t, func() bool {
+			status, body := call(http.MethodGet, "/jobs/"+id, nil)
+			require.Equal(t, http.StatusOK, status)
+			require.NoError(t, json.Unmarshal(body, &job))
+			return job.FinishedAt != nil
+		}, 3*time.Minute, 500*time.Millisecond)
+		return job
+	}
+
+	resp, err := api.Client().Get(api.URL + "/jobs")
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode, "Requests without the token should be rejected")
+
+	status, body := call(http.MethodPost, "/jobs", server.JobRequest{Settings: map[string]any{"SOURCE_DB": sourceConnStr}})
+	require.Equal(t, http.StatusBadRequest, status)
+	require.Contains(t, string(body), "unknown settings: SOURCE_DB")
+
+	status, body = call(http.MethodPost, "/jobs", server.JobRequest{Settings: map[string]any{"SOURCE_DATABASE_URL": sourceConnStr, "TARGET_DATABASE_URL": targetConnStr, "WORK_DIR": "/etc"}})
+	require.Equal(t, http.StatusBadRequest, status)
+	require.Contains(t, string(body), "settings that only the server's environment may set: WORK_DIR")
+
+	status, body = call(http.MethodPost, "/jobs", server.JobRequest{Settings: map[string]any{"TARGET_DATABASE_URL": targetConnStr}})
+	require.Equal(t, http.StatusBadRequest, status, "A job giving its own target should not inherit the server's source")
+	require.Contains(t, string(body), "SOURCE_DATABASE_URL is required")
+
+	settings := map[string]any{
+		"SOURCE_DATABASE_URL": sourceConnStr,
+		"TARGET_DATABASE_URL": targetConnStr,
+		"NO_OWNER":            true,
+		"NO_ACL":              true,
+		"PARALLEL_JOBS":       2,
+	}
+	migrate := submit(server.JobRequest{Type: server.JobMigrate, Settings: settings})
+	validate := submit(server.JobRequest{Type: server.JobValidate, Settings: settings})
+	cancelled := submit(server.JobRequest{Type: server.JobMigrate, Settings: settings})
+
+	// With one worker the third job is still queued behind the others
+	status, body = call(http.MethodPost, "/jobs/"+cancelled.ID+"/cancel", nil)
+	require.Equal(t, http.StatusAccepted, status, string(body))
+
+	migrated := waitFor(migrate.ID)
+	require.Equal(t, server.StatusSucceeded, migrated.Status, migrated.Error)
+	require.Equal(t, "validate", migrated.Phase, "Post-migration validation should be the last phase")
+
+	job := waitFor(validate.ID)
+	require.Equal(t, server.StatusSucceeded, job.Status, job.Error)
+	require.False(t, job.StartedAt.Before(*migrated.FinishedAt), "Jobs should run one at a time")
+
+	job = waitFor(cancelled.ID)
+	require.Equal(t, server.StatusCancelled, job.Status)
+	require.Nil(t, job.StartedAt, "A job cancelled in the queue should never start")
+
+	status, body = call(http.MethodGet, "/jobs/"+migrate.ID+"/logs", nil)
+	require.Equal(t, http.StatusOK, status)
+	var messages []string
+	for _, line := range bytes.Split(bytes.TrimSpace(body), []byte("\n")) {
+		var record map[string]any
+		require.NoError(t, json.Unmarshal(line, &record))
+		require.Equal(t, migrate.ID, record["run_id"], "Every log record should carry the job ID")
+		messages = append(messages, record["msg"].(string))
+	}
+	require.Equal(t, "command started", messages[0])
+	require.Equal(t, "command completed", messages[len(messages)-1])
+	require.Contains(t, messages, "all tables validated")
+
+	status, _ = call(http.MethodPost, "/jobs/"+migrate.ID+"/cancel", nil)
+	require.Equal(t, http.StatusConflict, status, "A finished job cannot be cancelled")
+
+	status, _ = call(http.MethodGet, "/jobs/unknown", nil)
+	require.Equal(t, http.StatusNotFound, status)
+
+	status, body = call(http.MethodGet, "/jobs", nil)
+	require.Equal(t, http.StatusOK, status)
+	var jobs []server.JobStatus
+	require.NoError(t, json.Unmarshal(body, &jobs))
+	require.Len(t, jobs, 3)
+
+	exposition := httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(exposition, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	require.Contains(t, exposition.Body.String(), `postgres_migrator_phase{database="",phase="completed",run_id="`+migrate.ID+`"} 1`, "Each job should report its own metrics")
+	require.Contains(t, exposition.Body.String(), `postgres_migrator_tables_restored_total{database="",run_id="`+validate.ID+`"} 0`)
+
+	// A server keeping few log records per job and finished jobs only briefly
+	api = start(&config.Config{ServeConcurrency: 1, ServeToken: "api-token", ServeJobRetention: 2 * time.Second, ServeJobLogLines: 3})
+	short := waitFor(submit(server.JobRequest{Type: server.JobValidate, Settings: settings}).ID)
+	require.Equal(t, server.StatusSucceeded, short.Status, short.Error)
+
+	status, body = call(http.MethodGet, "/jobs/"+short.ID+"/logs", nil)
+	require.Equal(t, http.StatusOK, status)
+	lines := bytes.Split(bytes.TrimSpace(body), []byte("\n"))
+	require.Len(t, lines, 4, "Three records and the truncation notice should be kept")
+	var last map[string]any
+	require.NoError(t, json.Unmarshal(lines[3], &last))
+	require.Equal(t, "job log truncated", last["msg"])
+
+	require.Eventually(t, func() bool {
+		status, _ := call(http.MethodGet, "/jobs/"+short.ID, nil)
+		return status == http.StatusNotFound
+	}, 30*time.Second, 500*time.Millisecond, "A finished job should be dropped after SERVE_JOB_RETENTION")
+
+	exposition = httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(exposition, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	require.NotContains(t, exposition.Body.String(), short.ID, "A dropped job's metrics should be removed")
+}
+
+func TestClusterMigration(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	sourceContainer, err := postgres.Run(
+		ctx,
+		getPostgresImage(getDefaultPostgresVersion()),
+		postgres.WithDatabase("sourcedb"),
+		postgres.WithUsername("user"),
+		postgres.WithPassword("password"),
+		postgres.WithInitScripts("testdata/init-source.sql"),
+		testcontainers.WithWaitStrategy(
+			wait.ForLog("database system is ready to accept connections").WithOccurrence(2),
+			wait.ForListeningPort("5432/tcp"),
+		),
+	)
+	testcontainers.CleanupContainer(t, sourceContainer)
+	require.NoError(t, err)
+
+	targetContainer, err := postgres.Run(
+		ctx,
+		getPostgresImage(getDefaultPostgresVersion()),
+		postgres.WithDatabase("targetdb"),
+		postgres.WithUsername("user"),
+		postgres.WithPassword("password"),
+		testcontainers.WithWaitStrategy(
+			wait.ForLog("database system is ready to accept connections").WithOccurrence(2),
+			wait.ForListeningPort("5432/tcp"),
+		),
+	)
+	testcontainers.CleanupContainer(t, targetContainer)
+	require.NoError(t, err)
+
+	sourceConnStr, err := sourceContainer.ConnectionString(ctx, "sslmode=disable")
+	require.NoError(t, err)
+
+	targetConnStr, err := targetContainer.ConnectionString(ctx, "sslmode=disable")
+	require.NoError(t, err)
+
+	sourceServer, err := database.DatabaseURL(sourceConnStr, "postgres")
+	require.NoError(t, err)
+	targetServer, err := database.DatabaseURL(targetConnStr, "postgres")
+	require.NoError(t, err)
+
+	sourceConn, err := pgx.Connect(ctx, sourceServer)
+	require.NoError(t, err)
+	defer sourceConn.Close(ctx)
+	for _, statement := range []string{
+		"CREATE ROLE inventory_owner",
+		"CREATE DATABASE inventory OWNER inventory_owner TEMPLATE template0 ENCODING 'UTF8' LC_COLLATE 'C' LC_CTYPE 'C'",
+		"CREATE DATABASE scratch",
+	} {
+		_, err = sourceConn.Exec(ctx, statement)
+		require.NoError(t, err)
+	}
+
+	inventoryURL, err := database.DatabaseURL(sourceConnStr, "inventory")
+	require.NoError(t, err)
+	inventoryConn, err := pgx.Connect(ctx, inventoryURL)
+	require.NoError(t, err)
+	_, err = inventoryConn.Exec(ctx, "CREATE TABLE items (id SERIAL PRIMARY KEY, name TEXT NOT NULL); INSERT INTO items (name) VALUES ('bolt'), ('nut')")
+	require.NoError(t, err)
+	inventoryConn.Close(ctx)
+
+	targetConn, err := pgx.Connect(ctx, targetServer)
+	require.NoError(t, err)
+	defer targetConn.Close(ctx)
+	_, err = targetConn.Exec(ctx, "CREATE ROLE inventory_owner")
+	require.NoError(t, err)
+
+	cfg := &config.Config{
+		SourceDatabaseURL: sourceServer,
+		TargetDatabaseURL: targetServer,
+		ParallelJobs:      1,
+		NoOwner:           true,
+		NoACL:             true,
+		ValidateAfter:     true,
+		ParallelDatabases: 2,
+		ExcludeDatabases:  []string{"scr*"},
+	}
+	require.NoError(t, cfg.ValidateCluster())
+
+	receiver := helpers.NewWebhookReceiver(t, "webhook-secret")
+	notifier := webhook.New(&config.Config{WebhookURLs: []string{receiver.URL}, WebhookSecret: "webhook-secret"}, "cluster-run", logging.Discard())
+	recorder := metrics.New("cluster-run")
+	defer recorder.Close()
+	runCtx := metrics.NewContext(webhook.NewContext(ctx, notifier), recorder)
+
+	var logs bytes.Buffer
+	logger := logging.New(&logs, &config.Config{LogFormat: config.LogFormatJSON})
+	require.NoError(t, migration.Cluster(runCtx, cfg, logger))
+
+	closeCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	notifier.Close(closeCtx)
+
+	var owner, collate string
+	err = targetConn.QueryRow(ctx, `
+		SELECT r.rolname, d.datcollate
+		FROM pg_database d JOIN pg_roles r ON r.oid = d.datdba
+		WHERE d.datname = 'inventory'`).Scan(&owner, &collate)
+	require.NoError(t, err, "inventory should have been created on the target")
+	require.Equal(t, "inventory_owner", owner)
+	require.Equal(t, "C", collate)
+
+	exists, err := database.DatabaseExists(ctx, targetServer, "scratch")
+	require.NoError(t, err)
+	require.False(t, exists, "Excluded databases should not be created")
+
+	for name, table := range map[string]string{"inventory": "items", "sourcedb": "users"} {
+		url, err := database.DatabaseURL(targetConnStr, name)
+		require.NoError(t, err)
+		conn, err := pgx.Connect(ctx, url)
+		require.NoError(t, err)
+		var count int
+		require.NoError(t, conn.QueryRow(ctx, "SELECT COUNT(*) FROM "+table).Scan(&count))
+		conn.Close(ctx)
+		require.Greater(t, count, 1, "%s.%s should have been migrated", name, table)
+	}
+
+	var summary map[string]any
+	for _, line := range bytes.Split(bytes.TrimSpace(logs.Bytes()), []byte("\n")) {
+		var record map[string]any
+		require.NoError(t, json.Unmarshal(line, &record))
+		if record["msg"] == "cluster migration summary" {
+			summary = record
+		}
+	}
+	require.NotNil(t, summary, "A cluster-wide summary should be logged")
+	require.Equal(t, 3.0, summary["databases"], "postgres, sourcedb and inventory should be migrated")
+	require.Equal(t, 3.0, summary["succeeded"])
+	require.Equal(t, 0.0, summary["failed"])
+
+	// Databases migrated in parallel must report separately
+	events := make(map[string][]string)
+	for _, payload := range receiver.Payloads() {
+		require.Equal(t, "cluster-run", payload.RunID)
+		events[payload.Database] = append(events[payload.Database], payload.Event)
+	}
+	for _, name := range []string{"postgres", "sourcedb", "inventory"} {
+		require.Equal(t, []string{webhook.EventMigrationStarted, webhook.EventMigrationCompleted}, events[name], "Events of %s", name)
+	}
+	require.Len(t, events, 3, "Every event should name its database")
+
+	server := httptest.NewServer(metrics.Handler())
+	defer server.Close()
+	resp, err := server.Client().Get(server.URL)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	for _, name := range []string{"postgres", "sourcedb", "inventory"} {
+		require.Contains(t, string(body), `postgres_migrator_phase{database="`+name+`",phase="completed",run_id="cluster-run"} 1`)
+	}
+	require.Contains(t, string(body), `postgres_migrator_tables_restored_total{database="inventory",run_id="cluster-run"} 1`)
+}
+
+func TestGlobalsMigration(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	sourceContainer, err := postgres.Run(
+		ctx,
+		getPostgresImage(getDefaultPostgresVersion()),
+		postgres.WithDatabase("sourcedb"),
+		postgres.WithUsername("sourceuser"),
+		postgres.WithPassword("password"),
+		postgres.WithInitScripts("testdata/init-source.sql"),
+		testcontainers.WithWaitStrategy(
+			wait.ForLog("database system is ready to accept connections").WithOccurrence(2),
+			wait.ForListeningPort("5432/tcp"),
+		),
+	)
+	testcontainers.CleanupContainer(t, sourceContainer)
+	require.NoError(t, err)
+
+	targetContainer, err := postgres.Run(
+		ctx,
+		getPostgresImage(getDefaultPostgresVersion()),
+		postgres.WithDatabase("targetdb"),
+		postgres.WithUsername("targetuser"),
+		postgres.WithPassword("password"),
+		testcontainers.WithWaitStrategy(
+			wait.ForLog("database system is ready to accept connections").WithOccurrence(2),
+			wait.ForListeningPort("5432/tcp"),
+		),
+	)
+	testcontainers.CleanupContainer(t, targetContainer)
+	require.NoError(t, err)
+
+	sourceConnStr, err := sourceContainer.ConnectionString(ctx, "sslmode=disable")
+	require.NoError(t, err)
+
+	targetConnStr, err := targetContainer.ConnectionString(ctx, "sslmode=disable")
+	require.NoError(t, err)
+
+	sourceConn, err := pgx.Connect(ctx, sourceConnStr)
+	require.NoError(t, err)
+	defer sourceConn.Close(ctx)
+	for _, statement := range []string{
+		"CREATE ROLE app_reader NOLOGIN",
+		"CREATE ROLE app_user LOGIN PASSWORD 'secret' CREATEDB",
+		"CREATE ROLE app_admin LOGIN REPLICATION BYPASSRLS",
+		"GRANT app_reader TO app_user",
+		"ALTER ROLE app_user SET search_path TO app, public",
+		"ALTER ROLE app_user IN DATABASE sourcedb SET work_mem TO '64MB'",
+		"ALTER TABLE users OWNER TO app_user",
+	} {
+		_, err = sourceConn.Exec(ctx, statement)
+		require.NoError(t, err)
+	}
+
+	cfg := &config.Config{
+		SourceDatabaseURL:    sourceConnStr,
+		TargetDatabaseURL:    targetConnStr,
+		ParallelJobs:         1,
+		NoOwner:              false,
+		NoACL:                true,
+		MigrateGlobals:       true,
+		GlobalsSkipSuperuser: true,
+		GlobalsPasswords:     config.GlobalsPasswordsCopy,
+	}
+	_, err = migration.Run(ctx, cfg, logging.Discard())
+	require.NoError(t, err, "Restoring with owners should succeed once the roles exist")
+
+	targetConn, err := pgx.Connect(ctx, targetConnStr)
+	require.NoError(t, err)
+	defer targetConn.Close(ctx)
+
+	var replication, bypassRLS, createDB bool
+	err = targetConn.QueryRow(ctx, "SELECT rolreplication, rolbypassrls FROM pg_roles WHERE rolname = 'app_admin'").Scan(&replication, &bypassRLS)
+	require.NoError(t, err, "app_admin should have been created")
+	require.False(t, replication, "Superuser-only attributes should be skipped")
+	require.False(t, bypassRLS, "Superuser-only attributes should be skipped")
+
+	err = targetConn.QueryRow(ctx, "SELECT rolcreatedb FROM pg_roles WHERE rolname = 'app_user'").Scan(&createDB)
+	require.NoError(t, err, "app_user should have been created")
+	require.True(t, createDB)
+
+	var member bool
+	err = targetConn.QueryRow(ctx, "SELECT pg_has_role('app_user', 'app_reader', 'MEMBER')").Scan(&member)
+	require.NoError(t, err)
+	require.True(t, member, "Role memberships should be migrated")
+
+	var settings []string
+	err = targetConn.QueryRow(ctx, `
+		SELECT array_agg(unnest ORDER BY unnest)
+		FROM pg_db_role_setting s
+		JOIN pg_roles r ON r.oid = s.setrole
+		LEFT JOIN pg_database d ON d.oid = s.setdatabase,
+		unnest(s.setconfig)
+		WHERE r.rolname = 'app_user' AND (s.setdatabase = 0 OR d.datname = 'targetdb')`).Scan(&settings)
+	require.NoError(t, err)
+	require.Equal(t, []string{"search_path=app, public", "work_mem=64MB"}, settings, "Role settings should follow the source database to the target database")
+
+	var owner string
+	err = targetConn.QueryRow(ctx, "SELECT tableowner FROM pg_tables WHERE schemaname = 'public' AND tablename = 'users'").Scan(&owner)
+	require.NoError(t, err)
+	require.Equal(t, "app_user", owner)
+
+	loginConfig, err := pgx.ParseConfig(targetConnStr)
+	require.NoError(t, err)
+	loginConfig.User = "app_user"
+	loginConfig.Password = "secret"
+	loginConn, err := pgx.ConnectConfig(ctx, loginConfig)
+	require.NoError(t, err, "Copied password hashes should let app_user log in")
+	loginConn.Close(ctx)
+
+	// The target now has tables, so a second run only validates and must not touch roles
+	_, err = sourceConn.Exec(ctx, "CREATE ROLE late_user LOGIN PASSWORD 'late-secret'")
+	require.NoError(t, err)
+	skipped, err := migration.Run(ctx, cfg, logging.Discard())
+	require.NoError(t, err)
+	require.True(t, skipped)
+	var exists bool
+	err = targetConn.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM pg_roles WHERE rolname = 'late_user')").Scan(&exists)
+	require.NoError(t, err)
+	require.False(t, exists, "A run that skips the migration should not create roles")
+
+	_, err = targetConn.Exec(ctx, "CREATE DATABASE placeholderdb")
+	require.NoError(t, err)
+	placeholderURL, err := database.DatabaseURL(targetConnStr, "placeholderdb")
+	require.NoError(t, err)
+
+	var logs bytes.Buffer
+	placeholderCfg := *cfg
+	placeholderCfg.TargetDatabaseURL = placeholderURL
+	placeholderCfg.GlobalsPasswords = config.GlobalsPasswordsPlaceholder
+	_, err = migration.Run(ctx, &placeholderCfg, logging.New(&logs, &config.Config{LogFormat: config.LogFormatJSON}))
+	require.NoError(t, err)
+
+	var placeholderRoles []any
+	for _, line := range bytes.Split(bytes.TrimSpace(logs.Bytes()), []byte("\n")) {
+		var record map[string]any
+		require.NoError(t, json.Unmarshal(line, &record))
+		if record["msg"] == "login roles created with a random password, set their passwords before they connect" {
+			placeholderRoles = record["roles"].([]any)
+		}
+	}
+	require.Equal(t, []any{"late_user"}, placeholderRoles, "Only the new login role should get a placeholder, existing roles are kept")
+
+	var hasPassword bool
+	err = targetConn.QueryRow(ctx, "SELECT rolpassword IS NOT NULL FROM pg_authid WHERE rolname = 'late_user'").Scan(&hasPassword)
+	require.NoError(t, err)
+	require.True(t, hasPassword, "The placeholder password should be set")
+
+	loginConfig.User = "late_user"
+	loginConfig.Password = "late-secret"
+	_, err = pgx.ConnectConfig(ctx, loginConfig)
+	require.Error(t, err, "The source password should not have been copied")
+}